@@ -0,0 +1,64 @@
+// Package audio inspects MP3 and Ogg files without decoding them, finding how long they play for and how they were
+// encoded from their MPEG frame headers and Ogg pages alone.
+//
+// It only reads as far as it needs to, and has no dependencies outside the standard library.
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalid     = errors.New("invalid audio")
+	ErrUnsupported = errors.New("unsupported audio")
+)
+
+// Format is the codec a file's audio is encoded with.
+type Format string
+
+const (
+	MP3    Format = "mp3"
+	Opus   Format = "opus"
+	Vorbis Format = "vorbis"
+)
+
+// Info describes a file's audio.
+type Info struct {
+	Format Format
+	// Duration is how long the audio plays for, less any padding the encoder declared.
+	Duration time.Duration
+	// Bitrate is the average bits per second of the encoded audio, not counting tags or container headers.
+	Bitrate int
+	// SampleRate is the samples per second the audio is decoded at, which for Opus is always 48kHz.
+	SampleRate int
+	Channels   int
+}
+
+// Inspect inspects an MP3 or Ogg file, telling which it is from its first bytes.
+func Inspect(data []byte) (Info, error) {
+	if bytes.HasPrefix(data, []byte(oggCapture)) {
+		return InspectOgg(data)
+	}
+	if bytes.HasPrefix(data, []byte(id3Magic)) || findFrame(data, 0) >= 0 {
+		return InspectMP3(data)
+	}
+	return Info{}, fmt.Errorf("%w: neither an MP3 nor an Ogg file", ErrInvalid)
+}
+
+// samplesDuration is how long samples last at sampleRate.
+func samplesDuration(samples int64, sampleRate int) time.Duration {
+	seconds := samples / int64(sampleRate)
+	rest := samples % int64(sampleRate)
+	return time.Duration(seconds)*time.Second + time.Duration(rest)*time.Second/time.Duration(sampleRate)
+}
+
+// bitrate is the average bits per second of n bytes lasting d.
+func bitrate(n int64, d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(float64(n*8) / d.Seconds())
+}
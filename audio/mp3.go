@@ -0,0 +1,308 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	id3Magic = "ID3"
+	// id3HeaderSize is the size of an ID3v2 tag's header, and of its footer if it has one.
+	id3HeaderSize = 10
+	// frameHeaderSize is the size of an MPEG audio frame's header, not counting its CRC.
+	frameHeaderSize = 4
+	id3v1Magic      = "TAG"
+	// id3v1Size is the size of an ID3v1 tag, which is always at the very end of a file.
+	id3v1Size = 128
+	apeMagic  = "APETAGEX"
+	// apeFooterSize is the size of an APE tag's footer, and of its header if it has one.
+	apeFooterSize = 32
+)
+
+// bitrates are the bitrates in kbps of each bitrate index, by whether the frame is MPEG-1 and then by layer.
+// Index 0 means a free format bitrate, which isn't supported, and index 15 is invalid.
+var bitrates = [2][4][16]int{
+	// MPEG-2 and MPEG-2.5.
+	{
+		1: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		3: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	},
+	// MPEG-1.
+	{
+		1: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		3: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	},
+}
+
+// frameHeader is an MPEG audio frame's header.
+type frameHeader struct {
+	// mpeg1 is whether the frame is MPEG-1, rather than MPEG-2 or MPEG-2.5, which halve the samples of layer III.
+	mpeg1 bool
+	// layer is the layer's index in the header, which is 3 for layer I, 2 for layer II and 1 for layer III.
+	layer      int
+	bitrate    int
+	sampleRate int
+	padding    bool
+	crc        bool
+	channels   int
+}
+
+// parseFrameHeader parses the frame header at the start of b, if there is one.
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if len(b) < frameHeaderSize || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return frameHeader{}, false
+	}
+	version := b[1] >> 3 & 0x3
+	layer := int(b[1] >> 1 & 0x3)
+	bitrateIndex := b[2] >> 4
+	sampleRateIndex := b[2] >> 2 & 0x3
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return frameHeader{}, false
+	}
+	h := frameHeader{
+		mpeg1:      version == 3,
+		layer:      layer,
+		sampleRate: [3]int{44100, 48000, 32000}[sampleRateIndex],
+		padding:    b[2]&0x2 != 0,
+		crc:        b[1]&0x1 == 0,
+		channels:   2,
+	}
+	mpeg1 := 0
+	if h.mpeg1 {
+		mpeg1 = 1
+	}
+	h.bitrate = bitrates[mpeg1][layer][bitrateIndex] * 1000
+	switch version {
+	case 2:
+		h.sampleRate /= 2
+	case 0:
+		h.sampleRate /= 4
+	}
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+	return h, true
+}
+
+// samples is how many samples per channel the frame decodes to.
+func (h frameHeader) samples() int {
+	switch {
+	case h.layer == 3:
+		return 384
+	case h.layer == 1 && !h.mpeg1:
+		return 576
+	}
+	return 1152
+}
+
+// size is the size of the frame, including its header.
+func (h frameHeader) size() int {
+	padding := 0
+	if h.padding {
+		padding = 1
+	}
+	if h.layer == 3 {
+		return (12*h.bitrate/h.sampleRate + padding) * 4
+	}
+	return h.samples()/8*h.bitrate/h.sampleRate + padding
+}
+
+// sideInfoSize is the size of the layer III side information which follows the header and CRC.
+func (h frameHeader) sideInfoSize() int {
+	switch {
+	case h.mpeg1 && h.channels == 1:
+		return 17
+	case h.mpeg1:
+		return 32
+	case h.channels == 1:
+		return 9
+	}
+	return 17
+}
+
+// findFrame finds the first frame at or after from, or -1 if there isn't one.
+// To tell frames apart from bytes which happen to look like a header, a frame must be followed by another like it or
+// by the end of data.
+func findFrame(data []byte, from int) int {
+	for i := from; i+frameHeaderSize <= len(data); i++ {
+		h, ok := parseFrameHeader(data[i:])
+		if !ok {
+			continue
+		}
+		next := i + h.size()
+		if next == len(data) {
+			return i
+		}
+		if n, ok := parseFrameHeader(data[min(next, len(data)):]); ok && n.mpeg1 == h.mpeg1 && n.layer == h.layer && n.sampleRate == h.sampleRate {
+			return i
+		}
+	}
+	return -1
+}
+
+// skipID3 returns the size of the ID3v2 tags at the start of data.
+func skipID3(data []byte) (int, error) {
+	offset := 0
+	for bytes.HasPrefix(data[offset:], []byte(id3Magic)) {
+		tag := data[offset:]
+		if len(tag) < id3HeaderSize {
+			return 0, fmt.Errorf("%w: truncated ID3 tag", ErrInvalid)
+		}
+		// The size is syncsafe, with only the low 7 bits of each byte used.
+		size := int(tag[6]&0x7f)<<21 | int(tag[7]&0x7f)<<14 | int(tag[8]&0x7f)<<7 | int(tag[9]&0x7f)
+		size += id3HeaderSize
+		if tag[5]&0x10 != 0 {
+			size += id3HeaderSize
+		}
+		if size > len(tag) {
+			return 0, fmt.Errorf("%w: truncated ID3 tag", ErrInvalid)
+		}
+		offset += size
+	}
+	return offset, nil
+}
+
+// trailingTags returns the size of the APE and ID3v1 tags at the end of data, which follow the audio frames.
+func trailingTags(data []byte) int {
+	end := len(data)
+	if end >= id3v1Size && string(data[end-id3v1Size:end-id3v1Size+len(id3v1Magic)]) == id3v1Magic {
+		end -= id3v1Size
+	}
+	if end >= apeFooterSize && string(data[end-apeFooterSize:end-apeFooterSize+len(apeMagic)]) == apeMagic {
+		footer := data[end-apeFooterSize : end]
+		// The size counts the items and the footer, and the header too if the flags say there is one.
+		size := int64(binary.LittleEndian.Uint32(footer[12:16]))
+		if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+			size += apeFooterSize
+		}
+		if size >= apeFooterSize && size <= int64(end) {
+			end -= int(size)
+		}
+	}
+	return len(data) - end
+}
+
+// encoderGap reads how many samples the encoder added to the start and end of the audio from the Xing or Info tag in
+// frame, which must be layer III, along with whether frame is a tag rather than audio.
+// Only LAME and FFmpeg write the gap, after the Xing tag, so other encoders' tags report no gap.
+func encoderGap(h frameHeader, frame []byte) (delay, padding int, isTag bool) {
+	offset := frameHeaderSize
+	if h.crc {
+		offset += 2
+	}
+	if len(frame) >= frameHeaderSize+32+4 && string(frame[frameHeaderSize+32:frameHeaderSize+36]) == "VBRI" {
+		return 0, 0, true
+	}
+	offset += h.sideInfoSize()
+	if len(frame) < offset+8 {
+		return 0, 0, false
+	}
+	if magic := string(frame[offset : offset+4]); magic != "Xing" && magic != "Info" {
+		return 0, 0, false
+	}
+	flags := binary.BigEndian.Uint32(frame[offset+4:])
+	offset += 8
+	// The frame count, byte count, table of contents and quality are each only there if their flag is set.
+	for flag, size := range []int{4, 4, 100, 4} {
+		if flags&(1<<flag) != 0 {
+			offset += size
+		}
+	}
+	// The LAME tag starts with a 9 byte encoder version, and has the gap 21 bytes in.
+	if len(frame) < offset+24 {
+		return 0, 0, true
+	}
+	switch string(frame[offset : offset+4]) {
+	case "LAME", "Lavf", "Lavc":
+	default:
+		return 0, 0, true
+	}
+	gap := frame[offset+21 : offset+24]
+	return int(gap[0])<<4 | int(gap[1])>>4, int(gap[1]&0xf)<<8 | int(gap[2]), true
+}
+
+// mp3Stream is the audio frames of an MP3 file.
+type mp3Stream struct {
+	// header is the first audio frame's header, which every other frame matches but for its bitrate and padding.
+	header frameHeader
+	frames [][]byte
+	// delay and padding are how many samples the encoder added to the start and end, if its tag says.
+	delay, padding int
+}
+
+// readMP3 finds every audio frame in an MP3 file, skipping tags before and after them, and a final frame which was cut
+// short. Every frame is counted since the frame count in a Xing tag can't be relied on. Bytes between frames which
+// aren't a frame, such as a frame whose header is corrupt, are skipped until the next frame.
+func readMP3(data []byte) (mp3Stream, error) {
+	offset, err := skipID3(data)
+	if err != nil {
+		return mp3Stream{}, err
+	}
+	data = data[:max(len(data)-trailingTags(data), offset)]
+	start := findFrame(data, offset)
+	if start < 0 {
+		return mp3Stream{}, fmt.Errorf("%w: no MPEG audio frames", ErrInvalid)
+	}
+	s := mp3Stream{}
+	s.header, _ = parseFrameHeader(data[start:])
+	pos := start
+	if s.header.layer == 1 {
+		var isTag bool
+		if s.delay, s.padding, isTag = encoderGap(s.header, data[start:min(start+s.header.size(), len(data))]); isTag {
+			pos += s.header.size()
+		}
+	}
+	for pos < len(data) {
+		h, ok := parseFrameHeader(data[pos:])
+		if !ok {
+			next := findFrame(data, pos+1)
+			if next < 0 {
+				return mp3Stream{}, fmt.Errorf("%w: %d bytes after the last MPEG audio frame", ErrInvalid, len(data)-pos)
+			}
+			pos = next
+			continue
+		}
+		if h.mpeg1 != s.header.mpeg1 || h.layer != s.header.layer {
+			return mp3Stream{}, fmt.Errorf("%w: MPEG version or layer changes part way through", ErrUnsupported)
+		}
+		if h.sampleRate != s.header.sampleRate {
+			return mp3Stream{}, fmt.Errorf("%w: sample rate changes part way through", ErrUnsupported)
+		}
+		if pos+h.size() > len(data) {
+			break
+		}
+		s.frames = append(s.frames, data[pos:pos+h.size()])
+		pos += h.size()
+	}
+	if len(s.frames) == 0 {
+		return mp3Stream{}, fmt.Errorf("%w: no MPEG audio frames", ErrInvalid)
+	}
+	if s.header, _ = parseFrameHeader(s.frames[0]); s.delay+s.padding >= len(s.frames)*s.header.samples() {
+		// The gap can't be right if it is longer than the audio.
+		s.delay, s.padding = 0, 0
+	}
+	return s, nil
+}
+
+// InspectMP3 inspects an MP3 file.
+func InspectMP3(data []byte) (Info, error) {
+	s, err := readMP3(data)
+	if err != nil {
+		return Info{}, err
+	}
+	var audioBytes int64
+	for _, frame := range s.frames {
+		audioBytes += int64(len(frame))
+	}
+	samples := int64(len(s.frames) * s.header.samples())
+	return Info{
+		Format:     MP3,
+		Duration:   samplesDuration(samples-int64(s.delay+s.padding), s.header.sampleRate),
+		Bitrate:    bitrate(audioBytes, samplesDuration(samples, s.header.sampleRate)),
+		SampleRate: s.header.sampleRate,
+		Channels:   s.header.channels,
+	}, nil
+}
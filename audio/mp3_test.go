@@ -0,0 +1,291 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+	layer3 = 1
+	layer2 = 2
+	layer1 = 3
+)
+
+// header builds a frame header without a CRC.
+func header(version, layer, bitrateIndex, sampleRateIndex int, padding, mono bool) []byte {
+	h := []byte{0xff, byte(0xe0 | version<<3 | layer<<1 | 1), byte(bitrateIndex<<4 | sampleRateIndex<<2), 0}
+	if padding {
+		h[2] |= 0x2
+	}
+	if mono {
+		h[3] = 3 << 6
+	}
+	return h
+}
+
+// frames is n frames of size bytes which start with header and are otherwise silent.
+func frames(header []byte, size, n int) []byte {
+	var out []byte
+	for i := 0; i < n; i++ {
+		f := make([]byte, size)
+		copy(f, header)
+		out = append(out, f...)
+	}
+	return out
+}
+
+// tagFrame is a frame of size bytes holding a Xing or Info tag with the given flags, followed by encoder's tag with
+// the gap in it if encoder isn't empty. sideInfo is the size of the frame's side information.
+func tagFrame(header []byte, size, sideInfo int, magic string, flags uint32, encoder string, delay, padding int) []byte {
+	f := make([]byte, size)
+	copy(f, header)
+	offset := frameHeaderSize + sideInfo
+	if header[1]&0x1 == 0 {
+		offset += 2
+	}
+	copy(f[offset:], magic)
+	binary.BigEndian.PutUint32(f[offset+4:], flags)
+	offset += 8
+	for flag, n := range []int{4, 4, 100, 4} {
+		if flags&(1<<flag) != 0 {
+			offset += n
+		}
+	}
+	if encoder != "" {
+		copy(f[offset:], encoder)
+		copy(f[offset+21:], []byte{byte(delay >> 4), byte(delay&0xf<<4 | padding>>8), byte(padding)})
+	}
+	return f
+}
+
+func TestFrameHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		header              []byte
+		size, samples, rate int
+		channels            int
+	}{
+		{"MPEG-1 layer III", header(mpeg1, layer3, 9, 0, false, false), 417, 1152, 44100, 2},
+		{"MPEG-1 layer III padded", header(mpeg1, layer3, 9, 0, true, false), 418, 1152, 44100, 2},
+		{"MPEG-1 layer III 320kbps at 48kHz", header(mpeg1, layer3, 14, 1, false, true), 960, 1152, 48000, 1},
+		{"MPEG-1 layer II", header(mpeg1, layer2, 12, 1, false, false), 768, 1152, 48000, 2},
+		{"MPEG-1 layer I", header(mpeg1, layer1, 12, 2, false, false), 576, 384, 32000, 2},
+		{"MPEG-1 layer I padded", header(mpeg1, layer1, 12, 2, true, false), 580, 384, 32000, 2},
+		{"MPEG-2 layer III", header(mpeg2, layer3, 8, 0, false, true), 208, 576, 22050, 1},
+		{"MPEG-2 layer III padded", header(mpeg2, layer3, 8, 0, true, true), 209, 576, 22050, 1},
+		{"MPEG-2 layer II", header(mpeg2, layer2, 8, 1, false, false), 384, 1152, 24000, 2},
+		{"MPEG-2.5 layer III", header(mpeg25, layer3, 8, 0, false, false), 417, 576, 11025, 2},
+		{"MPEG-2.5 layer III at 8kHz", header(mpeg25, layer3, 1, 2, false, true), 72, 576, 8000, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, ok := parseFrameHeader(tc.header)
+			if !ok {
+				t.Fatalf("parseFrameHeader(%x) failed", tc.header)
+			}
+			if h.size() != tc.size || h.samples() != tc.samples || h.sampleRate != tc.rate || h.channels != tc.channels {
+				t.Errorf("parseFrameHeader(%x) = size %d, %d samples at %dHz in %d channels, want size %d, %d samples at %dHz in %d channels",
+					tc.header, h.size(), h.samples(), h.sampleRate, h.channels, tc.size, tc.samples, tc.rate, tc.channels)
+			}
+			info, err := InspectMP3(frames(tc.header, tc.size, 99))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := samplesDuration(int64(99*tc.samples), tc.rate); info.Duration != want {
+				t.Errorf("InspectMP3() duration = %v, want %v", info.Duration, want)
+			}
+		})
+	}
+	for _, b := range [][]byte{
+		{0xff, 0xfb, 0x90},                         // Truncated.
+		{0xfe, 0xfb, 0x90, 0x00},                   // No sync.
+		{0xff, 0xeb, 0x90, 0x00},                   // Reserved version.
+		{0xff, 0xf9, 0x90, 0x00},                   // Reserved layer.
+		header(mpeg1, layer3, 0, 0, false, false),  // Free format.
+		header(mpeg1, layer3, 15, 0, false, false), // Invalid bitrate.
+		header(mpeg1, layer3, 9, 3, false, false),  // Reserved sample rate.
+	} {
+		if h, ok := parseFrameHeader(b); ok {
+			t.Errorf("parseFrameHeader(%x) = %+v, want no header", b, h)
+		}
+	}
+}
+
+func TestEncoderGap(t *testing.T) {
+	stereo := header(mpeg1, layer3, 9, 0, false, false)
+	withCRC := append([]byte{}, stereo...)
+	withCRC[1] &^= 0x1
+	mono2 := header(mpeg2, layer3, 8, 0, false, true)
+	for _, tc := range []struct {
+		name           string
+		tag            []byte
+		header         []byte
+		size           int
+		delay, padding int
+	}{
+		{"LAME after every field", tagFrame(stereo, 417, 32, "Xing", 0xf, "LAME3.100", 576, 1000), stereo, 417, 576, 1000},
+		{"LAME after no fields", tagFrame(stereo, 417, 32, "Info", 0, "LAME3.99r", 1105, 529), stereo, 417, 1105, 529},
+		{"FFmpeg", tagFrame(stereo, 417, 32, "Info", 0x1, "Lavc58.91", 1105, 0), stereo, 417, 1105, 0},
+		{"largest gap", tagFrame(stereo, 417, 32, "Info", 0, "LAME3.100", 4095, 4095), stereo, 417, 4095, 4095},
+		{"CRC", tagFrame(withCRC, 417, 32, "Xing", 0x3, "LAME3.100", 576, 700), withCRC, 417, 576, 700},
+		{"MPEG-1 mono", tagFrame(header(mpeg1, layer3, 9, 0, false, true), 417, 17, "Info", 0, "LAME3.100", 576, 100), header(mpeg1, layer3, 9, 0, false, true), 417, 576, 100},
+		{"MPEG-2 mono", tagFrame(mono2, 208, 9, "Info", 0x1, "LAME3.100", 1105, 300), mono2, 208, 1105, 300},
+		{"MPEG-2 stereo", tagFrame(header(mpeg2, layer3, 8, 0, false, false), 208, 17, "Info", 0, "LAME3.100", 1105, 300), header(mpeg2, layer3, 8, 0, false, false), 208, 1105, 300},
+		{"Xing without LAME", tagFrame(stereo, 417, 32, "Xing", 0xf, "", 0, 0), stereo, 417, 0, 0},
+		{"other encoder", tagFrame(stereo, 417, 32, "Info", 0, "GOGO3.13", 576, 1000), stereo, 417, 0, 0},
+		{"VBRI", func() []byte { f := frames(stereo, 417, 1); copy(f[frameHeaderSize+32:], "VBRI"); return f }(), stereo, 417, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := parseFrameHeader(tc.header)
+			delay, padding, isTag := encoderGap(h, tc.tag)
+			if delay != tc.delay || padding != tc.padding || !isTag {
+				t.Errorf("encoderGap() = %d, %d, %t, want %d, %d, true", delay, padding, isTag, tc.delay, tc.padding)
+			}
+			// The tag's frame isn't audio, and the gap doesn't play.
+			info, err := InspectMP3(append(tc.tag, frames(tc.header, tc.size, 100)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := samplesDuration(int64(100*h.samples()-tc.delay-tc.padding), h.sampleRate); info.Duration != want {
+				t.Errorf("InspectMP3() duration = %v, want %v", info.Duration, want)
+			}
+		})
+	}
+	h, _ := parseFrameHeader(stereo)
+	if _, _, isTag := encoderGap(h, frames(stereo, 417, 1)); isTag {
+		t.Error("encoderGap() of an audio frame reports a tag")
+	}
+	// A gap longer than the audio is ignored.
+	info, err := InspectMP3(append(tagFrame(stereo, 417, 32, "Info", 0, "LAME3.100", 4095, 4095), frames(stereo, 417, 2)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := samplesDuration(2*1152, 44100); info.Duration != want {
+		t.Errorf("InspectMP3() with a gap longer than the audio = %v, want %v", info.Duration, want)
+	}
+}
+
+func TestInspectMP3Tags(t *testing.T) {
+	h := header(mpeg1, layer3, 9, 0, false, false)
+	audio := frames(h, 417, 100)
+	want := samplesDuration(100*1152, 44100)
+	id3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x05hello")
+	// The footer flag adds a 10 byte footer after the tag's 5 bytes.
+	footer := append([]byte("ID3\x04\x00\x10\x00\x00\x00\x05hello"), "3DI\x04\x00\x10\x00\x00\x00\x05"...)
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	ape := func(header bool) []byte {
+		item := []byte("\x05\x00\x00\x00\x00\x00\x00\x00Title\x00hello")
+		tag := binary.LittleEndian.AppendUint32([]byte(apeMagic+"\xd0\x07\x00\x00"), uint32(len(item)+apeFooterSize))
+		tag = binary.LittleEndian.AppendUint32(tag, 1)
+		flags := uint32(0)
+		if header {
+			flags = 1 << 31
+		}
+		tag = binary.LittleEndian.AppendUint32(tag, flags)
+		tag = append(tag, make([]byte, 8)...)
+		if !header {
+			return append(item, tag...)
+		}
+		return append(append(append([]byte{}, tag...), item...), tag...)
+	}
+	for name, data := range map[string][]byte{
+		"ID3v2":                append(append([]byte{}, id3...), audio...),
+		"ID3v2 with a footer":  append(append([]byte{}, footer...), audio...),
+		"two ID3v2 tags":       append(append(append([]byte{}, id3...), footer...), audio...),
+		"ID3v1":                append(append([]byte{}, audio...), id3v1...),
+		"APE":                  append(append([]byte{}, audio...), ape(false)...),
+		"APE with a header":    append(append([]byte{}, audio...), ape(true)...),
+		"APE and ID3v1":        append(append(append([]byte{}, audio...), ape(true)...), id3v1...),
+		"every tag":            append(append(append(append([]byte{}, footer...), audio...), ape(false)...), id3v1...),
+		"last frame cut short": append(append([]byte{}, audio...), frames(h, 417, 1)[:200]...),
+	} {
+		info, err := Inspect(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if info.Format != MP3 || info.Duration != want || info.SampleRate != 44100 || info.Channels != 2 || info.Bitrate < 127000 || info.Bitrate > 129000 {
+			t.Errorf("%s: Inspect() = %+v, want %v of 128kbps 44.1kHz stereo", name, info, want)
+		}
+	}
+	for name, data := range map[string][]byte{
+		"empty":             nil,
+		"text":              []byte("hello, world"),
+		"only ID3v2":        id3,
+		"truncated ID3v2":   id3[:8],
+		"ID3v2 too long":    append([]byte("ID3\x04\x00\x00\x00\x00\x01\x00"), audio[:100]...),
+		"footer cut off":    append([]byte("ID3\x04\x00\x10\x00\x00\x00\x05hello"), "3DI"...),
+		"junk after frames": append(append([]byte{}, audio...), "not a tag"...),
+		"only ID3v1":        id3v1,
+	} {
+		if _, err := InspectMP3(data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: InspectMP3() = %v, want ErrInvalid", name, err)
+		}
+	}
+	changes := map[string][]byte{
+		"sample rate": append(frames(h, 417, 3), frames(header(mpeg1, layer3, 9, 1, false, false), 384, 3)...),
+		"version":     append(frames(h, 417, 3), frames(header(mpeg2, layer3, 8, 0, false, false), 208, 3)...),
+	}
+	for name, data := range changes {
+		if _, err := InspectMP3(data); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s changes: InspectMP3() = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
+
+// TestInspectMP3Resync carries on past frames whose headers are corrupt.
+func TestInspectMP3Resync(t *testing.T) {
+	h := header(mpeg1, layer3, 9, 0, false, false)
+	// 300 frames is 7.84 seconds.
+	data := frames(h, 417, 300)
+	for _, corrupt := range [][]int{{20 * 417}, {20*417 + 1}, {20 * 417, 21 * 417}, {299 * 417}} {
+		t.Run(fmt.Sprint(corrupt), func(t *testing.T) {
+			data := append([]byte{}, data...)
+			for _, i := range corrupt {
+				data[i] = 0
+			}
+			info, err := InspectMP3(data)
+			if corrupt[0] == 299*417 {
+				// Nothing follows the last frame to find again.
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("InspectMP3() = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := samplesDuration(int64((300-len(corrupt))*1152), 44100); info.Duration != want {
+				t.Errorf("InspectMP3() duration = %v, want %v", info.Duration, want)
+			}
+		})
+	}
+}
+
+// TestInspectMP3Mutations checks that no truncation or single byte change of a file makes InspectMP3 panic.
+func TestInspectMP3Mutations(t *testing.T) {
+	h := header(mpeg1, layer3, 9, 0, false, false)
+	data := append([]byte("ID3\x04\x00\x10\x00\x00\x00\x05hello3DI\x04\x00\x10\x00\x00\x00\x05"), tagFrame(h, 417, 32, "Xing", 0xf, "LAME3.100", 576, 1000)...)
+	data = append(data, frames(h, 417, 3)...)
+	data = append(data, append([]byte("TAG"), make([]byte, 125)...)...)
+	check := func(data []byte) {
+		t.Helper()
+		if _, err := InspectMP3(data); err != nil && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrUnsupported) {
+			t.Errorf("InspectMP3() = %v, want ErrInvalid or ErrUnsupported", err)
+		}
+	}
+	for n := range data {
+		check(data[:n])
+	}
+	for i := range data {
+		for _, b := range []byte{0x00, 0xff, data[i] ^ 0x80, data[i] + 1} {
+			mutated := append([]byte{}, data...)
+			mutated[i] = b
+			check(mutated)
+		}
+	}
+}
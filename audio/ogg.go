@@ -0,0 +1,150 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	oggCapture = "OggS"
+	// oggHeaderSize is the size of an Ogg page's header, not counting its segment table.
+	oggHeaderSize = 27
+	// oggBeginning is the header type flag of the first page of a stream.
+	oggBeginning = 0x2
+	// opusSampleRate is the sample rate Opus is always decoded at, and which its granule positions count in.
+	opusSampleRate = 48000
+)
+
+// oggCRCTable is the lookup table for Ogg's CRC-32, which unlike the usual CRC-32 isn't reflected.
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+// oggPage is a page of an Ogg stream.
+type oggPage struct {
+	headerType byte
+	// granule is the position in the stream, in samples, of the last packet which ends on the page, or -1 if none do.
+	granule int64
+	serial  uint32
+	body    []byte
+	// size is the size of the page, including its header.
+	size int
+}
+
+// readPage reads the page at the start of data, checking its CRC.
+func readPage(data []byte) (oggPage, error) {
+	if !bytes.HasPrefix(data, []byte(oggCapture)) {
+		return oggPage{}, fmt.Errorf("%w: expected an Ogg page", ErrInvalid)
+	}
+	if len(data) < oggHeaderSize {
+		return oggPage{}, fmt.Errorf("%w: truncated Ogg page", ErrInvalid)
+	}
+	if data[4] != 0 {
+		return oggPage{}, fmt.Errorf("%w: Ogg version %d", ErrUnsupported, data[4])
+	}
+	segments := int(data[26])
+	if len(data) < oggHeaderSize+segments {
+		return oggPage{}, fmt.Errorf("%w: truncated Ogg page", ErrInvalid)
+	}
+	bodySize := 0
+	for _, s := range data[oggHeaderSize : oggHeaderSize+segments] {
+		bodySize += int(s)
+	}
+	size := oggHeaderSize + segments + bodySize
+	if len(data) < size {
+		return oggPage{}, fmt.Errorf("%w: truncated Ogg page", ErrInvalid)
+	}
+	// The CRC is of the whole page with the CRC itself zeroed.
+	crc := oggCRC(0, data[:22])
+	crc = oggCRC(crc, []byte{0, 0, 0, 0})
+	crc = oggCRC(crc, data[26:size])
+	if crc != binary.LittleEndian.Uint32(data[22:26]) {
+		return oggPage{}, fmt.Errorf("%w: Ogg page fails its CRC", ErrInvalid)
+	}
+	return oggPage{
+		headerType: data[5],
+		granule:    int64(binary.LittleEndian.Uint64(data[6:14])),
+		serial:     binary.LittleEndian.Uint32(data[14:18]),
+		body:       data[oggHeaderSize+segments : size],
+		size:       size,
+	}, nil
+}
+
+// InspectOgg inspects an Ogg file holding a single Opus or Vorbis stream, finding its duration from the granule
+// position of its last page.
+func InspectOgg(data []byte) (Info, error) {
+	first, err := readPage(data)
+	if err != nil {
+		return Info{}, err
+	}
+	if first.headerType&oggBeginning == 0 {
+		return Info{}, fmt.Errorf("%w: the first Ogg page doesn't begin a stream", ErrInvalid)
+	}
+	var info Info
+	// preSkip is how many samples Opus decoders drop from the start.
+	var preSkip int64
+	// The identification header is the only packet on the first page.
+	switch id := first.body; {
+	case bytes.HasPrefix(id, []byte("OpusHead")):
+		if len(id) < 19 {
+			return Info{}, fmt.Errorf("%w: truncated Opus header", ErrInvalid)
+		}
+		info.Format = Opus
+		info.Channels = int(id[9])
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+		info.SampleRate = opusSampleRate
+	case bytes.HasPrefix(id, []byte("\x01vorbis")):
+		if len(id) < 30 {
+			return Info{}, fmt.Errorf("%w: truncated Vorbis header", ErrInvalid)
+		}
+		info.Format = Vorbis
+		info.Channels = int(id[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+	default:
+		return Info{}, fmt.Errorf("%w: Ogg files must hold Opus or Vorbis", ErrUnsupported)
+	}
+	if info.Channels == 0 || info.SampleRate <= 0 {
+		return Info{}, fmt.Errorf("%w: %s header has no channels or sample rate", ErrInvalid, info.Format)
+	}
+	var granule, audioBytes int64
+	for pos := first.size; pos < len(data); {
+		page, err := readPage(data[pos:])
+		if err != nil {
+			return Info{}, err
+		}
+		if page.serial != first.serial {
+			return Info{}, fmt.Errorf("%w: Ogg files must hold a single stream", ErrUnsupported)
+		}
+		// Header packets end on pages with a granule position of 0, and audio packets on pages after them.
+		if page.granule != 0 {
+			audioBytes += int64(len(page.body))
+		}
+		if page.granule > 0 {
+			granule = page.granule
+		}
+		pos += page.size
+	}
+	samples := max(granule-preSkip, 0)
+	info.Duration = samplesDuration(samples, info.SampleRate)
+	info.Bitrate = bitrate(audioBytes, info.Duration)
+	return info, nil
+}
@@ -0,0 +1,162 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// makePage builds an Ogg page holding body, with a valid CRC.
+func makePage(headerType byte, granule int64, serial, seq uint32, body []byte) []byte {
+	var segments []byte
+	n := len(body)
+	for n >= 255 {
+		segments = append(segments, 255)
+		n -= 255
+	}
+	segments = append(segments, byte(n))
+	page := []byte(oggCapture + "\x00")
+	page = append(page, headerType)
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = binary.LittleEndian.AppendUint32(page, seq)
+	page = append(page, 0, 0, 0, 0, byte(len(segments)))
+	page = append(page, segments...)
+	page = append(page, body...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(0, page))
+	return page
+}
+
+func opusHead(channels byte, preSkip uint16) []byte {
+	head := append([]byte("OpusHead\x01"), channels)
+	head = binary.LittleEndian.AppendUint16(head, preSkip)
+	// The input sample rate is only informational, since Opus is always decoded at 48kHz.
+	head = binary.LittleEndian.AppendUint32(head, 44100)
+	return append(head, 0, 0, 0)
+}
+
+func vorbisID(channels byte, sampleRate uint32) []byte {
+	id := append([]byte("\x01vorbis\x00\x00\x00\x00"), channels)
+	id = binary.LittleEndian.AppendUint32(id, sampleRate)
+	return append(id, make([]byte, 14)...)
+}
+
+// opus is an Opus stream with the given pre-skip whose audio pages end at granules.
+func opus(preSkip uint16, granules ...int64) []byte {
+	data := makePage(oggBeginning, 0, 7, 0, opusHead(2, preSkip))
+	data = append(data, makePage(0, 0, 7, 1, []byte("OpusTags"))...)
+	for i, granule := range granules {
+		headerType := byte(0)
+		if i == len(granules)-1 {
+			headerType = 0x4
+		}
+		data = append(data, makePage(headerType, granule, 7, uint32(i+2), make([]byte, 500))...)
+	}
+	return data
+}
+
+func TestInspectOpus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		duration time.Duration
+	}{
+		{"pre-skip", opus(312, -1, 312+48000, 312+72000), 1500 * time.Millisecond},
+		{"no pre-skip", opus(0, 48000, 96000), 2 * time.Second},
+		{"pre-skip longer than the audio", opus(3840, 960), 0},
+		{"no audio", opus(312), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := Inspect(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Format != Opus || info.Duration != tc.duration || info.SampleRate != 48000 || info.Channels != 2 {
+				t.Errorf("Inspect() = %+v, want %v of 48kHz stereo Opus", info, tc.duration)
+			}
+		})
+	}
+	info, err := InspectOgg(opus(312, -1, 312+48000, 312+72000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages without a granule position hold audio too.
+	if want := 1500 * 8 * 2 / 3; info.Bitrate != want {
+		t.Errorf("InspectOgg() bitrate = %d, want %d", info.Bitrate, want)
+	}
+}
+
+func TestInspectVorbis(t *testing.T) {
+	data := makePage(oggBeginning, 0, 1, 0, vorbisID(1, 22050))
+	// The comment and setup headers end on a page with a granule of 0.
+	data = append(data, makePage(0, 0, 1, 1, []byte("\x03vorbis"))...)
+	data = append(data, makePage(0, 22050, 1, 2, make([]byte, 100))...)
+	data = append(data, makePage(0x4, 22050*2+11025, 1, 3, make([]byte, 100))...)
+	info, err := Inspect(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != Vorbis || info.Duration != 2500*time.Millisecond || info.SampleRate != 22050 || info.Channels != 1 || info.Bitrate != 200*8*2/5 {
+		t.Errorf("Inspect() = %+v, want 2.5s of 22.05kHz mono Vorbis at 640bps", info)
+	}
+}
+
+func TestInspectOggInvalid(t *testing.T) {
+	valid := opus(312, 312+48000)
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-1] ^= 1
+	badCRC := append([]byte{}, valid...)
+	badCRC[22] ^= 1
+	notFirst := makePage(0, 0, 7, 0, opusHead(2, 312))
+	for name, data := range map[string][]byte{
+		"body fails the CRC":       corrupt,
+		"CRC is wrong":             badCRC,
+		"truncated page":           valid[:len(valid)-10],
+		"truncated header":         valid[:20],
+		"truncated segment table":  makePage(oggBeginning, 0, 7, 0, make([]byte, 600))[:28],
+		"doesn't begin a stream":   notFirst,
+		"truncated Opus header":    makePage(oggBeginning, 0, 7, 0, opusHead(2, 312)[:18]),
+		"truncated Vorbis header":  makePage(oggBeginning, 0, 7, 0, vorbisID(2, 44100)[:29]),
+		"no channels":              makePage(oggBeginning, 0, 7, 0, opusHead(0, 312)),
+		"no sample rate":           makePage(oggBeginning, 0, 7, 0, vorbisID(2, 0)),
+		"junk after the last page": append(append([]byte{}, valid...), "junk"...),
+		"not Ogg":                  []byte("hello, world"),
+	} {
+		if _, err := InspectOgg(data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: InspectOgg() = %v, want ErrInvalid", name, err)
+		}
+	}
+	version := append([]byte{}, valid...)
+	version[4] = 1
+	for name, data := range map[string][]byte{
+		"two streams": append(append([]byte{}, valid...), makePage(oggBeginning, 0, 8, 0, opusHead(2, 312))...),
+		"FLAC":        makePage(oggBeginning, 0, 7, 0, []byte("\x7fFLAC")),
+		"version 1":   version,
+	} {
+		if _, err := InspectOgg(data); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: InspectOgg() = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
+
+// TestInspectOggMutations checks that no truncation or single byte change of a file makes InspectOgg panic.
+func TestInspectOggMutations(t *testing.T) {
+	data := opus(312, -1, 312+960)
+	check := func(data []byte) {
+		t.Helper()
+		if _, err := InspectOgg(data); err != nil && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrUnsupported) {
+			t.Errorf("InspectOgg() = %v, want ErrInvalid or ErrUnsupported", err)
+		}
+	}
+	for n := range data {
+		check(data[:n])
+	}
+	for i := range data {
+		for _, b := range []byte{0x00, 0xff, data[i] ^ 0x80, data[i] + 1} {
+			mutated := append([]byte{}, data...)
+			mutated[i] = b
+			check(mutated)
+		}
+	}
+}
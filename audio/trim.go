@@ -0,0 +1,141 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// decoderDelay is how many samples MP3 decoders output before the first sample the encoder was given, on top of
+	// the encoder's own delay.
+	decoderDelay = 529
+	// lameVersion is the encoder version trimmed files are tagged with, since players only read the gap from tags
+	// whose encoder they recognise, and the gap is written as LAME writes it.
+	lameVersion = "LAME3.100"
+	// xingTagSize is the size of the Xing tag written to trimmed files, with every optional field, followed by the
+	// LAME tag. This is the layout LAME writes.
+	xingTagSize = 120 + 36
+	// xingFlags says every optional field is in the Xing tag.
+	xingFlags = 0xf
+)
+
+var (
+	ErrOutOfRange = errors.New("out of range")
+)
+
+// TrimMP3 cuts an MP3 file down to what plays from start to end, which is clamped to the end of the audio.
+// Frames are copied as they are without re-encoding, so the cut falls on frame boundaries, and a LAME tag is written
+// declaring the samples either side of start and end for players to skip. A frame before the one start falls in is
+// kept, since decoders need it for the overlap and bit reservoir of the next.
+// ID3 and any other tags are dropped.
+func TrimMP3(data []byte, start, end time.Duration) ([]byte, error) {
+	s, err := readMP3(data)
+	if err != nil {
+		return nil, err
+	}
+	if s.header.layer != 1 {
+		return nil, fmt.Errorf("%w: only MPEG layer III can be trimmed", ErrUnsupported)
+	}
+	perFrame := s.header.samples()
+	total := len(s.frames)*perFrame - s.delay - s.padding
+	// from and to are the samples to keep, counted from the start of the first frame.
+	from := s.delay + min(durationSamples(start, s.header.sampleRate), total)
+	to := s.delay + min(durationSamples(end, s.header.sampleRate), total)
+	if start < 0 || from >= to {
+		return nil, fmt.Errorf("%w: nothing plays from %v to %v", ErrOutOfRange, start, end)
+	}
+	// Decoders output each sample decoderDelay samples late, so the frames up to the one which outputs the last sample
+	// are kept. Both gaps are then less than two frames, which fits in the 12 bits the LAME tag has for each.
+	first := max(from/perFrame-1, 0)
+	last := min((to+decoderDelay-1)/perFrame+1, len(s.frames))
+	frames := s.frames[first:last]
+	delay := from - first*perFrame
+	padding := max(last*perFrame-to, 0)
+	tag := s.xingFrame(frames, delay, padding)
+	out := make([]byte, 0, len(tag)+len(frames)*len(frames[0]))
+	out = append(out, tag...)
+	positions := make([]int, len(frames))
+	for i, frame := range frames {
+		positions[i] = len(out)
+		out = append(out, frame...)
+	}
+	// The byte count, table of contents and CRCs are only known once the frames are written.
+	offset := frameHeaderSize + s.header.sideInfoSize()
+	xing := out[offset:]
+	binary.BigEndian.PutUint32(xing[12:], uint32(len(out)))
+	// Each entry of the table of contents is where each percent of the way through starts, in 256ths of the file.
+	for i := 0; i < 100; i++ {
+		xing[16+i] = byte(positions[i*len(frames)/100] * 256 / len(out))
+	}
+	lame := xing[120:]
+	binary.BigEndian.PutUint32(lame[28:], uint32(len(out)))
+	binary.BigEndian.PutUint16(lame[32:], crc16(out[len(tag):]))
+	binary.BigEndian.PutUint16(lame[34:], crc16(out[:offset+120+34]))
+	return out, nil
+}
+
+// xingFrame makes a silent frame like the stream's first which holds an Info tag, or a Xing tag if frames vary in
+// bitrate, followed by a LAME tag declaring the gap. The byte count, table of contents and CRCs are left for the
+// caller to fill in.
+func (s mp3Stream) xingFrame(frames [][]byte, delay, padding int) []byte {
+	h := s.header
+	offset := frameHeaderSize + h.sideInfoSize()
+	mpeg1 := 0
+	if h.mpeg1 {
+		mpeg1 = 1
+	}
+	// Use the lowest bitrate with room for the tags, so that the frame is as small as it can be.
+	var header [frameHeaderSize]byte
+	copy(header[:], frames[0])
+	for index := 1; index < 15; index++ {
+		h.bitrate, h.padding = bitrates[mpeg1][h.layer][index]*1000, false
+		if h.size() >= offset+xingTagSize {
+			// Keep the version, layer, sample rate and channel mode, without a CRC or padding.
+			header[1] |= 0x1
+			header[2] = byte(index)<<4 | header[2]&0x0c
+			break
+		}
+	}
+	frame := make([]byte, h.size())
+	copy(frame, header[:])
+	magic := "Info"
+	for _, f := range frames {
+		if f[2]>>4 != frames[0][2]>>4 {
+			magic = "Xing"
+			break
+		}
+	}
+	tag := frame[offset:]
+	copy(tag, magic)
+	binary.BigEndian.PutUint32(tag[4:], xingFlags)
+	binary.BigEndian.PutUint32(tag[8:], uint32(len(frames)))
+	lame := tag[120:]
+	copy(lame, lameVersion)
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay<<4) | byte(padding>>8)
+	lame[23] = byte(padding)
+	return frame
+}
+
+// durationSamples is how many samples at sampleRate last d.
+func durationSamples(d time.Duration, sampleRate int) int {
+	return int((d.Nanoseconds()*int64(sampleRate) + int64(time.Second)/2) / int64(time.Second))
+}
+
+// crc16 is the CRC-16 which LAME tags are checked with.
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
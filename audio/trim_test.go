@@ -0,0 +1,218 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// numbered is n frames like header of size bytes, each with its index in the byte after its header so that they
+// can be told apart once trimmed.
+func numbered(header []byte, size, n int) []byte {
+	data := frames(header, size, n)
+	for i := 0; i < n; i++ {
+		data[i*size+frameHeaderSize] = byte(i)
+	}
+	return data
+}
+
+// lameGap reads the gap from the LAME tag of a trimmed file, which starts with an MPEG-1 stereo tag frame.
+func lameGap(t *testing.T, out []byte) (delay, padding int) {
+	t.Helper()
+	h, ok := parseFrameHeader(out)
+	if !ok {
+		t.Fatalf("trimmed file starts with %x, want a frame header", out[:frameHeaderSize])
+	}
+	delay, padding, isTag := encoderGap(h, out[:h.size()])
+	if !isTag {
+		t.Fatal("trimmed file doesn't start with a Xing tag")
+	}
+	return delay, padding
+}
+
+// TestTrimMP3 checks the frames kept and gap declared when trimming 2s to 4.5s from 10s of MPEG-1 layer III, with a
+// LAME tag declaring a delay of 576 samples and padding of 2048, and ID3 tags either side.
+func TestTrimMP3(t *testing.T) {
+	h := header(mpeg1, layer3, 9, 0, false, false)
+	n := 10 * 44100 / 1152
+	data := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x02hi"), tagFrame(h, 417, 32, "Info", 0, "LAME3.99r", 576, 2048)...)
+	data = append(data, numbered(h, 417, n)...)
+	data = append(data, append([]byte("TAG"), make([]byte, 125)...)...)
+
+	out, err := TrimMP3(data, 2*time.Second, 4500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(out, []byte(id3Magic)) || trailingTags(out) != 0 {
+		t.Error("TrimMP3() kept the ID3 tags")
+	}
+	info, err := InspectMP3(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 2500*time.Millisecond || info.SampleRate != 44100 || info.Channels != 2 {
+		t.Errorf("InspectMP3() of the trimmed file = %+v, want 2.5s of 44.1kHz stereo", info)
+	}
+	s, err := readMP3(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2s in is 576+88200 = 88776 samples into the frames, in frame 77, so frame 76 is kept for the bit reservoir.
+	// 4.5s in is 576+198450 = 199026 samples in, which decoders output 529 samples late, in frame 173.
+	if first, last := s.frames[0][frameHeaderSize], s.frames[len(s.frames)-1][frameHeaderSize]; first != 76 || last != 173 {
+		t.Errorf("TrimMP3() kept frames %d to %d, want 76 to 173", first, last)
+	}
+	// The delay runs from the start of frame 76 to 2s in, and the padding from 4.5s in to the end of frame 173.
+	if s.delay != 88776-76*1152 || s.padding != 174*1152-199026 {
+		t.Errorf("TrimMP3() gap = %d, %d, want %d, %d", s.delay, s.padding, 88776-76*1152, 174*1152-199026)
+	}
+	if delay, padding := lameGap(t, out); delay != s.delay || padding != s.padding {
+		t.Errorf("LAME tag gap = %d, %d, want %d, %d", delay, padding, s.delay, s.padding)
+	}
+
+	const offset = frameHeaderSize + 32
+	xing := out[offset:]
+	tagSize := len(out) - len(s.frames)*417
+	if string(xing[:4]) != "Info" || binary.BigEndian.Uint32(xing[4:]) != xingFlags {
+		t.Errorf("Xing tag starts %q, want an Info tag with every field", xing[:8])
+	}
+	if got := binary.BigEndian.Uint32(xing[8:]); got != uint32(len(s.frames)) {
+		t.Errorf("Xing frame count = %d, want %d", got, len(s.frames))
+	}
+	if got := binary.BigEndian.Uint32(xing[12:]); got != uint32(len(out)) {
+		t.Errorf("Xing byte count = %d, want %d", got, len(out))
+	}
+	for i := 0; i < 100; i++ {
+		position := tagSize + i*len(s.frames)/100*417
+		if got, want := xing[16+i], byte(position*256/len(out)); got != want {
+			t.Errorf("Xing table of contents entry %d = %d, want %d", i, got, want)
+		}
+	}
+	lame := xing[120:]
+	if string(lame[:9]) != lameVersion {
+		t.Errorf("LAME tag version = %q, want %q", lame[:9], lameVersion)
+	}
+	if got := binary.BigEndian.Uint32(lame[28:]); got != uint32(len(out)) {
+		t.Errorf("LAME tag music length = %d, want %d", got, len(out))
+	}
+	// The music CRC is of the frames after the tag, and the tag CRC of everything before it in the tag frame.
+	if got, want := binary.BigEndian.Uint16(out[offset+152:]), crc16(out[tagSize:]); got != want {
+		t.Errorf("LAME tag music CRC = %#x, want %#x", got, want)
+	}
+	if got, want := binary.BigEndian.Uint16(out[offset+154:]), crc16(out[:offset+154]); got != want {
+		t.Errorf("LAME tag CRC = %#x, want %#x", got, want)
+	}
+
+	// Trimming the trimmed file again stays exact.
+	again, err := TrimMP3(out, 500*time.Millisecond, 1750*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := InspectMP3(again); err != nil || info.Duration != 1250*time.Millisecond {
+		t.Errorf("InspectMP3() of the file trimmed again = %+v, %v, want 1.25s", info, err)
+	}
+}
+
+// TestTrimMP3Durations trims at many points through MPEG-1 stereo and MPEG-2 mono, checking that what plays is
+// exactly what was asked for and that the gap fits the LAME tag.
+func TestTrimMP3Durations(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		header   []byte
+		size     int
+		sideInfo int
+	}{
+		{"MPEG-1 stereo", header(mpeg1, layer3, 9, 0, false, false), 417, 32},
+		{"MPEG-2 mono", header(mpeg2, layer3, 8, 0, false, true), 208, 9},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := parseFrameHeader(tc.header)
+			data := append(tagFrame(tc.header, tc.size, tc.sideInfo, "Info", 0, "LAME3.100", 576, 1500), frames(tc.header, tc.size, 200)...)
+			total := 200*h.samples() - 576 - 1500
+			length := samplesDuration(int64(total), h.sampleRate)
+			for start := time.Duration(0); start < length; start += 97 * time.Millisecond {
+				for _, end := range []time.Duration{start + 10*time.Millisecond, start + time.Second, length, time.Hour} {
+					out, err := TrimMP3(data, start, end)
+					if err != nil {
+						t.Fatalf("TrimMP3(%v, %v) = %v", start, end, err)
+					}
+					s, err := readMP3(out)
+					if err != nil {
+						t.Fatal(err)
+					}
+					samples := min(durationSamples(end, h.sampleRate), total) - durationSamples(start, h.sampleRate)
+					if got := len(s.frames)*h.samples() - s.delay - s.padding; got != samples {
+						t.Errorf("TrimMP3(%v, %v) plays %d samples, want %d", start, end, got, samples)
+					}
+					// Both gaps fit the LAME tag's 12 bits, and the padding covers the decoder's delay unless the
+					// end of the audio was kept.
+					if s.delay >= 1<<12 || s.padding >= 1<<12 || (s.padding < decoderDelay && end < length) {
+						t.Errorf("TrimMP3(%v, %v) gap = %d, %d", start, end, s.delay, s.padding)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTrimMP3Errors(t *testing.T) {
+	h := header(mpeg1, layer3, 9, 0, false, false)
+	data := frames(h, 417, 100)
+	length := samplesDuration(100*1152, 44100)
+	for _, tc := range []struct {
+		name       string
+		start, end time.Duration
+	}{
+		{"start after end", 2 * time.Second, time.Second},
+		{"start at end", time.Second, time.Second},
+		{"start past the audio", length + time.Second, time.Hour},
+		{"start at the end of the audio", length, time.Hour},
+		{"negative start", -time.Second, time.Second},
+	} {
+		if _, err := TrimMP3(data, tc.start, tc.end); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%s: TrimMP3(%v, %v) = %v, want ErrOutOfRange", tc.name, tc.start, tc.end, err)
+		}
+	}
+	// The end is clamped to the end of the audio.
+	out, err := TrimMP3(data, time.Second, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := InspectMP3(out); err != nil || info.Duration != length-time.Second {
+		t.Errorf("InspectMP3() of the file trimmed past its end = %+v, %v, want %v", info, err, length-time.Second)
+	}
+	if _, err := TrimMP3(frames(header(mpeg1, layer2, 12, 1, false, false), 768, 10), 0, time.Second); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("TrimMP3() of layer II = %v, want ErrUnsupported", err)
+	}
+	if _, err := TrimMP3([]byte("hello, world"), 0, time.Second); !errors.Is(err, ErrInvalid) {
+		t.Errorf("TrimMP3() of a text file = %v, want ErrInvalid", err)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// The check value of CRC-16/ARC.
+	if got := crc16([]byte("123456789")); got != 0xbb3d {
+		t.Errorf("crc16() = %#x, want 0xbb3d", got)
+	}
+}
+
+func TestDurationSamples(t *testing.T) {
+	for _, tc := range []struct {
+		d          time.Duration
+		sampleRate int
+		want       int
+	}{
+		{time.Second, 44100, 44100},
+		{4500 * time.Millisecond, 44100, 198450},
+		{time.Millisecond, 22050, 22},
+		// Rounded to the nearest sample.
+		{time.Second / 44100 * 3 / 2, 44100, 1},
+		{0, 48000, 0},
+	} {
+		if got := durationSamples(tc.d, tc.sampleRate); got != tc.want {
+			t.Errorf("durationSamples(%v, %d) = %d, want %d", tc.d, tc.sampleRate, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Command fakediscord serves a fake Discord, which the bot can be pointed at with --discord_url.
+//
+// Scenarios are scripted through the control API described by fake.Server.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/discord/fake"
+)
+
+var (
+	address       = flag.String("address", "localhost:8080", "Address to serve the fake Discord on")
+	bots          = flag.String("bots", "creator:creator-token,manager:manager-token:manager-secret", "Comma-separated list of bots, in the form name:token or name:token:client_secret")
+	users         = flag.String("users", "kagadar", "Comma-separated list of users to create, such as admins to sign in to the dashboard as")
+	template      = flag.String("template", "qFRRy4yyx5Da", "Code of the server template to create")
+	templateRoles = flag.String("template_roles", "Member,DJ", "Comma-separated list of roles in the server template, from lowest to highest")
+)
+
+func init() {
+	klog.InitFlags(nil)
+	flag.Parse()
+}
+
+func main() {
+	d := fake.New()
+	server := fake.NewServer(d)
+	for _, bot := range strings.Split(*bots, ",") {
+		name, token, ok := strings.Cut(bot, ":")
+		if !ok {
+			klog.Fatalf("invalid bot %q, expected name:token", bot)
+		}
+		token, secret, _ := strings.Cut(token, ":")
+		session := server.AddBot(name, token)
+		if secret != "" {
+			server.SetClientSecret(session, secret)
+		}
+		klog.Infof("bot %q has app ID %q", name, session.CurrentApplication().ID)
+	}
+	if *users != "" {
+		for _, name := range strings.Split(*users, ",") {
+			klog.Infof("user %q has ID %q", name, d.AddUser(name).ID)
+		}
+	}
+	var roles []string
+	if *templateRoles != "" {
+		roles = strings.Split(*templateRoles, ",")
+	}
+	d.AddTemplate(*template, roles...)
+	klog.Infof("serving fake Discord on %s", *address)
+	klog.Fatal(http.ListenAndServe(*address, server))
+}
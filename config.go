@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/soundboard"
+	"github.com/kagadar/soundboardbot/tokens"
+)
+
+// Secrets can be given through the environment instead of flags or the config file, and take precedence over both.
+// Tokens are better given as token sources, see tokens.Parse.
+const (
+	creatorAccessTokenEnv    = "SOUNDBOARD_CREATOR_ACCESS_TOKEN"
+	managerAccessTokenEnv    = "SOUNDBOARD_MANAGER_ACCESS_TOKEN"
+	interactionsPublicKeyEnv = "SOUNDBOARD_INTERACTIONS_PUBLIC_KEY"
+	dashboardClientSecretEnv = "SOUNDBOARD_DASHBOARD_CLIENT_SECRET"
+)
+
+// fileConfig is the YAML file given by --config. Anything left out of the file keeps the value of its flag.
+type fileConfig struct {
+	Admins       []string           `yaml:"admins"`
+	AdminGuilds  []string           `yaml:"admin_guilds"`
+	Creator      appConfig          `yaml:"creator"`
+	Manager      appConfig          `yaml:"manager"`
+	DiscordURL   string             `yaml:"discord_url"`
+	Interactions interactionsConfig `yaml:"interactions"`
+	Dashboard    dashboardConfig    `yaml:"dashboard"`
+	Backup       backupConfig       `yaml:"backup"`
+	Export       exportConfig       `yaml:"export"`
+	DB           dbConfig           `yaml:"db"`
+	Monitoring   monitoringConfig   `yaml:"monitoring"`
+	Overflow     overflowConfig     `yaml:"overflow"`
+	Tracing      tracingConfig      `yaml:"tracing"`
+	LogFormat    string             `yaml:"log_format"`
+	// RateLimits are in the same form as --rate_limits, one per entry.
+	RateLimits       []string `yaml:"rate_limits"`
+	Template         string   `yaml:"soundboard_server_template"`
+	DisabledCommands []string `yaml:"disabled_commands"`
+}
+
+type appConfig struct {
+	AccessToken string `yaml:"access_token"`
+	AppID       string `yaml:"app_id"`
+}
+
+type interactionsConfig struct {
+	Address   string `yaml:"address"`
+	PublicKey string `yaml:"public_key"`
+}
+
+type dashboardConfig struct {
+	Address string `yaml:"address"`
+	URL     string `yaml:"url"`
+	// ClientSecret is in the same form as the tokens.
+	ClientSecret string `yaml:"client_secret"`
+}
+
+type backupConfig struct {
+	Dir       string        `yaml:"dir"`
+	Interval  time.Duration `yaml:"interval"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+type exportConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+type dbConfig struct {
+	Path string `yaml:"path"`
+}
+
+type monitoringConfig struct {
+	Address string `yaml:"address"`
+}
+
+type overflowConfig struct {
+	Owner          string `yaml:"owner"`
+	MaxSoundboards int    `yaml:"max_soundboards"`
+}
+
+type tracingConfig struct {
+	// Exporter is in the form given to tracing.Setup.
+	Exporter string `yaml:"exporter"`
+}
+
+// processConfig is the part of the config used to set up the process, rather than the bot.
+// Unlike soundboard.Config, it is only read at startup.
+type processConfig struct {
+	DBPath        string
+	TraceExporter string
+	LogFormat     string
+}
+
+// overrideString replaces *dst with src, unless src is empty.
+func overrideString(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+// parseToken parses the token source for the named bot, warning if the token was given directly.
+func parseToken(name, spec string) (tokens.Source, error) {
+	source, err := tokens.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s token: %w", name, err)
+	}
+	if _, ok := source.(tokens.Static); ok && spec != "" {
+		klog.Warningf("%s token was given directly, where other local users may be able to read it. Use file:<path> or env:<variable> instead", name)
+	}
+	return source, nil
+}
+
+// loadConfig builds the bot's config from flags, then the config file at path (if any), then the environment.
+func loadConfig(path string) (soundboard.Config, processConfig, error) {
+	file := fileConfig{
+		Admins:      splitList(*admins),
+		AdminGuilds: splitList(*adminGuilds),
+		Creator: appConfig{
+			AccessToken: *creatorAccessToken,
+			AppID:       *creatorAppID,
+		},
+		Manager: appConfig{
+			AccessToken: *managerAccessToken,
+			AppID:       *managerAppID,
+		},
+		DiscordURL: *discordURL,
+		Interactions: interactionsConfig{
+			Address:   *interactionsAddress,
+			PublicKey: *interactionsPublicKey,
+		},
+		Dashboard: dashboardConfig{
+			Address:      *dashboardAddress,
+			URL:          *dashboardURL,
+			ClientSecret: *dashboardClientSecret,
+		},
+		Backup:           backupConfig{Dir: *backupDir, Interval: *backupInterval, Retention: *backupRetention},
+		Export:           exportConfig{Dir: *exportDir},
+		DB:               dbConfig{Path: *dbPath},
+		Monitoring:       monitoringConfig{Address: *monitoringAddress},
+		Overflow:         overflowConfig{Owner: *overflowOwner, MaxSoundboards: *maxSoundboards},
+		Tracing:          tracingConfig{Exporter: *traceExporter},
+		LogFormat:        *logFormat,
+		RateLimits:       splitList(*rateLimits),
+		Template:         *template,
+		DisabledCommands: splitList(*disabledCommands),
+	}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return soundboard.Config{}, processConfig{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// Decoding over the flags' values leaves anything missing from the file untouched.
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil {
+			return soundboard.Config{}, processConfig{}, fmt.Errorf("failed to parse config file %q: %w", path, err)
+		}
+	}
+	if os.Getenv(creatorAccessTokenEnv) != "" {
+		file.Creator.AccessToken = "env:" + creatorAccessTokenEnv
+	}
+	if os.Getenv(managerAccessTokenEnv) != "" {
+		file.Manager.AccessToken = "env:" + managerAccessTokenEnv
+	}
+	if os.Getenv(dashboardClientSecretEnv) != "" {
+		file.Dashboard.ClientSecret = "env:" + dashboardClientSecretEnv
+	}
+	overrideString(&file.Interactions.PublicKey, os.Getenv(interactionsPublicKeyEnv))
+	if file.LogFormat != logFormatText && file.LogFormat != logFormatJSON {
+		return soundboard.Config{}, processConfig{}, fmt.Errorf("%w: %q", ErrUnknownLogFormat, file.LogFormat)
+	}
+	creatorToken, err := parseToken("creator", file.Creator.AccessToken)
+	if err != nil {
+		return soundboard.Config{}, processConfig{}, err
+	}
+	managerToken, err := parseToken("manager", file.Manager.AccessToken)
+	if err != nil {
+		return soundboard.Config{}, processConfig{}, err
+	}
+	dashboardSecret, err := parseToken("dashboard", file.Dashboard.ClientSecret)
+	if err != nil {
+		return soundboard.Config{}, processConfig{}, err
+	}
+
+	var guilds []discordgo.Snowflake
+	for _, guild := range file.AdminGuilds {
+		guilds = append(guilds, discordgo.Snowflake(guild))
+	}
+	limits, err := soundboard.ParseRateLimits(strings.Join(file.RateLimits, ","))
+	if err != nil {
+		return soundboard.Config{}, processConfig{}, err
+	}
+	return soundboard.Config{
+		Admins:                file.Admins,
+		AdminGuilds:           guilds,
+		BackupDir:             file.Backup.Dir,
+		BackupInterval:        file.Backup.Interval,
+		BackupRetention:       file.Backup.Retention,
+		CreatorToken:          creatorToken,
+		CreatorAppID:          discordgo.Snowflake(file.Creator.AppID),
+		DashboardAddress:      file.Dashboard.Address,
+		DashboardURL:          file.Dashboard.URL,
+		DashboardClientSecret: dashboardSecret,
+		DiscordURL:            file.DiscordURL,
+		ExportDir:             file.Export.Dir,
+		InteractionsAddress:   file.Interactions.Address,
+		InteractionsPublicKey: file.Interactions.PublicKey,
+		ManagerToken:          managerToken,
+		ManagerAppId:          discordgo.Snowflake(file.Manager.AppID),
+		MaxSoundboards:        file.Overflow.MaxSoundboards,
+		MonitoringAddress:     file.Monitoring.Address,
+		OverflowOwner:         discordgo.Snowflake(file.Overflow.Owner),
+		RateLimits:            limits,
+		Template:              file.Template,
+		DisabledCommands:      file.DisabledCommands,
+	}, processConfig{DBPath: file.DB.Path, TraceExporter: file.Tracing.Exporter, LogFormat: file.LogFormat}, nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/kagadar/go-pipeline/maps"
@@ -18,16 +19,112 @@ type db struct {
 	db *sql.DB
 }
 
+// AuditEntry records a single command call.
+type AuditEntry struct {
+	Time    time.Time
+	UserID  discordgo.Snowflake
+	GuildID discordgo.Snowflake
+	Command string
+	Outcome string
+}
+
+// AutoRole grants TemplateRoleName in every soundboard to members of a guild with RoleID.
+type AutoRole struct {
+	GuildID          discordgo.Snowflake
+	RoleID           discordgo.Snowflake
+	TemplateRoleName string
+}
+
+// Sound records which soundboard a sound was uploaded to.
+type Sound struct {
+	SoundID   discordgo.Snowflake
+	GuildID   discordgo.Snowflake
+	Name      string
+	EmojiID   discordgo.Snowflake
+	EmojiName string
+	Volume    float64
+	// UserID is who added the sound.
+	UserID discordgo.Snowflake
+	Added  time.Time
+	// Tags are extra words to find the sound by.
+	Tags []string
+}
+
 type DB interface {
+	Close() error
+	// Ping checks that the database can still be reached.
+	Ping(ctx context.Context) error
+	// CountSounds returns how many sounds are recorded in each soundboard, including those without any.
+	CountSounds(ctx context.Context) (map[discordgo.Snowflake]int, error)
+	DeleteSound(ctx context.Context, soundID discordgo.Snowflake) error
 	DeleteSoundboard(ctx context.Context, guildID discordgo.Snowflake) error
 	FindAllSoundboardRoles(ctx context.Context, filter set.Set[discordgo.Snowflake]) (map[discordgo.Snowflake]set.Set[discordgo.Snowflake], error)
 	FindSoundboardRoles(ctx context.Context, guildID discordgo.Snowflake, filter set.Set[discordgo.Snowflake]) (set.Set[discordgo.Snowflake], error)
+	// ImportSound records a sound uploaded from a file in an archive, so that an interrupted import of the same
+	// archive can carry on without uploading it again.
+	ImportSound(ctx context.Context, archive, file string, sound Sound) error
+	InsertAuditLog(ctx context.Context, entry AuditEntry) error
 	InsertAutoRole(ctx context.Context, guildID, roleID discordgo.Snowflake, templateRoleName string) error
+	// ListAuditLog returns the most recent entries, newest first.
+	ListAuditLog(ctx context.Context, limit int) ([]AuditEntry, error)
+	ListAutoRoles(ctx context.Context) ([]AutoRole, error)
 	ListGuilds(ctx context.Context) (set.Set[discordgo.Snowflake], error)
+	// ListImportedSounds returns the ID of each sound imported from an archive, by the file it was imported from.
+	ListImportedSounds(ctx context.Context, archive string) (map[string]discordgo.Snowflake, error)
 	ListSoundboards(ctx context.Context) (set.Set[discordgo.Snowflake], error)
+	// ListSounds returns every recorded sound along with its tags in the order they were given, ordered by name.
+	ListSounds(ctx context.Context) ([]Sound, error)
+	// ListSoundboardRoles returns the ID of each template role in each soundboard.
+	ListSoundboardRoles(ctx context.Context) (map[discordgo.Snowflake]map[string]discordgo.Snowflake, error)
+	// MoveSound records that the sound fromID has been uploaded again as to, carrying over who added it, when and its
+	// tags, and forgets fromID.
+	MoveSound(ctx context.Context, fromID discordgo.Snowflake, to Sound) error
+	// ReplaceSounds sets the sounds in a soundboard, keeping who added and when for those already recorded.
+	ReplaceSounds(ctx context.Context, guildID discordgo.Snowflake, sounds []Sound) error
+	// UpsertSound records a sound, keeping who added it and when if it is already recorded.
+	// UserID is left as it was if it is empty, and so are Tags if they are nil.
+	UpsertSound(ctx context.Context, sound Sound) error
 	UpsertSoundboard(ctx context.Context, guildID discordgo.Snowflake, roles map[string]discordgo.Snowflake) error
 }
 
+func (db *db) Close() error {
+	return db.db.Close()
+}
+
+func (db *db) Ping(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+func (db *db) CountSounds(ctx context.Context) (map[discordgo.Snowflake]int, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT b.GuildID, COUNT(s.SoundID)
+		FROM Soundboards AS b
+			LEFT JOIN Sounds AS s
+				USING (GuildID)
+		GROUP BY b.GuildID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to count sounds", err)
+	}
+	out := map[discordgo.Snowflake]int{}
+	for rows.Next() {
+		var guildID discordgo.Snowflake
+		var count int
+		if err := rows.Scan(&guildID, &count); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan sound count", err)
+		}
+		out[guildID] = count
+	}
+	return out, nil
+}
+
+func (db *db) DeleteSound(ctx context.Context, soundID discordgo.Snowflake) error {
+	if _, err := db.db.ExecContext(ctx, `DELETE FROM Sounds WHERE SoundID = ?;`, soundID); err != nil {
+		return fmt.Errorf("%w: failed to delete sound %q", err, soundID)
+	}
+	return nil
+}
+
 func (db *db) DeleteSoundboard(ctx context.Context, guildID discordgo.Snowflake) error {
 	if _, err := db.db.ExecContext(ctx, `DELETE FROM Soundboards WHERE GuildID = ?;`, guildID); err != nil {
 		return fmt.Errorf("%w: failed to delete soundboard %q", err, guildID)
@@ -84,6 +181,32 @@ func (db *db) FindSoundboardRoles(ctx context.Context, guildID discordgo.Snowfla
 	return out, nil
 }
 
+func (db *db) ImportSound(ctx context.Context, archive, file string, sound Sound) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start transaction to import sound %q", err, file)
+	}
+	defer tx.Rollback()
+	if err := upsertSound(ctx, tx, sound); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO ImportedSounds VALUES(?, ?, ?);
+	`, archive, file, sound.SoundID); err != nil {
+		return fmt.Errorf("%w: failed to record import of sound %q", err, file)
+	}
+	return tx.Commit()
+}
+
+func (db *db) InsertAuditLog(ctx context.Context, entry AuditEntry) error {
+	if _, err := db.db.ExecContext(ctx, `
+		INSERT INTO AuditLog VALUES(?, ?, ?, ?, ?);
+	`, entry.Time.UnixMilli(), entry.UserID, entry.GuildID, entry.Command, entry.Outcome); err != nil {
+		return fmt.Errorf("%w: failed to save audit log for %q", err, entry.Command)
+	}
+	return nil
+}
+
 func (db *db) InsertAutoRole(ctx context.Context, guildID, roleID discordgo.Snowflake, templateRoleName string) error {
 	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -102,6 +225,44 @@ func (db *db) InsertAutoRole(ctx context.Context, guildID, roleID discordgo.Snow
 	return tx.Commit()
 }
 
+func (db *db) ListAuditLog(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT * FROM AuditLog ORDER BY Time DESC LIMIT ?;
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list audit log", err)
+	}
+	var out []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var millis int64
+		if err := rows.Scan(&millis, &entry.UserID, &entry.GuildID, &entry.Command, &entry.Outcome); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan audit log entry", err)
+		}
+		entry.Time = time.UnixMilli(millis)
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+func (db *db) ListAutoRoles(ctx context.Context) ([]AutoRole, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT * FROM AutoRoles ORDER BY GuildID, TemplateRoleName;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list autoroles", err)
+	}
+	var out []AutoRole
+	for rows.Next() {
+		var autoRole AutoRole
+		if err := rows.Scan(&autoRole.GuildID, &autoRole.RoleID, &autoRole.TemplateRoleName); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan autorole", err)
+		}
+		out = append(out, autoRole)
+	}
+	return out, nil
+}
+
 func (db *db) ListGuilds(ctx context.Context) (set.Set[discordgo.Snowflake], error) {
 	rows, err := db.db.QueryContext(ctx, `
 		SELECT * FROM Guilds;
@@ -120,6 +281,25 @@ func (db *db) ListGuilds(ctx context.Context) (set.Set[discordgo.Snowflake], err
 	return guilds, nil
 }
 
+func (db *db) ListImportedSounds(ctx context.Context, archive string) (map[string]discordgo.Snowflake, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT File, SoundID FROM ImportedSounds WHERE Archive = ?;
+	`, archive)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list sounds imported from %q", err, archive)
+	}
+	out := map[string]discordgo.Snowflake{}
+	for rows.Next() {
+		var file string
+		var soundID discordgo.Snowflake
+		if err := rows.Scan(&file, &soundID); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan imported sound", err)
+		}
+		out[file] = soundID
+	}
+	return out, nil
+}
+
 func (db *db) ListSoundboards(ctx context.Context) (set.Set[discordgo.Snowflake], error) {
 	rows, err := db.db.QueryContext(ctx, `
 		SELECT * FROM Soundboards;
@@ -138,13 +318,172 @@ func (db *db) ListSoundboards(ctx context.Context) (set.Set[discordgo.Snowflake]
 	return guilds, nil
 }
 
+func (db *db) ListSounds(ctx context.Context) ([]Sound, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT * FROM Sounds ORDER BY Name, SoundID;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list sounds", err)
+	}
+	var out []Sound
+	for rows.Next() {
+		var sound Sound
+		var millis int64
+		if err := rows.Scan(&sound.SoundID, &sound.GuildID, &sound.Name, &sound.EmojiID, &sound.EmojiName, &sound.Volume, &sound.UserID, &millis); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan sound", err)
+		}
+		sound.Added = time.UnixMilli(millis)
+		out = append(out, sound)
+	}
+	byID := map[discordgo.Snowflake]*Sound{}
+	for i := range out {
+		byID[out[i].SoundID] = &out[i]
+	}
+	rows, err = db.db.QueryContext(ctx, `
+		SELECT * FROM SoundTags ORDER BY rowid;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list sound tags", err)
+	}
+	for rows.Next() {
+		var soundID discordgo.Snowflake
+		var tag string
+		if err := rows.Scan(&soundID, &tag); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan sound tag", err)
+		}
+		if sound, ok := byID[soundID]; ok {
+			sound.Tags = append(sound.Tags, tag)
+		}
+	}
+	return out, nil
+}
+
+func (db *db) ListSoundboardRoles(ctx context.Context) (map[discordgo.Snowflake]map[string]discordgo.Snowflake, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT * FROM SoundboardRoles;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list soundboard roles", err)
+	}
+	out := map[discordgo.Snowflake]map[string]discordgo.Snowflake{}
+	for rows.Next() {
+		var guildID, roleID discordgo.Snowflake
+		var roleName string
+		if err := rows.Scan(&guildID, &roleName, &roleID); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan soundboard role", err)
+		}
+		if out[guildID] == nil {
+			out[guildID] = map[string]discordgo.Snowflake{}
+		}
+		out[guildID][roleName] = roleID
+	}
+	return out, nil
+}
+
+func (db *db) MoveSound(ctx context.Context, fromID discordgo.Snowflake, to Sound) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start transaction to move sound %q", err, fromID)
+	}
+	defer tx.Rollback()
+	to.Tags = nil
+	if err := upsertSound(ctx, tx, to); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE Sounds SET UserID = f.UserID, Added = f.Added
+		FROM Sounds AS f
+		WHERE f.SoundID = ? AND Sounds.SoundID = ?;
+	`, fromID, to.SoundID); err != nil {
+		return fmt.Errorf("%w: failed to carry over sound %q", err, fromID)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE OR IGNORE SoundTags SET SoundID = ? WHERE SoundID = ?;
+	`, to.SoundID, fromID); err != nil {
+		return fmt.Errorf("%w: failed to carry over tags of sound %q", err, fromID)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM Sounds WHERE SoundID = ?;
+	`, fromID); err != nil {
+		return fmt.Errorf("%w: failed to delete sound %q", err, fromID)
+	}
+	return tx.Commit()
+}
+
+func (db *db) ReplaceSounds(ctx context.Context, guildID discordgo.Snowflake, sounds []Sound) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start transaction to replace sounds in %q", err, guildID)
+	}
+	defer tx.Rollback()
+	args := []any{guildID}
+	var placeholders []string
+	for _, sound := range sounds {
+		if err := upsertSound(ctx, tx, sound); err != nil {
+			return err
+		}
+		args = append(args, sound.SoundID)
+		placeholders = append(placeholders, "?")
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM Sounds WHERE GuildID = ? AND SoundID NOT IN (%s);
+	`, strings.Join(placeholders, ",")), args...); err != nil {
+		return fmt.Errorf("%w: failed to delete removed sounds in %q", err, guildID)
+	}
+	return tx.Commit()
+}
+
+func (db *db) UpsertSound(ctx context.Context, sound Sound) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start transaction to save sound %q", err, sound.Name)
+	}
+	defer tx.Rollback()
+	if err := upsertSound(ctx, tx, sound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func upsertSound(ctx context.Context, tx *sql.Tx, sound Sound) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO Sounds VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(SoundID) DO UPDATE SET
+			GuildID = excluded.GuildID,
+			Name = excluded.Name,
+			EmojiID = excluded.EmojiID,
+			EmojiName = excluded.EmojiName,
+			Volume = excluded.Volume,
+			UserID = COALESCE(NULLIF(excluded.UserID, ''), UserID);
+	`, sound.SoundID, sound.GuildID, sound.Name, sound.EmojiID, sound.EmojiName, sound.Volume, sound.UserID, sound.Added.UnixMilli()); err != nil {
+		return fmt.Errorf("%w: failed to save sound %q", err, sound.Name)
+	}
+	if sound.Tags == nil {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM SoundTags WHERE SoundID = ?;
+	`, sound.SoundID); err != nil {
+		return fmt.Errorf("%w: failed to delete tags of sound %q", err, sound.Name)
+	}
+	for _, tag := range sound.Tags {
+		if _, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO SoundTags VALUES(?, ?);
+	`, sound.SoundID, tag); err != nil {
+			return fmt.Errorf("%w: failed to save sound %q tag %q", err, sound.Name, tag)
+		}
+	}
+	return nil
+}
+
 func (db *db) UpsertSoundboard(ctx context.Context, guildID discordgo.Snowflake, roles map[string]discordgo.Snowflake) error {
 	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("%w: failed to start transaction to insert soundboard %q", err, guildID)
 	}
+	// Replacing the soundboard would delete its sounds.
 	if _, err := tx.ExecContext(ctx, `
-		INSERT OR REPLACE INTO Soundboards VALUES(?);
+		INSERT OR IGNORE INTO Soundboards VALUES(?);
 	`, guildID); err != nil {
 		return fmt.Errorf("%w: failed to save soundboard %q", err, guildID)
 	}
@@ -158,27 +497,35 @@ func (db *db) UpsertSoundboard(ctx context.Context, guildID discordgo.Snowflake,
 	return tx.Commit()
 }
 
-func New() (DB, error) {
-	hd, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user's home directory: %w", err)
+// New opens the database at path, creating it if needed.
+// If path is empty, the database is kept in ~/.soundboardbot.
+func New(path string) (DB, error) {
+	if path == "" {
+		hd, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user's home directory: %w", err)
+		}
+		path = filepath.Join(hd, ".soundboardbot", "db")
 	}
-	datadir := filepath.Join(hd, ".soundboardbot")
-	if err := os.MkdirAll(datadir, 0700); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return nil, fmt.Errorf("failed to make data directory: %w", err)
 	}
-	d, err := sql.Open("sqlite", filepath.Join(datadir, "db"))
+	// Pragmas are set for every connection in the pool, rather than just the first.
+	d, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 	if _, err := d.Exec(`
-		PRAGMA foreign_keys = ON;
 		CREATE TABLE IF NOT EXISTS Guilds (GuildID TEXT, PRIMARY KEY(GuildID)) STRICT;
 		CREATE TABLE IF NOT EXISTS AutoRoles (GuildID TEXT, RoleID TEXT, TemplateRoleName TEXT, PRIMARY KEY(GuildID, RoleID, TemplateRoleName), FOREIGN KEY(GuildID) REFERENCES Guilds ON DELETE CASCADE) STRICT;
 		CREATE TABLE IF NOT EXISTS Soundboards (GuildID TEXT, PRIMARY KEY(GuildID)) STRICT;
 		CREATE TABLE IF NOT EXISTS SoundboardRoles (GuildID TEXT, TemplateRoleName TEXT, RoleID TEXT, PRIMARY KEY(GuildID, TemplateRoleName, RoleID), FOREIGN KEY(GuildID) REFERENCES Soundboards ON DELETE CASCADE) STRICT;
+		CREATE TABLE IF NOT EXISTS AuditLog (Time INTEGER, UserID TEXT, GuildID TEXT, Command TEXT, Outcome TEXT) STRICT;
+		CREATE TABLE IF NOT EXISTS Sounds (SoundID TEXT, GuildID TEXT, Name TEXT, EmojiID TEXT, EmojiName TEXT, Volume REAL, UserID TEXT, Added INTEGER, PRIMARY KEY(SoundID), FOREIGN KEY(GuildID) REFERENCES Soundboards ON DELETE CASCADE) STRICT;
+		CREATE TABLE IF NOT EXISTS SoundTags (SoundID TEXT, Tag TEXT, PRIMARY KEY(SoundID, Tag), FOREIGN KEY(SoundID) REFERENCES Sounds ON DELETE CASCADE) STRICT;
+		CREATE TABLE IF NOT EXISTS ImportedSounds (Archive TEXT, File TEXT, SoundID TEXT, PRIMARY KEY(Archive, File)) STRICT;
 	`); err != nil {
 		return nil, fmt.Errorf("failed to configure database: %w", err)
 	}
-	return &db{db: d}, nil
+	return traced{&db{db: d}}, nil
 }
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-set"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+var tracer = otel.Tracer("github.com/kagadar/soundboardbot/db")
+
+// traced adds a span for each call to a DB, and logs it at V(2) with any correlation ID in the caller's logger.
+// Calls to methods which it doesn't override go straight to the DB, untraced.
+type traced struct {
+	DB
+}
+
+type call struct {
+	span      trace.Span
+	logger    klog.Logger
+	operation string
+	start     time.Time
+}
+
+func start(ctx context.Context, operation string) (context.Context, call) {
+	ctx, span := tracer.Start(ctx, "db "+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemSqlite,
+		semconv.DBOperation(operation),
+	))
+	return ctx, call{span: span, logger: klog.FromContext(ctx), operation: operation, start: time.Now()}
+}
+
+func end(c call, err error) {
+	if err != nil {
+		c.span.RecordError(err)
+		c.span.SetStatus(codes.Error, err.Error())
+	}
+	c.span.End()
+	if logger := c.logger.V(2); logger.Enabled() {
+		logger.Info("DB call", "operation", c.operation, "duration", time.Since(c.start), "err", err)
+	}
+}
+
+func (t traced) Ping(ctx context.Context) (err error) {
+	ctx, c := start(ctx, "Ping")
+	defer func() { end(c, err) }()
+	return t.DB.Ping(ctx)
+}
+
+func (t traced) CountSounds(ctx context.Context) (_ map[discordgo.Snowflake]int, err error) {
+	ctx, c := start(ctx, "CountSounds")
+	defer func() { end(c, err) }()
+	return t.DB.CountSounds(ctx)
+}
+
+func (t traced) DeleteSound(ctx context.Context, soundID discordgo.Snowflake) (err error) {
+	ctx, c := start(ctx, "DeleteSound")
+	defer func() { end(c, err) }()
+	return t.DB.DeleteSound(ctx, soundID)
+}
+
+func (t traced) DeleteSoundboard(ctx context.Context, guildID discordgo.Snowflake) (err error) {
+	ctx, c := start(ctx, "DeleteSoundboard")
+	defer func() { end(c, err) }()
+	return t.DB.DeleteSoundboard(ctx, guildID)
+}
+
+func (t traced) FindAllSoundboardRoles(ctx context.Context, filter set.Set[discordgo.Snowflake]) (_ map[discordgo.Snowflake]set.Set[discordgo.Snowflake], err error) {
+	ctx, c := start(ctx, "FindAllSoundboardRoles")
+	defer func() { end(c, err) }()
+	return t.DB.FindAllSoundboardRoles(ctx, filter)
+}
+
+func (t traced) FindSoundboardRoles(ctx context.Context, guildID discordgo.Snowflake, filter set.Set[discordgo.Snowflake]) (_ set.Set[discordgo.Snowflake], err error) {
+	ctx, c := start(ctx, "FindSoundboardRoles")
+	defer func() { end(c, err) }()
+	return t.DB.FindSoundboardRoles(ctx, guildID, filter)
+}
+
+func (t traced) ImportSound(ctx context.Context, archive, file string, sound Sound) (err error) {
+	ctx, c := start(ctx, "ImportSound")
+	defer func() { end(c, err) }()
+	return t.DB.ImportSound(ctx, archive, file, sound)
+}
+
+func (t traced) InsertAuditLog(ctx context.Context, entry AuditEntry) (err error) {
+	ctx, c := start(ctx, "InsertAuditLog")
+	defer func() { end(c, err) }()
+	return t.DB.InsertAuditLog(ctx, entry)
+}
+
+func (t traced) InsertAutoRole(ctx context.Context, guildID, roleID discordgo.Snowflake, templateRoleName string) (err error) {
+	ctx, c := start(ctx, "InsertAutoRole")
+	defer func() { end(c, err) }()
+	return t.DB.InsertAutoRole(ctx, guildID, roleID, templateRoleName)
+}
+
+func (t traced) ListAuditLog(ctx context.Context, limit int) (_ []AuditEntry, err error) {
+	ctx, c := start(ctx, "ListAuditLog")
+	defer func() { end(c, err) }()
+	return t.DB.ListAuditLog(ctx, limit)
+}
+
+func (t traced) ListAutoRoles(ctx context.Context) (_ []AutoRole, err error) {
+	ctx, c := start(ctx, "ListAutoRoles")
+	defer func() { end(c, err) }()
+	return t.DB.ListAutoRoles(ctx)
+}
+
+func (t traced) ListGuilds(ctx context.Context) (_ set.Set[discordgo.Snowflake], err error) {
+	ctx, c := start(ctx, "ListGuilds")
+	defer func() { end(c, err) }()
+	return t.DB.ListGuilds(ctx)
+}
+
+func (t traced) ListImportedSounds(ctx context.Context, archive string) (_ map[string]discordgo.Snowflake, err error) {
+	ctx, c := start(ctx, "ListImportedSounds")
+	defer func() { end(c, err) }()
+	return t.DB.ListImportedSounds(ctx, archive)
+}
+
+func (t traced) ListSoundboards(ctx context.Context) (_ set.Set[discordgo.Snowflake], err error) {
+	ctx, c := start(ctx, "ListSoundboards")
+	defer func() { end(c, err) }()
+	return t.DB.ListSoundboards(ctx)
+}
+
+func (t traced) ListSounds(ctx context.Context) (_ []Sound, err error) {
+	ctx, c := start(ctx, "ListSounds")
+	defer func() { end(c, err) }()
+	return t.DB.ListSounds(ctx)
+}
+
+func (t traced) ListSoundboardRoles(ctx context.Context) (_ map[discordgo.Snowflake]map[string]discordgo.Snowflake, err error) {
+	ctx, c := start(ctx, "ListSoundboardRoles")
+	defer func() { end(c, err) }()
+	return t.DB.ListSoundboardRoles(ctx)
+}
+
+func (t traced) MoveSound(ctx context.Context, fromID discordgo.Snowflake, to Sound) (err error) {
+	ctx, c := start(ctx, "MoveSound")
+	defer func() { end(c, err) }()
+	return t.DB.MoveSound(ctx, fromID, to)
+}
+
+func (t traced) ReplaceSounds(ctx context.Context, guildID discordgo.Snowflake, sounds []Sound) (err error) {
+	ctx, c := start(ctx, "ReplaceSounds")
+	defer func() { end(c, err) }()
+	return t.DB.ReplaceSounds(ctx, guildID, sounds)
+}
+
+func (t traced) UpsertSound(ctx context.Context, sound Sound) (err error) {
+	ctx, c := start(ctx, "UpsertSound")
+	defer func() { end(c, err) }()
+	return t.DB.UpsertSound(ctx, sound)
+}
+
+func (t traced) UpsertSoundboard(ctx context.Context, guildID discordgo.Snowflake, roles map[string]discordgo.Snowflake) (err error) {
+	ctx, c := start(ctx, "UpsertSoundboard")
+	defer func() { end(c, err) }()
+	return t.DB.UpsertSoundboard(ctx, guildID, roles)
+}
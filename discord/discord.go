@@ -0,0 +1,278 @@
+// Package discord describes the parts of Discord used by the bot, so that they can be faked.
+package discord
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is a connection to Discord as a single bot user.
+type Session interface {
+	// Open connects to the gateway, after which handlers start receiving events.
+	Open() error
+	Close() error
+	// Reconnect closes the gateway connection and opens a new one, authenticating with token.
+	// Handlers are kept, but the session's state is rebuilt from scratch.
+	Reconnect(token string) error
+	// AddHandler registers a discordgo event handler, such as func(*discordgo.Session, *discordgo.GuildCreate).
+	// Handlers may also take the soundboard events in this package, such as *SoundboardSoundCreate.
+	// Handlers should not rely on the *discordgo.Session they are passed, since it may be nil.
+	AddHandler(handler interface{}) func()
+	// Ready reports whether the gateway connection is established.
+	Ready() bool
+
+	// CurrentUser returns the bot user, once connected.
+	CurrentUser() *discordgo.User
+	// CurrentApplication returns the bot's application, once connected.
+	CurrentApplication() *discordgo.Application
+	// StateGuilds returns the guilds the bot is a member of, once connected.
+	StateGuilds() []*discordgo.Guild
+	// StateRole looks up a role in a guild that the bot is a member of.
+	StateRole(guildID, roleID discordgo.Snowflake) (*discordgo.Role, error)
+
+	// REST calls take discordgo's request options, such as discordgo.WithContext to trace the call as part of ctx.
+	ApplicationCommandBulkOverwrite(appID, guildID discordgo.Snowflake, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	ApplicationCommands(appID, guildID discordgo.Snowflake, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	ChannelInviteCreate(channelID discordgo.Snowflake, invite discordgo.Invite, options ...discordgo.RequestOption) (*discordgo.Invite, error)
+	ChannelMessageDelete(channelID, messageID discordgo.Snowflake, options ...discordgo.RequestOption) error
+	ChannelMessageSend(channelID discordgo.Snowflake, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID discordgo.Snowflake, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageEdit(interaction *discordgo.Interaction, messageID discordgo.Snowflake, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	Guild(guildID discordgo.Snowflake, options ...discordgo.RequestOption) (*discordgo.Guild, error)
+	GuildCreateWithTemplate(templateCode, name, icon string, options ...discordgo.RequestOption) (*discordgo.Guild, error)
+	GuildDelete(guildID discordgo.Snowflake, options ...discordgo.RequestOption) error
+	GuildEdit(guildID discordgo.Snowflake, params *discordgo.GuildParams, options ...discordgo.RequestOption) (*discordgo.Guild, error)
+	GuildLeave(guildID discordgo.Snowflake, options ...discordgo.RequestOption) error
+	GuildMember(guildID, userID discordgo.Snowflake, options ...discordgo.RequestOption) (*discordgo.Member, error)
+	GuildMemberRoleAdd(guildID, userID, roleID discordgo.Snowflake, options ...discordgo.RequestOption) error
+	GuildRoleReorder(guildID discordgo.Snowflake, roles []*discordgo.Role, options ...discordgo.RequestOption) ([]*discordgo.Role, error)
+	GuildSoundboardSounds(guildID discordgo.Snowflake, options ...discordgo.RequestOption) ([]*SoundboardSound, error)
+	GuildSoundboardSoundCreate(guildID discordgo.Snowflake, params *SoundboardSoundParams, options ...discordgo.RequestOption) (*SoundboardSound, error)
+	GuildSoundboardSoundDelete(guildID, soundID discordgo.Snowflake, options ...discordgo.RequestOption) error
+	GuildTemplate(templateCode string, options ...discordgo.RequestOption) (*discordgo.GuildTemplate, error)
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	UserChannelCreate(recipientID discordgo.Snowflake, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserGuilds(limit int, beforeID, afterID discordgo.Snowflake, options ...discordgo.RequestOption) ([]*discordgo.UserGuild, error)
+}
+
+// SetBaseURL points discordgo at a Discord other than https://discord.com/, such as a fake server.
+// discordgo's endpoints are global, so this affects every session and should be called before any are opened.
+func SetBaseURL(base string) {
+	discordgo.EndpointDiscord = strings.TrimSuffix(base, "/") + "/"
+	discordgo.EndpointCDN = discordgo.EndpointDiscord + "cdn/"
+	discordgo.EndpointAPI = discordgo.EndpointDiscord + "api/v" + discordgo.APIVersion + "/"
+	discordgo.EndpointGuilds = discordgo.EndpointAPI + "guilds/"
+	discordgo.EndpointChannels = discordgo.EndpointAPI + "channels/"
+	discordgo.EndpointUsers = discordgo.EndpointAPI + "users/"
+	discordgo.EndpointGateway = discordgo.EndpointAPI + "gateway"
+	discordgo.EndpointGatewayBot = discordgo.EndpointGateway + "/bot"
+	discordgo.EndpointWebhooks = discordgo.EndpointAPI + "webhooks/"
+	discordgo.EndpointStickers = discordgo.EndpointAPI + "stickers/"
+	discordgo.EndpointStageInstances = discordgo.EndpointAPI + "stage-instances"
+	discordgo.EndpointVoice = discordgo.EndpointAPI + "/voice/"
+	discordgo.EndpointVoiceRegions = discordgo.EndpointVoice + "regions"
+	discordgo.EndpointNitroStickersPacks = discordgo.EndpointAPI + "/sticker-packs"
+	discordgo.EndpointGuildCreate = discordgo.EndpointAPI + "guilds"
+	discordgo.EndpointApplications = discordgo.EndpointAPI + "applications"
+	discordgo.EndpointOAuth2 = discordgo.EndpointAPI + "oauth2/"
+	discordgo.EndpointOAuth2Applications = discordgo.EndpointOAuth2 + "applications"
+}
+
+// session adapts a discordgo.Session to Session.
+type session struct {
+	intents   discordgo.Intent
+	transport http.RoundTripper
+
+	mu       sync.RWMutex
+	s        *discordgo.Session
+	handlers map[int]*handler
+	nextID   int
+}
+
+// handler is an event handler along with the function to remove it from the current discordgo.Session.
+type handler struct {
+	handler interface{}
+	remove  func()
+}
+
+func newSession(token string, intents discordgo.Intent, transport http.RoundTripper) (*discordgo.Session, error) {
+	s, err := discordgo.New(fmt.Sprintf("Bot %s", token))
+	if err != nil {
+		return nil, err
+	}
+	s.Identify.Intents |= intents
+	if transport != nil {
+		s.Client.Transport = transport
+	}
+	return s, nil
+}
+
+// New creates a Session for the bot with the given token, requesting intents on top of discordgo's defaults.
+// REST calls are made through transport, or http.DefaultTransport if it is nil.
+func New(token string, intents discordgo.Intent, transport http.RoundTripper) (Session, error) {
+	s, err := newSession(token, intents, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &session{intents: intents, transport: transport, s: s, handlers: map[int]*handler{}}, nil
+}
+
+// current returns the discordgo.Session in use, which changes on Reconnect.
+func (s *session) current() *discordgo.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.s
+}
+
+func (s *session) Open() error {
+	return s.current().Open()
+}
+
+func (s *session) Close() error {
+	return s.current().Close()
+}
+
+func (s *session) Reconnect(token string) error {
+	next, err := newSession(token, s.intents, s.transport)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The old connection is closed first, so that no event is handled twice.
+	if err := s.s.Close(); err != nil {
+		return err
+	}
+	for _, h := range s.handlers {
+		h.remove = next.AddHandler(soundboardHandler(h.handler))
+	}
+	s.s = next
+	return s.s.Open()
+}
+
+func (s *session) Ready() bool {
+	current := s.current()
+	current.RLock()
+	defer current.RUnlock()
+	return current.DataReady
+}
+
+func (s *session) AddHandler(h interface{}) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.nextID++
+	s.handlers[id] = &handler{handler: h, remove: s.s.AddHandler(soundboardHandler(h))}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if h, ok := s.handlers[id]; ok {
+			h.remove()
+			delete(s.handlers, id)
+		}
+	}
+}
+
+func (s *session) CurrentUser() *discordgo.User {
+	return s.current().State.User
+}
+
+func (s *session) CurrentApplication() *discordgo.Application {
+	return s.current().State.Application
+}
+
+func (s *session) StateGuilds() []*discordgo.Guild {
+	state := s.current().State
+	state.RLock()
+	defer state.RUnlock()
+	return append([]*discordgo.Guild(nil), state.Guilds...)
+}
+
+func (s *session) StateRole(guildID, roleID discordgo.Snowflake) (*discordgo.Role, error) {
+	return s.current().State.Role(guildID, roleID)
+}
+
+func (s *session) ApplicationCommandBulkOverwrite(appID, guildID discordgo.Snowflake, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	return s.current().ApplicationCommandBulkOverwrite(appID, guildID, commands, options...)
+}
+
+func (s *session) ApplicationCommands(appID, guildID discordgo.Snowflake, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	return s.current().ApplicationCommands(appID, guildID, options...)
+}
+
+func (s *session) ChannelInviteCreate(channelID discordgo.Snowflake, invite discordgo.Invite, options ...discordgo.RequestOption) (*discordgo.Invite, error) {
+	return s.current().ChannelInviteCreate(channelID, invite, options...)
+}
+
+func (s *session) ChannelMessageDelete(channelID, messageID discordgo.Snowflake, options ...discordgo.RequestOption) error {
+	return s.current().ChannelMessageDelete(channelID, messageID, options...)
+}
+
+func (s *session) ChannelMessageSend(channelID discordgo.Snowflake, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.current().ChannelMessageSend(channelID, content, options...)
+}
+
+func (s *session) ChannelMessageSendComplex(channelID discordgo.Snowflake, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.current().ChannelMessageSendComplex(channelID, data, options...)
+}
+
+func (s *session) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.current().FollowupMessageCreate(interaction, wait, data, options...)
+}
+
+func (s *session) FollowupMessageEdit(interaction *discordgo.Interaction, messageID discordgo.Snowflake, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.current().FollowupMessageEdit(interaction, messageID, data, options...)
+}
+
+func (s *session) Guild(guildID discordgo.Snowflake, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	return s.current().Guild(guildID, options...)
+}
+
+func (s *session) GuildCreateWithTemplate(templateCode, name, icon string, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	return s.current().GuildCreateWithTemplate(templateCode, name, icon, options...)
+}
+
+func (s *session) GuildDelete(guildID discordgo.Snowflake, options ...discordgo.RequestOption) error {
+	return s.current().GuildDelete(guildID, options...)
+}
+
+func (s *session) GuildEdit(guildID discordgo.Snowflake, params *discordgo.GuildParams, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	return s.current().GuildEdit(guildID, params, options...)
+}
+
+func (s *session) GuildLeave(guildID discordgo.Snowflake, options ...discordgo.RequestOption) error {
+	return s.current().GuildLeave(guildID, options...)
+}
+
+func (s *session) GuildMember(guildID, userID discordgo.Snowflake, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	return s.current().GuildMember(guildID, userID, options...)
+}
+
+func (s *session) GuildMemberRoleAdd(guildID, userID, roleID discordgo.Snowflake, options ...discordgo.RequestOption) error {
+	return s.current().GuildMemberRoleAdd(guildID, userID, roleID, options...)
+}
+
+func (s *session) GuildRoleReorder(guildID discordgo.Snowflake, roles []*discordgo.Role, options ...discordgo.RequestOption) ([]*discordgo.Role, error) {
+	return s.current().GuildRoleReorder(guildID, roles, options...)
+}
+
+func (s *session) GuildTemplate(templateCode string, options ...discordgo.RequestOption) (*discordgo.GuildTemplate, error) {
+	return s.current().GuildTemplate(templateCode, options...)
+}
+
+func (s *session) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	return s.current().InteractionRespond(interaction, resp, options...)
+}
+
+func (s *session) UserChannelCreate(recipientID discordgo.Snowflake, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	return s.current().UserChannelCreate(recipientID, options...)
+}
+
+func (s *session) UserGuilds(limit int, beforeID, afterID discordgo.Snowflake, options ...discordgo.RequestOption) ([]*discordgo.UserGuild, error) {
+	return s.current().UserGuilds(limit, beforeID, afterID, options...)
+}
@@ -0,0 +1,1222 @@
+// Package fake is an in-process Discord, which lets the bot run without a network connection.
+//
+// A Discord holds guilds, users, roles, invites, messages, soundboard sounds and attachments. Bots connect to it
+// through Sessions, which implement discord.Session, while the rest of Discord is driven by calling methods on
+// Discord directly, such as AddUser, JoinInvite and Interact.
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	discordEpoch = 1420070400000
+)
+
+// Discord is the shared state that all Sessions act upon.
+type Discord struct {
+	mu        sync.Mutex
+	lastID    int64
+	users     map[discordgo.Snowflake]*discordgo.User
+	sessions  map[discordgo.Snowflake]*Session
+	guilds    map[discordgo.Snowflake]*guild
+	templates map[string]*discordgo.GuildTemplate
+	invites   map[string]discordgo.Snowflake
+	dms       map[dmKey]*discordgo.Channel
+	messages  map[discordgo.Snowflake][]*discordgo.Message
+	commands  map[commandKey][]*discordgo.ApplicationCommand
+	followups map[discordgo.Snowflake]*followups
+	// attachments holds the content of each uploaded attachment.
+	attachments map[discordgo.Snowflake]attachment
+	// audio holds the content of each soundboard sound.
+	audio map[discordgo.Snowflake]audio
+	// attachmentLimit is the largest file which can be attached, unless it is 0.
+	attachmentLimit int
+}
+
+type guild struct {
+	guild   discordgo.Guild
+	members map[discordgo.Snowflake]*discordgo.Member
+	sounds  []*discord.SoundboardSound
+}
+
+type attachment struct {
+	attachment *discordgo.MessageAttachment
+	content    []byte
+}
+
+type audio struct {
+	contentType string
+	content     []byte
+}
+
+type dmKey struct {
+	bot, user discordgo.Snowflake
+}
+
+type commandKey struct {
+	app, guild discordgo.Snowflake
+}
+
+type followups struct {
+	responded bool
+	// response is the content the bot responded with, if it responded with a message rather than deferring.
+	response string
+	messages []*discordgo.Message
+	// choices are those suggested in response to an autocomplete interaction.
+	choices []*discordgo.ApplicationCommandOptionChoice
+}
+
+func New() *Discord {
+	return &Discord{
+		users:     map[discordgo.Snowflake]*discordgo.User{},
+		sessions:  map[discordgo.Snowflake]*Session{},
+		guilds:    map[discordgo.Snowflake]*guild{},
+		templates: map[string]*discordgo.GuildTemplate{},
+		invites:   map[string]discordgo.Snowflake{},
+		dms:       map[dmKey]*discordgo.Channel{},
+		messages:  map[discordgo.Snowflake][]*discordgo.Message{},
+		commands:  map[commandKey][]*discordgo.ApplicationCommand{},
+		followups: map[discordgo.Snowflake]*followups{},
+
+		attachments: map[discordgo.Snowflake]attachment{},
+		audio:       map[discordgo.Snowflake]audio{},
+	}
+}
+
+// newID returns a unique snowflake for the current time. d.mu must be held.
+func (d *Discord) newID() discordgo.Snowflake {
+	id := (time.Now().UnixMilli() - discordEpoch) << 22
+	if id <= d.lastID {
+		id = d.lastID + 1
+	}
+	d.lastID = id
+	return discordgo.Snowflake(strconv.FormatInt(id, 10))
+}
+
+func restError(status, code int, message string) error {
+	body, _ := json.Marshal(discordgo.APIErrorMessage{Code: code, Message: message})
+	return &discordgo.RESTError{
+		Response: &http.Response{
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode: status,
+		},
+		ResponseBody: body,
+		Message:      &discordgo.APIErrorMessage{Code: code, Message: message},
+	}
+}
+
+func errMissingAccess() error {
+	return restError(http.StatusForbidden, discordgo.ErrCodeMissingAccess, "Missing Access")
+}
+
+func errMissingPermissions() error {
+	return restError(http.StatusForbidden, discordgo.ErrCodeMissingPermissions, "Missing Permissions")
+}
+
+func errUnknownChannel() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel, "Unknown Channel")
+}
+
+func errUnknownGuild() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownGuild, "Unknown Guild")
+}
+
+func errUnknownInteraction() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownInteraction, "Unknown interaction")
+}
+
+func errUnknownInvite() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownInvite, "Unknown Invite")
+}
+
+func errUnknownMember() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownMember, "Unknown Member")
+}
+
+func errUnknownMessage() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownMessage, "Unknown Message")
+}
+
+func errUnknownRole() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownRole, "Unknown Role")
+}
+
+func errUnknownSound() error {
+	return restError(http.StatusNotFound, discord.ErrCodeUnknownSound, "Unknown Sound")
+}
+
+func errUnknownTemplate() error {
+	return restError(http.StatusNotFound, discordgo.ErrCodeUnknownGuildTemplate, "Unknown Guild Template")
+}
+
+// AddUser creates a (human) user.
+func (d *Discord) AddUser(name string) *discordgo.User {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u := &discordgo.User{ID: d.newID(), Username: name}
+	d.users[u.ID] = u
+	return u
+}
+
+// AddBot creates a bot user, along with an application with the same ID and name.
+// The bot's Session receives events once it is opened.
+func (d *Discord) AddBot(name string) *Session {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u := &discordgo.User{ID: d.newID(), Username: name, Bot: true}
+	d.users[u.ID] = u
+	s := &Session{d: d, user: u, app: &discordgo.Application{ID: u.ID, Name: name}}
+	d.sessions[u.ID] = s
+	return s
+}
+
+// AddTemplate creates a guild template which has roles, from lowest to highest.
+func (d *Discord) AddTemplate(code string, roles ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	source := &discordgo.Guild{Roles: []*discordgo.Role{{Name: "@everyone"}}}
+	for i, name := range roles {
+		source.Roles = append(source.Roles, &discordgo.Role{Name: name, Position: i + 1})
+	}
+	d.templates[code] = &discordgo.GuildTemplate{Code: code, SerializedSourceGuild: source}
+}
+
+// AddGuild creates a guild owned by owner, with roles from lowest to highest.
+func (d *Discord) AddGuild(name string, owner discordgo.Snowflake, roles ...string) *discordgo.Guild {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g := d.createGuild(name, owner, roles)
+	return copyGuild(&g.guild)
+}
+
+// createGuild creates a guild with its owner as the only member. d.mu must be held.
+func (d *Discord) createGuild(name string, owner discordgo.Snowflake, roles []string) *guild {
+	g := &guild{
+		guild: discordgo.Guild{
+			ID:              d.newID(),
+			Name:            name,
+			OwnerID:         owner,
+			SystemChannelID: d.newID(),
+		},
+		members: map[discordgo.Snowflake]*discordgo.Member{},
+	}
+	// The @everyone role shares its ID with the guild.
+	g.guild.Roles = []*discordgo.Role{{ID: g.guild.ID, Name: "@everyone"}}
+	for i, name := range roles {
+		g.guild.Roles = append(g.guild.Roles, &discordgo.Role{ID: d.newID(), Name: name, Position: i + 1})
+	}
+	d.guilds[g.guild.ID] = g
+	d.join(g, owner)
+	return g
+}
+
+// join adds user to the guild with roles, and tells every bot in the guild. d.mu must be held.
+func (d *Discord) join(g *guild, user discordgo.Snowflake, roles ...discordgo.Snowflake) {
+	m := &discordgo.Member{GuildID: g.guild.ID, User: d.users[user], Roles: roles}
+	g.members[user] = m
+	if s, ok := d.sessions[user]; ok {
+		s.dispatch(&discordgo.GuildCreate{Guild: copyGuild(&g.guild)})
+	}
+	for id := range g.members {
+		if s, ok := d.sessions[id]; ok && id != user {
+			s.dispatch(&discordgo.GuildMemberAdd{Member: copyMember(m)})
+		}
+	}
+}
+
+// AddRole creates a role at the top of the guild's role hierarchy.
+func (d *Discord) AddRole(guildID discordgo.Snowflake, name string) (*discordgo.Role, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return nil, errUnknownGuild()
+	}
+	r := &discordgo.Role{ID: d.newID(), Name: name, Position: len(g.guild.Roles)}
+	g.guild.Roles = append(g.guild.Roles, r)
+	return copyRole(r), nil
+}
+
+// SetPremiumTier sets the guild's boost tier, which decides how many soundboard sounds it can hold.
+func (d *Discord) SetPremiumTier(guildID discordgo.Snowflake, tier discordgo.PremiumTier) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return errUnknownGuild()
+	}
+	g.guild.PremiumTier = tier
+	return nil
+}
+
+// broadcast sends event to every bot in the guild. d.mu must be held.
+func (d *Discord) broadcast(g *guild, event interface{}) {
+	for id := range g.members {
+		if s, ok := d.sessions[id]; ok {
+			s.dispatch(event)
+		}
+	}
+}
+
+// AddSound uploads a sound to the guild's soundboard as user, as if from Discord's client.
+func (d *Discord) AddSound(guildID, user discordgo.Snowflake, name string) (*discord.SoundboardSound, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return nil, errUnknownGuild()
+	}
+	if _, ok := g.members[user]; !ok {
+		return nil, errMissingAccess()
+	}
+	return d.addSound(g, d.users[user], &discord.SoundboardSoundParams{Name: name, Sound: "data:audio/mpeg;base64,"})
+}
+
+// addSound adds a sound to the guild's soundboard, if it has a free slot. d.mu must be held.
+func (d *Discord) addSound(g *guild, user *discordgo.User, params *discord.SoundboardSoundParams) (*discord.SoundboardSound, error) {
+	contentType, data, ok := strings.Cut(strings.TrimPrefix(params.Sound, "data:"), ";base64,")
+	content, err := base64.StdEncoding.DecodeString(data)
+	if len(params.Name) < 2 || len(params.Name) > 32 || !strings.HasPrefix(params.Sound, "data:audio/") || !ok || err != nil {
+		return nil, restError(http.StatusBadRequest, discordgo.ErrCodeInvalidFormBody, "Invalid Form Body")
+	}
+	if len(g.sounds) >= discord.MaxSoundboardSounds(g.guild.PremiumTier) {
+		return nil, restError(http.StatusBadRequest, discord.ErrCodeMaximumSoundboardSounds, "Maximum number of soundboard sounds reached")
+	}
+	sound := &discord.SoundboardSound{
+		ID:        d.newID(),
+		GuildID:   g.guild.ID,
+		Name:      params.Name,
+		Volume:    1,
+		EmojiID:   params.EmojiID,
+		EmojiName: params.EmojiName,
+		Available: true,
+		User:      user,
+	}
+	if params.Volume != nil {
+		sound.Volume = *params.Volume
+	}
+	g.sounds = append(g.sounds, sound)
+	d.audio[sound.ID] = audio{contentType: contentType, content: content}
+	event := *sound
+	d.broadcast(g, &discord.SoundboardSoundCreate{SoundboardSound: &event})
+	c := *sound
+	return &c, nil
+}
+
+// UpdateSound renames a sound in the guild's soundboard, as if from Discord's client.
+func (d *Discord) UpdateSound(guildID, soundID discordgo.Snowflake, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return errUnknownGuild()
+	}
+	i := slices.IndexFunc(g.sounds, func(s *discord.SoundboardSound) bool { return s.ID == soundID })
+	if i < 0 {
+		return errUnknownSound()
+	}
+	g.sounds[i].Name = name
+	c := *g.sounds[i]
+	d.broadcast(g, &discord.SoundboardSoundUpdate{SoundboardSound: &c})
+	return nil
+}
+
+// DeleteSound removes a sound from the guild's soundboard, as if from Discord's client.
+func (d *Discord) DeleteSound(guildID, soundID discordgo.Snowflake) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return errUnknownGuild()
+	}
+	return d.deleteSound(g, soundID)
+}
+
+// deleteSound removes a sound from the guild's soundboard. d.mu must be held.
+func (d *Discord) deleteSound(g *guild, soundID discordgo.Snowflake) error {
+	i := slices.IndexFunc(g.sounds, func(s *discord.SoundboardSound) bool { return s.ID == soundID })
+	if i < 0 {
+		return errUnknownSound()
+	}
+	g.sounds = slices.Delete(g.sounds, i, i+1)
+	delete(d.audio, soundID)
+	d.broadcast(g, &discord.SoundboardSoundDelete{SoundID: soundID, GuildID: g.guild.ID})
+	return nil
+}
+
+// Sounds returns the sounds in the guild's soundboard.
+func (d *Discord) Sounds(guildID discordgo.Snowflake) []*discord.SoundboardSound {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return nil
+	}
+	var out []*discord.SoundboardSound
+	for _, s := range g.sounds {
+		c := *s
+		out = append(out, &c)
+	}
+	return out
+}
+
+// SoundContent returns the content of a soundboard sound, along with its content type.
+// Its content can also be downloaded from discord.SoundboardSoundURL through a Server.
+func (d *Discord) SoundContent(soundID discordgo.Snowflake) ([]byte, string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.audio[soundID]
+	return a.content, a.contentType, ok
+}
+
+// AddAttachment uploads a file, which can be passed to Interact as the value of an attachment option.
+// Its URL is under discordgo.EndpointDiscord, so it can only be downloaded through a Server.
+func (d *Discord) AddAttachment(filename, contentType string, content []byte) *discordgo.MessageAttachment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addAttachment(filename, contentType, content)
+}
+
+// addAttachment stores an attachment, returning a copy of it. d.mu must be held.
+func (d *Discord) addAttachment(filename, contentType string, content []byte) *discordgo.MessageAttachment {
+	id := d.newID()
+	a := &discordgo.MessageAttachment{
+		ID:          id,
+		URL:         discordgo.EndpointDiscord + strings.TrimPrefix(controlPath, "/") + "attachments/" + string(id) + "/" + filename,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        len(content),
+	}
+	d.attachments[id] = attachment{attachment: a, content: slices.Clone(content)}
+	c := *a
+	return &c
+}
+
+// attachFiles stores files sent with a message as its attachments. d.mu must be held.
+func (d *Discord) attachFiles(files []*discordgo.File) ([]*discordgo.MessageAttachment, error) {
+	var out []*discordgo.MessageAttachment
+	for _, f := range files {
+		content, err := io.ReadAll(f.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if d.attachmentLimit > 0 && len(content) > d.attachmentLimit {
+			return nil, restError(http.StatusRequestEntityTooLarge, discordgo.ErrCodeRequestEntityTooLarge, "Request entity too large")
+		}
+		out = append(out, d.addAttachment(f.Name, f.ContentType, content))
+	}
+	return out, nil
+}
+
+// SetAttachmentLimit makes attaching files larger than limit bytes fail as Discord does, unless limit is 0.
+func (d *Discord) SetAttachmentLimit(limit int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attachmentLimit = limit
+}
+
+// Attachment returns the content of an attachment.
+func (d *Discord) Attachment(id discordgo.Snowflake) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.attachments[id]
+	return a.content, ok
+}
+
+// AddMember adds user to the guild with roles, as if they had been invited.
+func (d *Discord) AddMember(guildID, user discordgo.Snowflake, roles ...discordgo.Snowflake) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return errUnknownGuild()
+	}
+	d.join(g, user, roles...)
+	return nil
+}
+
+// JoinInvite adds user to the guild that the invite belongs to.
+func (d *Discord) JoinInvite(code string, user discordgo.Snowflake) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[d.invites[code]]
+	if !ok {
+		return errUnknownInvite()
+	}
+	if _, ok := g.members[user]; !ok {
+		d.join(g, user)
+	}
+	return nil
+}
+
+// Authorize adds the bot to a guild on behalf of user, who must be a member of the guild.
+// Like Discord, the bot is given a role named after its application at the bottom of the role hierarchy.
+func (d *Discord) Authorize(bot *Session, guildID, user discordgo.Snowflake) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return errUnknownGuild()
+	}
+	if _, ok := g.members[user]; !ok {
+		return errMissingAccess()
+	}
+	r := &discordgo.Role{ID: d.newID(), Name: bot.app.Name, Managed: true, Position: 1}
+	for _, existing := range g.guild.Roles {
+		if existing.Position >= 1 {
+			existing.Position++
+		}
+	}
+	g.guild.Roles = append(g.guild.Roles, r)
+	d.join(g, bot.user.ID, r.ID)
+	return nil
+}
+
+// Interact calls a bot's application command as user from the guild, or a DM if guildID is empty.
+func (d *Discord) Interact(bot *Session, user, guildID discordgo.Snowflake, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	return d.interact(bot, discordgo.InteractionApplicationCommand, user, guildID, name, options)
+}
+
+// Autocomplete asks bot to suggest values for the option being typed, which is the one of options marked Focused.
+// The suggestions are returned by Choices once the bot has responded.
+func (d *Discord) Autocomplete(bot *Session, user, guildID discordgo.Snowflake, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	return d.interact(bot, discordgo.InteractionApplicationCommandAutocomplete, user, guildID, name, options)
+}
+
+func (d *Discord) interact(bot *Session, kind discordgo.InteractionType, user, guildID discordgo.Snowflake, name string, options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data := discordgo.ApplicationCommandInteractionData{
+		Name:    name,
+		Options: options,
+	}
+	// Attachment options are resolved from those added with AddAttachment.
+	for _, o := range options {
+		if o.Type != discordgo.ApplicationCommandOptionAttachment {
+			continue
+		}
+		id, _ := o.Value.(string)
+		if a, ok := d.attachments[discordgo.Snowflake(id)]; ok {
+			if data.Resolved == nil {
+				data.Resolved = &discordgo.ApplicationCommandInteractionDataResolved{
+					Attachments: map[discordgo.Snowflake]*discordgo.MessageAttachment{},
+				}
+			}
+			c := *a.attachment
+			data.Resolved.Attachments[discordgo.Snowflake(id)] = &c
+		}
+	}
+	i := &discordgo.Interaction{
+		ID:      d.newID(),
+		AppID:   bot.app.ID,
+		Type:    kind,
+		GuildID: guildID,
+		Data:    data,
+	}
+	i.Token = string(i.ID)
+	if guildID == "" {
+		i.User = d.users[user]
+	} else {
+		i.Member = &discordgo.Member{GuildID: guildID, User: d.users[user]}
+	}
+	d.followups[i.ID] = &followups{}
+	bot.dispatch(&discordgo.InteractionCreate{Interaction: i})
+	return i
+}
+
+// Responded reports whether the bot has responded to the interaction.
+func (d *Discord) Responded(interactionID discordgo.Snowflake) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, ok := d.followups[interactionID]
+	return ok && f.responded
+}
+
+// Response returns the content of the message the bot responded to the interaction with, which is empty if it
+// deferred its response instead.
+func (d *Discord) Response(interactionID discordgo.Snowflake) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.followups[interactionID]; ok {
+		return f.response
+	}
+	return ""
+}
+
+// Followups returns the current content of each follow-up message sent for the interaction.
+func (d *Discord) Followups(interactionID discordgo.Snowflake) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []string
+	if f, ok := d.followups[interactionID]; ok {
+		for _, m := range f.messages {
+			out = append(out, m.Content)
+		}
+	}
+	return out
+}
+
+// FollowupAttachments returns the files attached to the follow-up messages sent for the interaction, whose content
+// can be read with Attachment.
+func (d *Discord) FollowupAttachments(interactionID discordgo.Snowflake) []*discordgo.MessageAttachment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []*discordgo.MessageAttachment
+	if f, ok := d.followups[interactionID]; ok {
+		for _, m := range f.messages {
+			out = append(out, m.Attachments...)
+		}
+	}
+	return out
+}
+
+// Choices returns the suggestions the bot responded to an autocomplete interaction with.
+func (d *Discord) Choices(interactionID discordgo.Snowflake) []*discordgo.ApplicationCommandOptionChoice {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.followups[interactionID]; ok {
+		return slices.Clone(f.choices)
+	}
+	return nil
+}
+
+// DirectMessages returns the messages that bots have sent to user.
+func (d *Discord) DirectMessages(user discordgo.Snowflake) []*discordgo.Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []*discordgo.Message
+	for key, c := range d.dms {
+		if key.user == user {
+			for _, m := range d.messages[c.ID] {
+				c := *m
+				out = append(out, &c)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Guild returns the guild, including its members.
+func (d *Discord) Guild(guildID discordgo.Snowflake) (*discordgo.Guild, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return nil, false
+	}
+	out := copyGuild(&g.guild)
+	for _, m := range g.members {
+		out.Members = append(out.Members, copyMember(m))
+	}
+	out.MemberCount = len(out.Members)
+	return out, true
+}
+
+// Commands returns the application commands registered by the app, either globally or in the guild.
+func (d *Discord) Commands(appID, guildID discordgo.Snowflake) []*discordgo.ApplicationCommand {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.commands[commandKey{appID, guildID}])
+}
+
+func copyRole(r *discordgo.Role) *discordgo.Role {
+	c := *r
+	return &c
+}
+
+func copyMember(m *discordgo.Member) *discordgo.Member {
+	c := *m
+	c.Roles = slices.Clone(m.Roles)
+	return &c
+}
+
+func copyGuild(g *discordgo.Guild) *discordgo.Guild {
+	c := *g
+	c.Roles = nil
+	for _, r := range g.Roles {
+		c.Roles = append(c.Roles, copyRole(r))
+	}
+	c.Members = nil
+	return &c
+}
+
+// Session is a bot's connection to a Discord.
+type Session struct {
+	d    *Discord
+	user *discordgo.User
+	app  *discordgo.Application
+
+	mu       sync.Mutex
+	open     bool
+	handlers map[int]interface{}
+	nextID   int
+}
+
+var _ discord.Session = &Session{}
+
+// dispatch sends event to each handler which accepts it, if the session is open.
+// Like discordgo, each handler is called in its own goroutine.
+func (s *Session) dispatch(event interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.open {
+		return
+	}
+	ev := reflect.ValueOf(event)
+	for _, h := range s.handlers {
+		hv := reflect.ValueOf(h)
+		if hv.Type().In(1) != ev.Type() {
+			continue
+		}
+		go hv.Call([]reflect.Value{reflect.Zero(hv.Type().In(0)), ev})
+	}
+}
+
+// Open connects the session, which sends a GuildCreate for each guild the bot is a member of.
+func (s *Session) Open() error {
+	s.mu.Lock()
+	s.open = true
+	s.mu.Unlock()
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	for _, g := range s.d.guilds {
+		if _, ok := g.members[s.user.ID]; ok {
+			s.dispatch(&discordgo.GuildCreate{Guild: copyGuild(&g.guild)})
+		}
+	}
+	return nil
+}
+
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open = false
+	return nil
+}
+
+// Reconnect closes and opens the session. Fake bots don't have tokens, so token is ignored.
+func (s *Session) Reconnect(token string) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	return s.Open()
+}
+
+func (s *Session) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open
+}
+
+func (s *Session) AddHandler(handler interface{}) func() {
+	t := reflect.TypeOf(handler)
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != reflect.TypeOf(&discordgo.Session{}) {
+		panic(fmt.Sprintf("invalid handler type %T", handler))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = map[int]interface{}{}
+	}
+	id := s.nextID
+	s.nextID++
+	s.handlers[id] = handler
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+func (s *Session) CurrentUser() *discordgo.User {
+	return s.user
+}
+
+func (s *Session) CurrentApplication() *discordgo.Application {
+	return s.app
+}
+
+func (s *Session) StateGuilds() []*discordgo.Guild {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var out []*discordgo.Guild
+	for _, g := range s.d.guilds {
+		if _, ok := g.members[s.user.ID]; ok {
+			out = append(out, copyGuild(&g.guild))
+		}
+	}
+	return out
+}
+
+func (s *Session) StateRole(guildID, roleID discordgo.Snowflake) (*discordgo.Role, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, discordgo.ErrStateNotFound
+	}
+	for _, r := range g.guild.Roles {
+		if r.ID == roleID {
+			return copyRole(r), nil
+		}
+	}
+	return nil, discordgo.ErrStateNotFound
+}
+
+// memberOf looks up a guild that the bot is a member of. s.d.mu must be held.
+func (s *Session) memberOf(guildID discordgo.Snowflake) (*guild, error) {
+	g, ok := s.d.guilds[guildID]
+	if !ok {
+		return nil, errUnknownGuild()
+	}
+	if _, ok := g.members[s.user.ID]; !ok {
+		return nil, errMissingAccess()
+	}
+	return g, nil
+}
+
+// highestRole returns the position of user's highest role in the guild.
+func (g *guild) highestRole(user discordgo.Snowflake) int {
+	var highest int
+	for _, id := range g.members[user].Roles {
+		for _, r := range g.guild.Roles {
+			if r.ID == id && r.Position > highest {
+				highest = r.Position
+			}
+		}
+	}
+	return highest
+}
+
+func (s *Session) ApplicationCommandBulkOverwrite(appID, guildID discordgo.Snowflake, commands []*discordgo.ApplicationCommand, _ ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if appID != s.app.ID {
+		return nil, errMissingAccess()
+	}
+	var out []*discordgo.ApplicationCommand
+	for _, c := range commands {
+		c := *c
+		c.ID = s.d.newID()
+		c.ApplicationID = appID
+		c.GuildID = guildID
+		out = append(out, &c)
+	}
+	s.d.commands[commandKey{appID, guildID}] = out
+	return slices.Clone(out), nil
+}
+
+func (s *Session) ApplicationCommands(appID, guildID discordgo.Snowflake, _ ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if appID != s.app.ID {
+		return nil, errMissingAccess()
+	}
+	return slices.Clone(s.d.commands[commandKey{appID, guildID}]), nil
+}
+
+func (s *Session) ChannelInviteCreate(channelID discordgo.Snowflake, invite discordgo.Invite, _ ...discordgo.RequestOption) (*discordgo.Invite, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	for _, g := range s.d.guilds {
+		if g.guild.SystemChannelID != channelID {
+			continue
+		}
+		if _, err := s.memberOf(g.guild.ID); err != nil {
+			return nil, err
+		}
+		invite.Code = string(s.d.newID())
+		invite.Guild = copyGuild(&g.guild)
+		invite.Inviter = s.user
+		s.d.invites[invite.Code] = g.guild.ID
+		return &invite, nil
+	}
+	return nil, errUnknownChannel()
+}
+
+// channel reports whether the bot can send messages to channelID. s.d.mu must be held.
+func (s *Session) channel(channelID discordgo.Snowflake) bool {
+	for key, c := range s.d.dms {
+		if c.ID == channelID {
+			return key.bot == s.user.ID
+		}
+	}
+	for _, g := range s.d.guilds {
+		if g.guild.SystemChannelID == channelID {
+			_, ok := g.members[s.user.ID]
+			return ok
+		}
+	}
+	return false
+}
+
+func (s *Session) ChannelMessageDelete(channelID, messageID discordgo.Snowflake, _ ...discordgo.RequestOption) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if !s.channel(channelID) {
+		return errUnknownChannel()
+	}
+	messages := s.d.messages[channelID]
+	i := slices.IndexFunc(messages, func(m *discordgo.Message) bool { return m.ID == messageID })
+	if i < 0 {
+		return errUnknownMessage()
+	}
+	if messages[i].Author.ID != s.user.ID {
+		return errMissingPermissions()
+	}
+	s.d.messages[channelID] = slices.Delete(messages, i, i+1)
+	return nil
+}
+
+func (s *Session) ChannelMessageSend(channelID discordgo.Snowflake, content string, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if !s.channel(channelID) {
+		return nil, errUnknownChannel()
+	}
+	m := &discordgo.Message{ID: s.d.newID(), ChannelID: channelID, Content: content, Author: s.user}
+	s.d.messages[channelID] = append(s.d.messages[channelID], m)
+	c := *m
+	return &c, nil
+}
+
+func (s *Session) ChannelMessageSendComplex(channelID discordgo.Snowflake, data *discordgo.MessageSend, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if !s.channel(channelID) {
+		return nil, errUnknownChannel()
+	}
+	attachments, err := s.d.attachFiles(data.Files)
+	if err != nil {
+		return nil, err
+	}
+	m := &discordgo.Message{ID: s.d.newID(), ChannelID: channelID, Content: data.Content, Author: s.user, Attachments: attachments}
+	s.d.messages[channelID] = append(s.d.messages[channelID], m)
+	c := *m
+	return &c, nil
+}
+
+// interaction looks up the follow-ups for an interaction sent to this bot. s.d.mu must be held.
+func (s *Session) interaction(interaction *discordgo.Interaction) (*followups, error) {
+	f, ok := s.d.followups[interaction.ID]
+	if !ok || interaction.AppID != s.app.ID {
+		return nil, errUnknownInteraction()
+	}
+	return f, nil
+}
+
+func (s *Session) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f, err := s.interaction(interaction)
+	if err != nil {
+		return nil, err
+	}
+	if !f.responded {
+		return nil, errUnknownInteraction()
+	}
+	m := &discordgo.Message{ID: s.d.newID(), ChannelID: interaction.ChannelID, Content: data.Content, Author: s.user}
+	f.messages = append(f.messages, m)
+	if !wait {
+		return nil, nil
+	}
+	c := *m
+	return &c, nil
+}
+
+func (s *Session) FollowupMessageEdit(interaction *discordgo.Interaction, messageID discordgo.Snowflake, data *discordgo.WebhookEdit, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f, err := s.interaction(interaction)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(f.messages, func(m *discordgo.Message) bool { return m.ID == messageID })
+	if i < 0 {
+		return nil, errUnknownMessage()
+	}
+	if data.Content != nil {
+		f.messages[i].Content = *data.Content
+	}
+	if len(data.Files) > 0 {
+		attachments, err := s.d.attachFiles(data.Files)
+		if err != nil {
+			return nil, err
+		}
+		f.messages[i].Attachments = attachments
+	}
+	c := *f.messages[i]
+	return &c, nil
+}
+
+func (s *Session) Guild(guildID discordgo.Snowflake, _ ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return copyGuild(&g.guild), nil
+}
+
+func (s *Session) GuildCreateWithTemplate(templateCode, name, icon string, _ ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	t, ok := s.d.templates[templateCode]
+	if !ok {
+		return nil, errUnknownTemplate()
+	}
+	var roles []string
+	for _, r := range t.SerializedSourceGuild.Roles {
+		if r.Name != "@everyone" {
+			roles = append(roles, r.Name)
+		}
+	}
+	g := s.d.createGuild(name, s.user.ID, roles)
+	return copyGuild(&g.guild), nil
+}
+
+func (s *Session) GuildDelete(guildID discordgo.Snowflake, _ ...discordgo.RequestOption) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return err
+	}
+	if g.guild.OwnerID != s.user.ID {
+		return errMissingPermissions()
+	}
+	delete(s.d.guilds, guildID)
+	for id := range g.members {
+		if bot, ok := s.d.sessions[id]; ok {
+			bot.dispatch(&discordgo.GuildDelete{Guild: copyGuild(&g.guild)})
+		}
+	}
+	return nil
+}
+
+func (s *Session) GuildEdit(guildID discordgo.Snowflake, params *discordgo.GuildParams, _ ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, err
+	}
+	if g.guild.OwnerID != s.user.ID {
+		return nil, errMissingPermissions()
+	}
+	if params.Name != "" {
+		g.guild.Name = params.Name
+	}
+	if params.OwnerID != "" {
+		if _, ok := g.members[params.OwnerID]; !ok {
+			return nil, errUnknownMember()
+		}
+		g.guild.OwnerID = params.OwnerID
+	}
+	return copyGuild(&g.guild), nil
+}
+
+func (s *Session) GuildLeave(guildID discordgo.Snowflake, _ ...discordgo.RequestOption) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return err
+	}
+	if g.guild.OwnerID == s.user.ID {
+		// Owners must transfer or delete the guild instead.
+		return restError(http.StatusBadRequest, discordgo.ErrCodeInvalidGuild, "Invalid Guild")
+	}
+	delete(g.members, s.user.ID)
+	return nil
+}
+
+func (s *Session) GuildMember(guildID, userID discordgo.Snowflake, _ ...discordgo.RequestOption) (*discordgo.Member, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := g.members[userID]
+	if !ok {
+		return nil, errUnknownMember()
+	}
+	return copyMember(m), nil
+}
+
+// GuildMemberRoleAdd grants a role, which must be below the bot's highest role unless the bot owns the guild.
+func (s *Session) GuildMemberRoleAdd(guildID, userID, roleID discordgo.Snowflake, _ ...discordgo.RequestOption) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return err
+	}
+	m, ok := g.members[userID]
+	if !ok {
+		return errUnknownMember()
+	}
+	i := slices.IndexFunc(g.guild.Roles, func(r *discordgo.Role) bool { return r.ID == roleID })
+	if i < 0 {
+		return errUnknownRole()
+	}
+	if g.guild.OwnerID != s.user.ID && g.guild.Roles[i].Position >= g.highestRole(s.user.ID) {
+		return errMissingPermissions()
+	}
+	if !slices.Contains(m.Roles, roleID) {
+		m.Roles = append(m.Roles, roleID)
+	}
+	return nil
+}
+
+// GuildRoleReorder moves roles to the given positions. Only the guild's owner may do this.
+func (s *Session) GuildRoleReorder(guildID discordgo.Snowflake, roles []*discordgo.Role, _ ...discordgo.RequestOption) ([]*discordgo.Role, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, err
+	}
+	if g.guild.OwnerID != s.user.ID {
+		return nil, errMissingPermissions()
+	}
+	for _, update := range roles {
+		i := slices.IndexFunc(g.guild.Roles, func(r *discordgo.Role) bool { return r.ID == update.ID })
+		if i < 0 {
+			return nil, errUnknownRole()
+		}
+		g.guild.Roles[i].Position = update.Position
+	}
+	return copyGuild(&g.guild).Roles, nil
+}
+
+func (s *Session) GuildSoundboardSounds(guildID discordgo.Snowflake, _ ...discordgo.RequestOption) ([]*discord.SoundboardSound, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, err
+	}
+	out := []*discord.SoundboardSound{}
+	for _, sound := range g.sounds {
+		c := *sound
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+// GuildSoundboardSoundCreate uploads a sound, as long as the guild's boost tier leaves a free slot for it.
+func (s *Session) GuildSoundboardSoundCreate(guildID discordgo.Snowflake, params *discord.SoundboardSoundParams, _ ...discordgo.RequestOption) (*discord.SoundboardSound, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return s.d.addSound(g, s.user, params)
+}
+
+func (s *Session) GuildSoundboardSoundDelete(guildID, soundID discordgo.Snowflake, _ ...discordgo.RequestOption) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	g, err := s.memberOf(guildID)
+	if err != nil {
+		return err
+	}
+	return s.d.deleteSound(g, soundID)
+}
+
+func (s *Session) GuildTemplate(templateCode string, _ ...discordgo.RequestOption) (*discordgo.GuildTemplate, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	t, ok := s.d.templates[templateCode]
+	if !ok {
+		return nil, errUnknownTemplate()
+	}
+	c := *t
+	c.SerializedSourceGuild = copyGuild(t.SerializedSourceGuild)
+	return &c, nil
+}
+
+func (s *Session) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, _ ...discordgo.RequestOption) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f, err := s.interaction(interaction)
+	if err != nil {
+		return err
+	}
+	if f.responded {
+		return restError(http.StatusBadRequest, discordgo.ErrCodeInteractionHasAlreadyBeenAcknowledged, "Interaction has already been acknowledged.")
+	}
+	f.responded = true
+	if resp.Type == discordgo.InteractionApplicationCommandAutocompleteResult && resp.Data != nil {
+		f.choices = resp.Data.Choices
+	}
+	if resp.Type == discordgo.InteractionResponseChannelMessageWithSource && resp.Data != nil {
+		f.response = resp.Data.Content
+	}
+	return nil
+}
+
+func (s *Session) UserChannelCreate(recipientID discordgo.Snowflake, _ ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	u, ok := s.d.users[recipientID]
+	if !ok {
+		return nil, restError(http.StatusNotFound, discordgo.ErrCodeUnknownUser, "Unknown User")
+	}
+	key := dmKey{bot: s.user.ID, user: recipientID}
+	c, ok := s.d.dms[key]
+	if !ok {
+		c = &discordgo.Channel{ID: s.d.newID(), Type: discordgo.ChannelTypeDM, Recipients: []*discordgo.User{u}}
+		s.d.dms[key] = c
+	}
+	out := *c
+	return &out, nil
+}
+
+func (s *Session) UserGuilds(limit int, beforeID, afterID discordgo.Snowflake, _ ...discordgo.RequestOption) ([]*discordgo.UserGuild, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var ids []int64
+	for _, g := range s.d.guilds {
+		if _, ok := g.members[s.user.ID]; ok {
+			id, _ := strconv.ParseInt(string(g.guild.ID), 10, 64)
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	before, _ := strconv.ParseInt(string(beforeID), 10, 64)
+	after, _ := strconv.ParseInt(string(afterID), 10, 64)
+	var out []*discordgo.UserGuild
+	for _, id := range ids {
+		if (beforeID != "" && id >= before) || (afterID != "" && id <= after) {
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		g := s.d.guilds[discordgo.Snowflake(strconv.FormatInt(id, 10))]
+		out = append(out, &discordgo.UserGuild{ID: g.guild.ID, Name: g.guild.Name, Owner: g.guild.OwnerID == s.user.ID})
+	}
+	return out, nil
+}
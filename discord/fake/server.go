@@ -0,0 +1,820 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	gatewayPath       = "/gateway"
+	controlPath       = "/fake/"
+	cdnSoundsPath     = "/cdn/soundboard-sounds/"
+	heartbeatInterval = 41250
+)
+
+// Server serves a Discord over HTTP, so that real discordgo sessions can connect to it.
+//
+// It implements the subset of Discord's REST API, CDN and gateway used by the bot, which discordgo is pointed at
+// with discord.SetBaseURL. Bots authenticate with the tokens given to AddBot.
+//
+// Everything else is scripted through a JSON control API under /fake/, so that scenarios can be driven from
+// outside of Go:
+//
+//	POST   /fake/users                          {"name"}                              -> User
+//	POST   /fake/invites/{code}/join            {"user_id"}
+//	POST   /fake/guilds/{guild}/authorize       {"bot", "user_id"}
+//	POST   /fake/interactions                   {"bot", "user_id", "guild_id", "name", "options", "autocomplete"} -> Interaction
+//	GET    /fake/interactions/{interaction}/response                                  -> content
+//	GET    /fake/interactions/{interaction}/followups                                 -> [content]
+//	GET    /fake/interactions/{interaction}/attachments                               -> [MessageAttachment]
+//	GET    /fake/interactions/{interaction}/choices                                   -> [ApplicationCommandOptionChoice]
+//	GET    /fake/users/{user}/messages                                                -> [Message]
+//	GET    /fake/guilds/{guild}                                                       -> Guild
+//	GET    /fake/applications/{app}/commands?guild_id=                                -> [ApplicationCommand]
+//	PUT    /fake/guilds/{guild}/premium_tier    {"tier"}
+//	GET    /fake/guilds/{guild}/sounds                                                -> [SoundboardSound]
+//	POST   /fake/guilds/{guild}/sounds          {"user_id", "name"}                   -> SoundboardSound
+//	PATCH  /fake/guilds/{guild}/sounds/{sound}  {"name"}
+//	DELETE /fake/guilds/{guild}/sounds/{sound}
+//	POST   /fake/attachments                    {"filename", "content_type", "content"} -> MessageAttachment
+//	GET    /fake/attachments/{attachment}/{filename}                                  -> the attachment's content
+//
+// Attachments' content is base64 encoded in JSON. Their IDs can be passed as the value of attachment options.
+//
+// It also stands in for Discord's OAuth2 authorization code grant with the identify scope. Since there is nobody to
+// sign in, /oauth2/authorize asks which user to sign in as, or takes them as its user_id parameter.
+type Server struct {
+	d        *Discord
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	tokens map[string]*Session
+	bots   map[string]*Session
+	// OAuth2 client secrets by application, and the codes and access tokens granted to users.
+	secrets      map[discordgo.Snowflake]string
+	codes        map[string]oauthGrant
+	accessTokens map[string]discordgo.Snowflake
+}
+
+// oauthGrant is an authorization code, which can be exchanged once by the application it was granted to.
+type oauthGrant struct {
+	user        discordgo.Snowflake
+	clientID    discordgo.Snowflake
+	redirectURI string
+}
+
+// NewServer serves d.
+func NewServer(d *Discord) *Server {
+	return &Server{
+		d:      d,
+		tokens: map[string]*Session{},
+		bots:   map[string]*Session{},
+
+		secrets:      map[discordgo.Snowflake]string{},
+		codes:        map[string]oauthGrant{},
+		accessTokens: map[string]discordgo.Snowflake{},
+	}
+}
+
+// SetClientSecret sets the OAuth2 client secret of the bot's application.
+func (s *Server) SetClientSecret(bot *Session, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[bot.app.ID] = secret
+}
+
+// AddBot creates a bot which authenticates with token.
+func (s *Server) AddBot(name, token string) *Session {
+	bot := s.d.AddBot(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens["Bot "+token] = bot
+	s.bots[name] = bot
+	return bot
+}
+
+// SetToken replaces the bot's token, as if it had been reset. The old token stops working.
+func (s *Server) SetToken(bot *Session, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, b := range s.tokens {
+		if b == bot {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens["Bot "+token] = bot
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api := "/api/v" + discordgo.APIVersion + "/"
+	switch {
+	case strings.HasPrefix(r.URL.Path, gatewayPath):
+		s.serveGateway(w, r)
+	case strings.HasPrefix(r.URL.Path, controlPath+"attachments/") && r.Method == http.MethodGet:
+		s.serveAttachment(w, r, segments(strings.TrimPrefix(r.URL.Path, controlPath+"attachments/")))
+	case strings.HasPrefix(r.URL.Path, cdnSoundsPath) && r.Method == http.MethodGet:
+		s.serveSoundContent(w, r, segments(strings.TrimPrefix(r.URL.Path, cdnSoundsPath)))
+	case strings.HasPrefix(r.URL.Path, controlPath):
+		s.serveControl(w, r, segments(strings.TrimPrefix(r.URL.Path, controlPath)))
+	case r.URL.Path == "/oauth2/authorize":
+		s.serveAuthorize(w, r)
+	case strings.HasPrefix(r.URL.Path, api):
+		s.serveREST(w, r, segments(strings.TrimPrefix(r.URL.Path, api)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// segments splits a path into its non-empty parts, since discordgo builds some endpoints with a double slash.
+func segments(path string) []string {
+	var out []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// match reports whether path has the same shape as pattern, where "*" matches any single segment.
+// The segments matched by "*" are returned in order.
+func match(path []string, pattern ...string) ([]string, bool) {
+	if len(path) != len(pattern) {
+		return nil, false
+	}
+	var params []string
+	for i, p := range pattern {
+		switch p {
+		case "*":
+			params = append(params, path[i])
+		case path[i]:
+		default:
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// respond writes a JSON response, or Discord's error response if err is a *discordgo.RESTError.
+func respond(w http.ResponseWriter, body interface{}, err error) {
+	if err != nil {
+		var restErr *discordgo.RESTError
+		if !errors.As(err, &restErr) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(restErr.Response.StatusCode)
+		w.Write(restErr.ResponseBody)
+		return
+	}
+	if body == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		klog.Errorf("failed to write response: %v", err)
+	}
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return restError(http.StatusBadRequest, discordgo.ErrCodeTheRequestBodyContainsInvalidJSON, "The request body contains invalid JSON.")
+	}
+	return nil
+}
+
+// decodeMessage decodes a message sent as JSON, or as multipart/form-data with files attached, as discordgo sends
+// messages with files.
+func decodeMessage(r *http.Request, v interface{}) ([]*discordgo.File, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+		return nil, decode(r, v)
+	}
+	invalid := restError(http.StatusBadRequest, discordgo.ErrCodeTheRequestBodyContainsInvalidJSON, "The request body contains invalid JSON.")
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, invalid
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("payload_json")), v); err != nil {
+		return nil, invalid
+	}
+	var fields []string
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var files []*discordgo.File
+	for _, field := range fields {
+		for _, header := range r.MultipartForm.File[field] {
+			f, err := header.Open()
+			if err != nil {
+				return nil, invalid
+			}
+			content, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, invalid
+			}
+			files = append(files, &discordgo.File{Name: header.Filename, ContentType: header.Header.Get("Content-Type"), Reader: bytes.NewReader(content)})
+		}
+	}
+	return files, nil
+}
+
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request, path []string) {
+	if r.Method == http.MethodGet && len(path) >= 1 && path[0] == "gateway" {
+		scheme := "ws"
+		if r.TLS != nil {
+			scheme = "wss"
+		}
+		respond(w, map[string]string{"url": fmt.Sprintf("%s://%s%s", scheme, r.Host, gatewayPath)}, nil)
+		return
+	}
+	if _, ok := match(path, "oauth2", "token"); ok && r.Method == http.MethodPost {
+		body, err := s.exchangeCode(r)
+		respond(w, body, err)
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		body, err := s.serveBearer(r, path)
+		respond(w, body, err)
+		return
+	}
+	s.mu.Lock()
+	bot, ok := s.tokens[r.Header.Get("Authorization")]
+	s.mu.Unlock()
+	if !ok {
+		respond(w, nil, restError(http.StatusUnauthorized, 0, "401: Unauthorized"))
+		return
+	}
+	body, err := bot.serveREST(r, path)
+	respond(w, body, err)
+}
+
+// serveREST calls the Session method which corresponds to the request.
+func (s *Session) serveREST(r *http.Request, path []string) (interface{}, error) {
+	m := r.Method
+	if p, ok := match(path, "applications", "*", "commands"); ok {
+		return s.commandsREST(r, discordgo.Snowflake(p[0]), "")
+	}
+	if p, ok := match(path, "applications", "*", "guilds", "*", "commands"); ok {
+		return s.commandsREST(r, discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]))
+	}
+	if p, ok := match(path, "channels", "*", "invites"); ok && m == http.MethodPost {
+		var invite discordgo.Invite
+		if err := decode(r, &invite); err != nil {
+			return nil, err
+		}
+		return s.ChannelInviteCreate(discordgo.Snowflake(p[0]), invite)
+	}
+	if p, ok := match(path, "channels", "*", "messages"); ok && m == http.MethodPost {
+		var msg discordgo.MessageSend
+		files, err := decodeMessage(r, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			msg.Files = files
+			return s.ChannelMessageSendComplex(discordgo.Snowflake(p[0]), &msg)
+		}
+		return s.ChannelMessageSend(discordgo.Snowflake(p[0]), msg.Content)
+	}
+	if p, ok := match(path, "channels", "*", "messages", "*"); ok && m == http.MethodDelete {
+		return nil, s.ChannelMessageDelete(discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]))
+	}
+	if p, ok := match(path, "guilds", "templates", "*"); ok {
+		switch m {
+		case http.MethodGet:
+			return s.GuildTemplate(p[0])
+		case http.MethodPost:
+			var params struct {
+				Name string `json:"name"`
+				Icon string `json:"icon"`
+			}
+			if err := decode(r, &params); err != nil {
+				return nil, err
+			}
+			return s.GuildCreateWithTemplate(p[0], params.Name, params.Icon)
+		}
+	}
+	if p, ok := match(path, "guilds", "*"); ok {
+		switch m {
+		case http.MethodGet:
+			return s.Guild(discordgo.Snowflake(p[0]))
+		case http.MethodPatch:
+			var params discordgo.GuildParams
+			if err := decode(r, &params); err != nil {
+				return nil, err
+			}
+			return s.GuildEdit(discordgo.Snowflake(p[0]), &params)
+		case http.MethodDelete:
+			return nil, s.GuildDelete(discordgo.Snowflake(p[0]))
+		}
+	}
+	if p, ok := match(path, "guilds", "*", "soundboard-sounds"); ok {
+		switch m {
+		case http.MethodGet:
+			sounds, err := s.GuildSoundboardSounds(discordgo.Snowflake(p[0]))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"items": sounds}, nil
+		case http.MethodPost:
+			var params discord.SoundboardSoundParams
+			if err := decode(r, &params); err != nil {
+				return nil, err
+			}
+			return s.GuildSoundboardSoundCreate(discordgo.Snowflake(p[0]), &params)
+		}
+	}
+	if p, ok := match(path, "guilds", "*", "soundboard-sounds", "*"); ok && m == http.MethodDelete {
+		return nil, s.GuildSoundboardSoundDelete(discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]))
+	}
+	if p, ok := match(path, "guilds", "*", "members", "*"); ok && m == http.MethodGet {
+		return s.GuildMember(discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]))
+	}
+	if p, ok := match(path, "guilds", "*", "members", "*", "roles", "*"); ok && m == http.MethodPut {
+		return nil, s.GuildMemberRoleAdd(discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]), discordgo.Snowflake(p[2]))
+	}
+	if p, ok := match(path, "guilds", "*", "roles"); ok && m == http.MethodPatch {
+		var roles []*discordgo.Role
+		if err := decode(r, &roles); err != nil {
+			return nil, err
+		}
+		return s.GuildRoleReorder(discordgo.Snowflake(p[0]), roles)
+	}
+	if p, ok := match(path, "interactions", "*", "*", "callback"); ok && m == http.MethodPost {
+		var resp discordgo.InteractionResponse
+		if err := decode(r, &resp); err != nil {
+			return nil, err
+		}
+		return nil, s.InteractionRespond(&discordgo.Interaction{ID: discordgo.Snowflake(p[0]), AppID: s.app.ID, Token: p[1]}, &resp)
+	}
+	if _, ok := match(path, "users", "@me", "channels"); ok && m == http.MethodPost {
+		var params struct {
+			RecipientID discordgo.Snowflake `json:"recipient_id"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		return s.UserChannelCreate(params.RecipientID)
+	}
+	if _, ok := match(path, "users", "@me", "guilds"); ok && m == http.MethodGet {
+		q := r.URL.Query()
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		return s.UserGuilds(limit, discordgo.Snowflake(q.Get("before")), discordgo.Snowflake(q.Get("after")))
+	}
+	if p, ok := match(path, "users", "@me", "guilds", "*"); ok && m == http.MethodDelete {
+		return nil, s.GuildLeave(discordgo.Snowflake(p[0]))
+	}
+	if p, ok := match(path, "webhooks", "*", "*"); ok && m == http.MethodPost {
+		var params discordgo.WebhookParams
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		// Interaction tokens are the interaction's ID.
+		msg, err := s.FollowupMessageCreate(&discordgo.Interaction{ID: discordgo.Snowflake(p[1]), AppID: discordgo.Snowflake(p[0]), Token: p[1]}, r.URL.Query().Get("wait") == "true", &params)
+		if msg == nil {
+			return nil, err
+		}
+		return msg, err
+	}
+	if p, ok := match(path, "webhooks", "*", "*", "messages", "*"); ok && m == http.MethodPatch {
+		var params discordgo.WebhookEdit
+		files, err := decodeMessage(r, &params)
+		if err != nil {
+			return nil, err
+		}
+		params.Files = files
+		return s.FollowupMessageEdit(&discordgo.Interaction{ID: discordgo.Snowflake(p[1]), AppID: discordgo.Snowflake(p[0]), Token: p[1]}, discordgo.Snowflake(p[2]), &params)
+	}
+	return nil, restError(http.StatusNotFound, 0, "404: Not Found")
+}
+
+func (s *Session) commandsREST(r *http.Request, appID, guildID discordgo.Snowflake) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		return s.ApplicationCommands(appID, guildID)
+	case http.MethodPut:
+		var commands []*discordgo.ApplicationCommand
+		if err := decode(r, &commands); err != nil {
+			return nil, err
+		}
+		return s.ApplicationCommandBulkOverwrite(appID, guildID, commands)
+	}
+	return nil, restError(http.StatusMethodNotAllowed, 0, "405: Method Not Allowed")
+}
+
+// gatewayPayload is a message sent over the gateway, see https://discord.com/developers/docs/topics/gateway-events#payload-structure.
+type gatewayPayload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d"`
+	Sequence int64           `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+// gatewayConn is a bot's connection to the gateway.
+type gatewayConn struct {
+	ws *websocket.Conn
+
+	mu       sync.Mutex
+	sequence int64
+}
+
+func (c *gatewayConn) send(op int, t string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := gatewayPayload{Op: op, Data: raw, Type: t}
+	if op == 0 {
+		c.sequence++
+		p.Sequence = c.sequence
+	}
+	return c.ws.WriteJSON(p)
+}
+
+func (c *gatewayConn) close(code int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
+func (c *gatewayConn) dispatch(t string, data interface{}) {
+	if err := c.send(0, t, data); err != nil {
+		klog.Errorf("failed to dispatch %s: %v", t, err)
+	}
+}
+
+// serveGateway speaks just enough of the gateway protocol for discordgo: HELLO, IDENTIFY (or RESUME), READY,
+// heartbeats and dispatching the events which the bot handles.
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		klog.Errorf("failed to upgrade gateway connection: %v", err)
+		return
+	}
+	defer ws.Close()
+	c := &gatewayConn{ws: ws}
+	if err := c.send(10, "", map[string]int{"heartbeat_interval": heartbeatInterval}); err != nil {
+		klog.Errorf("failed to send hello: %v", err)
+		return
+	}
+	var bot *Session
+	defer func() {
+		if bot != nil {
+			bot.Close()
+		}
+	}()
+	for {
+		var p gatewayPayload
+		if err := ws.ReadJSON(&p); err != nil {
+			return
+		}
+		switch p.Op {
+		case 1: // Heartbeat
+			if err := c.send(11, "", nil); err != nil {
+				return
+			}
+		case 2, 6: // Identify, Resume
+			if bot != nil {
+				c.close(4005, "Already authenticated.")
+				return
+			}
+			var auth struct {
+				Token string `json:"token"`
+			}
+			json.Unmarshal(p.Data, &auth)
+			s.mu.Lock()
+			bot = s.tokens[auth.Token]
+			s.mu.Unlock()
+			if bot == nil {
+				c.close(4004, "Authentication failed.")
+				return
+			}
+			c.dispatch("READY", &discordgo.Ready{
+				Version:     9,
+				SessionID:   string(bot.user.ID),
+				User:        bot.user,
+				Application: bot.app,
+			})
+			for _, remove := range []func(){
+				bot.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildCreate) { c.dispatch("GUILD_CREATE", e) }),
+				bot.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildDelete) { c.dispatch("GUILD_DELETE", e) }),
+				bot.AddHandler(func(_ *discordgo.Session, e *discordgo.GuildMemberAdd) { c.dispatch("GUILD_MEMBER_ADD", e) }),
+				bot.AddHandler(func(_ *discordgo.Session, e *discordgo.InteractionCreate) { c.dispatch("INTERACTION_CREATE", e) }),
+				bot.AddHandler(func(_ *discordgo.Session, e *discord.SoundboardSoundCreate) {
+					c.dispatch(discord.SoundboardSoundCreateEventType, e)
+				}),
+				bot.AddHandler(func(_ *discordgo.Session, e *discord.SoundboardSoundUpdate) {
+					c.dispatch(discord.SoundboardSoundUpdateEventType, e)
+				}),
+				bot.AddHandler(func(_ *discordgo.Session, e *discord.SoundboardSoundDelete) {
+					c.dispatch(discord.SoundboardSoundDeleteEventType, e)
+				}),
+			} {
+				defer remove()
+			}
+			bot.Open()
+		}
+	}
+}
+
+func (s *Server) bot(name string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bot, ok := s.bots[name]
+	if !ok {
+		return nil, restError(http.StatusNotFound, discordgo.ErrCodeUnknownUser, "Unknown User")
+	}
+	return bot, nil
+}
+
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request, path []string) {
+	body, err := s.control(r, path)
+	respond(w, body, err)
+}
+
+// control performs the request to the /fake/ control API.
+func (s *Server) control(r *http.Request, path []string) (interface{}, error) {
+	m := r.Method
+	if _, ok := match(path, "users"); ok && m == http.MethodPost {
+		var params struct {
+			Name string `json:"name"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		return s.d.AddUser(params.Name), nil
+	}
+	if p, ok := match(path, "invites", "*", "join"); ok && m == http.MethodPost {
+		var params struct {
+			UserID discordgo.Snowflake `json:"user_id"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.d.JoinInvite(p[0], params.UserID)
+	}
+	if p, ok := match(path, "guilds", "*", "authorize"); ok && m == http.MethodPost {
+		var params struct {
+			Bot    string              `json:"bot"`
+			UserID discordgo.Snowflake `json:"user_id"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		bot, err := s.bot(params.Bot)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.d.Authorize(bot, discordgo.Snowflake(p[0]), params.UserID)
+	}
+	if _, ok := match(path, "interactions"); ok && m == http.MethodPost {
+		var params struct {
+			Bot     string                                               `json:"bot"`
+			UserID  discordgo.Snowflake                                  `json:"user_id"`
+			GuildID discordgo.Snowflake                                  `json:"guild_id"`
+			Name    string                                               `json:"name"`
+			Options []*discordgo.ApplicationCommandInteractionDataOption `json:"options"`
+			// Autocomplete sends an autocomplete interaction, for the option marked focused.
+			Autocomplete bool `json:"autocomplete"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		bot, err := s.bot(params.Bot)
+		if err != nil {
+			return nil, err
+		}
+		if params.Autocomplete {
+			return s.d.Autocomplete(bot, params.UserID, params.GuildID, params.Name, params.Options...), nil
+		}
+		return s.d.Interact(bot, params.UserID, params.GuildID, params.Name, params.Options...), nil
+	}
+	if p, ok := match(path, "interactions", "*", "choices"); ok && m == http.MethodGet {
+		return append([]*discordgo.ApplicationCommandOptionChoice{}, s.d.Choices(discordgo.Snowflake(p[0]))...), nil
+	}
+	if p, ok := match(path, "interactions", "*", "attachments"); ok && m == http.MethodGet {
+		return append([]*discordgo.MessageAttachment{}, s.d.FollowupAttachments(discordgo.Snowflake(p[0]))...), nil
+	}
+	if p, ok := match(path, "interactions", "*", "response"); ok && m == http.MethodGet {
+		return s.d.Response(discordgo.Snowflake(p[0])), nil
+	}
+	if p, ok := match(path, "interactions", "*", "followups"); ok && m == http.MethodGet {
+		return append([]string{}, s.d.Followups(discordgo.Snowflake(p[0]))...), nil
+	}
+	if p, ok := match(path, "users", "*", "messages"); ok && m == http.MethodGet {
+		return append([]*discordgo.Message{}, s.d.DirectMessages(discordgo.Snowflake(p[0]))...), nil
+	}
+	if p, ok := match(path, "guilds", "*"); ok && m == http.MethodGet {
+		g, ok := s.d.Guild(discordgo.Snowflake(p[0]))
+		if !ok {
+			return nil, errUnknownGuild()
+		}
+		return g, nil
+	}
+	if p, ok := match(path, "applications", "*", "commands"); ok && m == http.MethodGet {
+		return append([]*discordgo.ApplicationCommand{}, s.d.Commands(discordgo.Snowflake(p[0]), discordgo.Snowflake(r.URL.Query().Get("guild_id")))...), nil
+	}
+	if p, ok := match(path, "guilds", "*", "premium_tier"); ok && m == http.MethodPut {
+		var params struct {
+			Tier discordgo.PremiumTier `json:"tier"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.d.SetPremiumTier(discordgo.Snowflake(p[0]), params.Tier)
+	}
+	if p, ok := match(path, "guilds", "*", "sounds"); ok {
+		switch m {
+		case http.MethodGet:
+			return append([]*discord.SoundboardSound{}, s.d.Sounds(discordgo.Snowflake(p[0]))...), nil
+		case http.MethodPost:
+			var params struct {
+				UserID discordgo.Snowflake `json:"user_id"`
+				Name   string              `json:"name"`
+			}
+			if err := decode(r, &params); err != nil {
+				return nil, err
+			}
+			return s.d.AddSound(discordgo.Snowflake(p[0]), params.UserID, params.Name)
+		}
+	}
+	if p, ok := match(path, "guilds", "*", "sounds", "*"); ok {
+		switch m {
+		case http.MethodPatch:
+			var params struct {
+				Name string `json:"name"`
+			}
+			if err := decode(r, &params); err != nil {
+				return nil, err
+			}
+			return nil, s.d.UpdateSound(discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]), params.Name)
+		case http.MethodDelete:
+			return nil, s.d.DeleteSound(discordgo.Snowflake(p[0]), discordgo.Snowflake(p[1]))
+		}
+	}
+	if _, ok := match(path, "attachments"); ok && m == http.MethodPost {
+		var params struct {
+			Filename    string `json:"filename"`
+			ContentType string `json:"content_type"`
+			Content     []byte `json:"content"`
+		}
+		if err := decode(r, &params); err != nil {
+			return nil, err
+		}
+		return s.d.AddAttachment(params.Filename, params.ContentType, params.Content), nil
+	}
+	return nil, restError(http.StatusNotFound, 0, "404: Not Found")
+}
+
+// serveAttachment serves an attachment's content, as Discord's CDN would.
+func (s *Server) serveAttachment(w http.ResponseWriter, r *http.Request, path []string) {
+	if len(path) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	content, ok := s.d.Attachment(discordgo.Snowflake(path[0]))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+// serveSoundContent serves a soundboard sound's content, as Discord's CDN does.
+func (s *Server) serveSoundContent(w http.ResponseWriter, r *http.Request, path []string) {
+	if len(path) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	content, contentType, ok := s.d.SoundContent(discordgo.Snowflake(path[0]))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html><body><form method="get" action="/oauth2/authorize">
+{{range $k, $v := .Query}}{{if ne $k "user_id"}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}{{end}}
+Sign in as <select name="user_id">{{range .Users}}<option value="{{.ID}}">{{.Username}}</option>{{end}}</select>
+<button>Authorize</button>
+</form></body></html>`))
+
+// serveAuthorize grants an authorization code to the user given by user_id, and sends them back to the application.
+func (s *Server) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	userID := discordgo.Snowflake(q.Get("user_id"))
+	if userID == "" {
+		s.d.mu.Lock()
+		var users []*discordgo.User
+		for _, u := range s.d.users {
+			if !u.Bot {
+				users = append(users, u)
+			}
+		}
+		s.d.mu.Unlock()
+		sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		authorizePage.Execute(w, struct {
+			Query url.Values
+			Users []*discordgo.User
+		}{q, users})
+		return
+	}
+	if q.Get("response_type") != "code" {
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.d.mu.Lock()
+	_, userOK := s.d.users[userID]
+	_, appOK := s.d.sessions[discordgo.Snowflake(q.Get("client_id"))]
+	code := string(s.d.newID())
+	s.d.mu.Unlock()
+	if !userOK || !appOK {
+		http.Error(w, "unknown user or client_id", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.codes[code] = oauthGrant{user: userID, clientID: discordgo.Snowflake(q.Get("client_id")), redirectURI: q.Get("redirect_uri")}
+	s.mu.Unlock()
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// exchangeCode swaps an authorization code for an access token, which can only be done once per code.
+func (s *Server) exchangeCode(r *http.Request) (interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, restError(http.StatusBadRequest, 0, "invalid_request")
+	}
+	s.d.mu.Lock()
+	token := string(s.d.newID())
+	s.d.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grant, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	clientID := discordgo.Snowflake(r.PostForm.Get("client_id"))
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok || grant.clientID != clientID || grant.redirectURI != r.PostForm.Get("redirect_uri") {
+		return nil, restError(http.StatusBadRequest, 0, "invalid_grant")
+	}
+	if secret, ok := s.secrets[clientID]; !ok || secret != r.PostForm.Get("client_secret") {
+		return nil, restError(http.StatusUnauthorized, 0, "invalid_client")
+	}
+	s.accessTokens[token] = grant.user
+	return map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   604800,
+		"scope":        "identify",
+	}, nil
+}
+
+// serveBearer serves the requests which users' access tokens can make.
+func (s *Server) serveBearer(r *http.Request, path []string) (interface{}, error) {
+	s.mu.Lock()
+	userID, ok := s.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	s.mu.Unlock()
+	if !ok {
+		return nil, restError(http.StatusUnauthorized, 0, "401: Unauthorized")
+	}
+	if _, ok := match(path, "users", "@me"); ok && r.Method == http.MethodGet {
+		s.d.mu.Lock()
+		defer s.d.mu.Unlock()
+		return s.d.users[userID], nil
+	}
+	return nil, restError(http.StatusNotFound, 0, "404: Not Found")
+}
@@ -0,0 +1,162 @@
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+)
+
+// discordgo doesn't support guild soundboards yet, so their API is called directly and their gateway events are
+// decoded from discordgo.Event, see https://discord.com/developers/docs/resources/soundboard.
+
+// SoundboardSound is a sound in a guild's soundboard.
+type SoundboardSound struct {
+	ID        discordgo.Snowflake `json:"sound_id"`
+	GuildID   discordgo.Snowflake `json:"guild_id,omitempty"`
+	Name      string              `json:"name"`
+	Volume    float64             `json:"volume"`
+	EmojiID   discordgo.Snowflake `json:"emoji_id,omitempty"`
+	EmojiName string              `json:"emoji_name,omitempty"`
+	Available bool                `json:"available"`
+	// User is who uploaded the sound.
+	User *discordgo.User `json:"user,omitempty"`
+}
+
+// SoundboardSoundParams describes a sound to upload.
+type SoundboardSoundParams struct {
+	Name string `json:"name"`
+	// Sound is the MP3 or Ogg data, as a data URI such as data:audio/mpeg;base64,...
+	Sound     string              `json:"sound"`
+	Volume    *float64            `json:"volume,omitempty"`
+	EmojiID   discordgo.Snowflake `json:"emoji_id,omitempty"`
+	EmojiName string              `json:"emoji_name,omitempty"`
+}
+
+// SoundboardSoundCreate is sent when a sound is added to a guild's soundboard.
+type SoundboardSoundCreate struct {
+	*SoundboardSound
+}
+
+// SoundboardSoundUpdate is sent when a sound in a guild's soundboard is edited.
+type SoundboardSoundUpdate struct {
+	*SoundboardSound
+}
+
+// SoundboardSoundDelete is sent when a sound is removed from a guild's soundboard.
+type SoundboardSoundDelete struct {
+	SoundID discordgo.Snowflake `json:"sound_id"`
+	GuildID discordgo.Snowflake `json:"guild_id"`
+}
+
+// The gateway event types of the soundboard events.
+const (
+	SoundboardSoundCreateEventType = "GUILD_SOUNDBOARD_SOUND_CREATE"
+	SoundboardSoundUpdateEventType = "GUILD_SOUNDBOARD_SOUND_UPDATE"
+	SoundboardSoundDeleteEventType = "GUILD_SOUNDBOARD_SOUND_DELETE"
+)
+
+// soundboardHandler adapts handlers of soundboard events to discordgo, which only passes them on as
+// discordgo.Event. Other handlers are returned as they are.
+func soundboardHandler(h interface{}) interface{} {
+	switch h := h.(type) {
+	case func(*discordgo.Session, *SoundboardSoundCreate):
+		return onEvent(SoundboardSoundCreateEventType, h)
+	case func(*discordgo.Session, *SoundboardSoundUpdate):
+		return onEvent(SoundboardSoundUpdateEventType, h)
+	case func(*discordgo.Session, *SoundboardSoundDelete):
+		return onEvent(SoundboardSoundDeleteEventType, h)
+	}
+	return h
+}
+
+func onEvent[T any](eventType string, h func(*discordgo.Session, *T)) func(*discordgo.Session, *discordgo.Event) {
+	return func(s *discordgo.Session, e *discordgo.Event) {
+		if e.Type != eventType {
+			return
+		}
+		event := new(T)
+		if err := unmarshal(e.RawData, event); err != nil {
+			klog.ErrorS(err, "Failed to decode event", "type", e.Type)
+			return
+		}
+		h(s, event)
+	}
+}
+
+const (
+	// ErrCodeUnknownSound is returned for sounds which don't exist.
+	ErrCodeUnknownSound = 10097
+	// ErrCodeMaximumSoundboardSounds is returned when a guild has no free soundboard slots.
+	ErrCodeMaximumSoundboardSounds = 30045
+)
+
+// MaxSoundboardSounds returns how many sounds a guild's soundboard can hold at its boost tier.
+func MaxSoundboardSounds(tier discordgo.PremiumTier) int {
+	switch tier {
+	case discordgo.PremiumTier1:
+		return 24
+	case discordgo.PremiumTier2:
+		return 36
+	case discordgo.PremiumTier3:
+		return 48
+	}
+	return 8
+}
+
+// EndpointGuildSoundboardSounds is the endpoint for a guild's soundboard sounds.
+func EndpointGuildSoundboardSounds(guildID discordgo.Snowflake) string {
+	return discordgo.EndpointGuilds + string(guildID) + "/soundboard-sounds"
+}
+
+// EndpointGuildSoundboardSound is the endpoint for a sound in a guild's soundboard.
+func EndpointGuildSoundboardSound(guildID, soundID discordgo.Snowflake) string {
+	return EndpointGuildSoundboardSounds(guildID) + "/" + string(soundID)
+}
+
+// SoundboardSoundURL is where a sound's MP3 or Ogg data can be downloaded from, without authentication.
+func SoundboardSoundURL(soundID discordgo.Snowflake) string {
+	return discordgo.EndpointCDN + "soundboard-sounds/" + string(soundID)
+}
+
+func unmarshal(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %w", discordgo.ErrJSONUnmarshal, err)
+	}
+	return nil
+}
+
+func (s *session) GuildSoundboardSounds(guildID discordgo.Snowflake, options ...discordgo.RequestOption) ([]*SoundboardSound, error) {
+	endpoint := EndpointGuildSoundboardSounds(guildID)
+	body, err := s.current().RequestWithBucketID(http.MethodGet, endpoint, nil, endpoint, options...)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Items []*SoundboardSound `json:"items"`
+	}
+	if err := unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
+
+func (s *session) GuildSoundboardSoundCreate(guildID discordgo.Snowflake, params *SoundboardSoundParams, options ...discordgo.RequestOption) (*SoundboardSound, error) {
+	endpoint := EndpointGuildSoundboardSounds(guildID)
+	body, err := s.current().RequestWithBucketID(http.MethodPost, endpoint, params, endpoint, options...)
+	if err != nil {
+		return nil, err
+	}
+	var sound SoundboardSound
+	if err := unmarshal(body, &sound); err != nil {
+		return nil, err
+	}
+	return &sound, nil
+}
+
+func (s *session) GuildSoundboardSoundDelete(guildID, soundID discordgo.Snowflake, options ...discordgo.RequestOption) error {
+	_, err := s.current().RequestWithBucketID(http.MethodDelete, EndpointGuildSoundboardSound(guildID, soundID), nil, EndpointGuildSoundboardSounds(guildID), options...)
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var ErrUnknownLogFormat = errors.New("unknown log format")
+
+// setupLogging sends klog's output to stderr in the given format, which loadConfig has already checked.
+func setupLogging(format string) {
+	if format != logFormatJSON {
+		return
+	}
+	// klog still applies -v and -vmodule, so every line that reaches the handler is written.
+	klog.SetLogger(logr.FromSlogHandler(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(-127)})))
+}
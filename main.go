@@ -1,25 +1,47 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/kagadar/soundboardbot/db"
 	"github.com/kagadar/soundboardbot/soundboard"
+	"github.com/kagadar/soundboardbot/tracing"
 	"k8s.io/klog/v2"
 )
 
 var (
-	admins             = flag.String("admins", "kagadar", "Comma-separated list of bot admins")
-	creatorAccessToken = flag.String("creator_access_token", "", "Token used by Creator to access Discord")
-	creatorAppID       = flag.String("creator_app_id", "1132277255410831360", "The Creator's App ID")
-	managerAccessToken = flag.String("manager_access_token", "", "Token used by the Soundboard Manager to access Discord")
-	managerAppID       = flag.String("manager_app_id", "1131203534117937182", "The Soundboard Manager's App ID")
-	template           = flag.String("soundboard_server_template", "qFRRy4yyx5Da", "The Server Template to use when creating a new soundboard")
+	admins                = flag.String("admins", "kagadar", "Comma-separated list of bot admins")
+	adminGuilds           = flag.String("admin_guilds", "", "Comma-separated list of guilds to register admin commands in. Admin commands are registered globally if empty. Run register-commands to clear them from guilds removed from this list")
+	backupDir             = flag.String("backup_dir", "", "Directory to back up every sound to, on /backup-sounds and every backup_interval. Backups are disabled if empty")
+	backupInterval        = flag.Duration("backup_interval", 0, "How often to back up every sound to backup_dir, or 0 to only back them up on /backup-sounds")
+	backupRetention       = flag.Duration("backup_retention", 0, "How long to keep backups for, or 0 to keep them forever. The latest backup is always kept")
+	configPath            = flag.String("config", "", "Path to a YAML config file, which overrides flags and is reloaded on SIGHUP. Tokens can also be given by the SOUNDBOARD_CREATOR_ACCESS_TOKEN and SOUNDBOARD_MANAGER_ACCESS_TOKEN environment variables")
+	creatorAccessToken    = flag.String("creator_access_token", "", "Token used by Creator to access Discord, as file:<path>, env:<variable> or <provider>:<ref>. Tokens given directly are visible to other local users")
+	creatorAppID          = flag.String("creator_app_id", "1132277255410831360", "The Creator's App ID")
+	dashboardAddress      = flag.String("dashboard_address", "", "Address to serve the admin dashboard on. Not served if empty")
+	dashboardURL          = flag.String("dashboard_url", "", "Public URL of the admin dashboard. <url>/callback must be an OAuth2 redirect of the Soundboard Manager's application")
+	dashboardClientSecret = flag.String("dashboard_client_secret", "", "The Soundboard Manager's OAuth2 client secret, used to sign admins in to the dashboard, as file:<path>, env:<variable> or <provider>:<ref>. Can also be given by the SOUNDBOARD_DASHBOARD_CLIENT_SECRET environment variable")
+	dbPath                = flag.String("db_path", "", "Path to the bot's database. Uses ~/.soundboardbot/db if empty")
+	disabledCommands      = flag.String("disabled_commands", "", "Comma-separated list of commands to disable")
+	discordURL            = flag.String("discord_url", "", "Base URL of the Discord API, such as a fake Discord server. Uses https://discord.com/ if empty")
+	exportDir             = flag.String("export_dir", "", "Directory to write /export-sounds archives to when asked to, or when they are too large to attach. Exports are only attached if empty")
+	interactionsAddress   = flag.String("interactions_address", "", "Address to receive interactions on over HTTP, at /interactions. Interactions are received through the gateway if empty")
+	interactionsPublicKey = flag.String("interactions_public_key", "", "The Soundboard Manager's public key, used to verify interactions received over HTTP")
+	logFormat             = flag.String("log_format", logFormatText, "Format of log lines: text, or json for one object per line with structured fields such as correlationID")
+	managerAccessToken    = flag.String("manager_access_token", "", "Token used by the Soundboard Manager to access Discord, as file:<path>, env:<variable> or <provider>:<ref>. Tokens given directly are visible to other local users")
+	managerAppID          = flag.String("manager_app_id", "1131203534117937182", "The Soundboard Manager's App ID")
+	maxSoundboards        = flag.Int("max_soundboards", 10, "The most soundboards to create automatically when every soundboard is full, or 0 for no limit")
+	monitoringAddress     = flag.String("monitoring_address", "", "Address to serve /healthz, /readyz and Prometheus /metrics on. Not served if empty")
+	overflowOwner         = flag.String("overflow_owner", "", "ID of the user to give a new soundboard to when a sound is added while every soundboard is full, or when an import from the command line has more sounds than they have room for. Sounds are turned away instead if empty")
+	rateLimits            = flag.String("rate_limits", "user:*:10/1m,user:invite-me:2/5m,user:fix-roles:2/5m", "Comma-separated list of command rate limits, in the form scope:command:burst/every. Scope is one of user, command or guild, and a command of * applies to all commands")
+	traceExporter         = flag.String("trace_exporter", "", "Where to export OpenTelemetry traces: stdout, stdout:<path>, otlp (configured by OTEL_EXPORTER_OTLP_* variables) or otlp:<host:port>. Traces are not exported if empty")
+	template              = flag.String("soundboard_server_template", "qFRRy4yyx5Da", "The Server Template to use when creating a new soundboard")
 )
 
 func init() {
@@ -27,25 +49,86 @@ func init() {
 	flag.Parse()
 }
 
+func splitList(list string) []string {
+	if list = strings.ReplaceAll(list, " ", ""); list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
 func main() {
-	db, err := db.New()
+	config, process, err := loadConfig(*configPath)
 	if err != nil {
 		klog.Fatal(err)
 	}
-	bot, err := soundboard.New(soundboard.Config{
-		Admins:             strings.Split(strings.ReplaceAll(*admins, " ", ""), ","),
-		CreatorAccessToken: *creatorAccessToken,
-		CreatorAppID:       discordgo.Snowflake(*creatorAppID),
-		ManagerAccessToken: *managerAccessToken,
-		ManagerAppId:       discordgo.Snowflake(*managerAppID),
-		Template:           *template,
-	}, db)
+	setupLogging(process.LogFormat)
+	shutdownTracing, err := tracing.Setup(context.Background(), process.TraceExporter)
 	if err != nil {
 		klog.Fatal(err)
 	}
-	defer bot.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			klog.Errorf("%v: failed to flush traces", err)
+		}
+	}()
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	<-stop
+	switch flag.Arg(0) {
+	case "":
+	case "register-commands":
+		if err := soundboard.RegisterCommands(config); err != nil {
+			klog.Fatal(err)
+		}
+		return
+	case "import-sounds":
+		if flag.NArg() != 2 {
+			klog.Fatal("usage: soundboardbot [flags] import-sounds <archive.zip>")
+		}
+		db, err := db.New(process.DBPath)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		// Interrupted imports carry on where they left off when the same archive is imported again.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = soundboard.ImportSounds(ctx, config, db, flag.Arg(1))
+		stop()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		return
+	default:
+		klog.Fatalf("unknown subcommand %q", flag.Arg(0))
+	}
+
+	db, err := db.New(process.DBPath)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	bot, err := soundboard.New(config, db)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			klog.Infof("received %v", sig)
+			if err := bot.Close(); err != nil {
+				klog.Errorf("%v: failed to shut down cleanly", err)
+			}
+			klog.Flush()
+			return
+		}
+		klog.Infof("reloading config from %q", *configPath)
+		config, _, err := loadConfig(*configPath)
+		if err != nil {
+			klog.Errorf("%v: failed to reload config", err)
+			continue
+		}
+		if err := bot.Reload(config); err != nil {
+			klog.Errorf("%v: failed to reload config", err)
+		}
+	}
 }
@@ -5,59 +5,43 @@ import (
 	"fmt"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/kagadar/go-pipeline/slices"
 	"k8s.io/klog/v2"
 )
 
 const (
-	autoroleCommand        = "add-autorole"
-	roleOption             = "role"
-	templateRoleNameOption = "template_role_name"
+	autoroleCommand = "add-autorole"
 )
 
+type addAutoroleOptions struct {
+	Role             discordgo.Snowflake `option:"role,required,type=role" description:"The Role which will become an AutoRole"`
+	TemplateRoleName string              `option:"template_role_name,required,choices=template_roles" description:"The Name of the Role in the Soundboard Template which members in this AutoRole will be assigned to"`
+}
+
 func (b *bot) initAddAutorole() {
 	b.commands[autoroleCommand] = command{
-		&discordgo.ApplicationCommand{
+		command: &discordgo.ApplicationCommand{
 			Description: "Adds an autorole for this server",
-			Options: []*discordgo.ApplicationCommandOption{
-				{
-					Type:        discordgo.ApplicationCommandOptionRole,
-					Name:        roleOption,
-					Description: "The Role which will become an AutoRole",
-					Required:    true,
-				},
-				{
-					Type:        discordgo.ApplicationCommandOptionString,
-					Name:        templateRoleNameOption,
-					Description: "The Name of the Role in the Soundboard Template which members in this AutoRole will be assigned to",
-					Required:    true,
-					Choices: slices.Transform(b.roles.Elements(), func(r string) *discordgo.ApplicationCommandOptionChoice {
-						return &discordgo.ApplicationCommandOptionChoice{Name: r, Value: r}
-					}),
-				},
-			},
-		}, b.addAutorole}
-}
-
-func (b *bot) addAutorole(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	if err := b.validateUser(user, autoroleCommand); err != nil {
-		return err
+		},
+		handler: bind(b.addAutorole),
+		admin:   true,
+		inGuild: true,
 	}
+}
 
-	roleID := options[roleOption].RoleValue(nil, "").ID
-	roleTemplateName := options[templateRoleNameOption].StringValue()
+func (b *bot) addAutorole(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *addAutoroleOptions, followup *discordgo.Message) error {
+	roleID := options.Role
+	roleTemplateName := options.TemplateRoleName
 
-	klog.Infof("add autorole for %q in %q to assign role %q requested by %q", roleID, interaction.GuildID, roleTemplateName, user)
+	logger := klog.FromContext(ctx).WithValues("roleID", roleID, "templateRoleName", roleTemplateName)
+	logger.Info("Add autorole requested")
 
 	if err := b.db.InsertAutoRole(ctx, interaction.GuildID, roleID, roleTemplateName); err != nil {
 		return err
 	}
 
-	if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr(fmt.Sprintf("autorole for %q in %q will assign role %q", roleID, interaction.GuildID, roleTemplateName)),
-	}); err != nil {
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("autorole for %q in %q will assign role %q", roleID, interaction.GuildID, roleTemplateName)); err != nil {
 		return fmt.Errorf("%w: failed to notify %q of completed add autorole request", err, user)
 	}
-	klog.Infof("add autorole for %q in %q to assign role %q completed by %q", roleID, interaction.GuildID, roleTemplateName, user)
+	logger.Info("Add autorole completed")
 	return nil
 }
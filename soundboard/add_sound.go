@@ -0,0 +1,429 @@
+package soundboard
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/audio"
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	addSoundCommand = "add-sound"
+	// maxSoundSize is the largest sound Discord accepts in a soundboard.
+	maxSoundSize = 512 * 1024
+	// maxSoundDuration is the longest sound Discord accepts in a soundboard.
+	maxSoundDuration = 5200 * time.Millisecond
+	// maxTrimSize is the largest MP3 which can be trimmed down to fit in a soundboard.
+	maxTrimSize = 8 * 1024 * 1024
+)
+
+var (
+	ErrInvalidSound = errors.New("invalid sound")
+	errTooLarge     = errors.New("too large")
+	ErrNoFreeSlots  = errors.New("every soundboard is full, an admin can make room with /create-soundboard")
+	// ErrTooManySoundboards is returned when overflow would create more soundboards than Config.MaxSoundboards.
+	ErrTooManySoundboards = errors.New("every soundboard is full, and no more can be created")
+
+	// soundTypes are the content types which soundboards accept.
+	soundTypes = map[string]bool{"audio/mpeg": true, "audio/ogg": true}
+	// soundFormats name the content types which soundboards accept.
+	soundFormats = map[string]string{"audio/mpeg": "MP3", "audio/ogg": "Ogg"}
+	// customEmoji matches a custom emoji as it is sent in a message, such as <:name:id> or <a:name:id>.
+	customEmoji = regexp.MustCompile(`^<a?:\w+:(\d+)>$`)
+)
+
+type addSoundOptions struct {
+	Sound  discordgo.Snowflake `option:"sound,required,type=attachment" description:"The MP3 or Ogg file to upload, at most 512KB and 5.2 seconds long"`
+	Name   string              `option:"name,required,minlen=2,maxlen=32" description:"The name of the sound"`
+	Emoji  *string             `option:"emoji" description:"The emoji shown next to the sound"`
+	Volume *float64            `option:"volume,min=0,max=1" description:"The volume of the sound, from 0 to 1"`
+	Tags   *string             `option:"tags,maxlen=100" description:"Comma separated words to find the sound by with /find-sound"`
+	Start  *float64            `option:"start,min=0" description:"Seconds into an MP3 to start the sound from"`
+	End    *float64            `option:"end,min=0" description:"Seconds into an MP3 to end the sound at"`
+}
+
+func (b *bot) initAddSound() {
+	b.commands[addSoundCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Uploads a sound to the first soundboard with room for it",
+		},
+		handler: bind(b.addSound),
+		// Uploads wait on a new soundboard being handed over when every other is full, see overflow.
+		background: true,
+		timeout:    time.Hour,
+	}
+}
+
+func (b *bot) addSound(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *addSoundOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx).WithValues("name", options.Name)
+	logger.Info("Add sound requested")
+
+	var attachment *discordgo.MessageAttachment
+	if resolved := interaction.ApplicationCommandData().Resolved; resolved != nil {
+		attachment = resolved.Attachments[options.Sound]
+	}
+	if attachment == nil {
+		return fmt.Errorf("%w: attachment %q was not sent with the command", ErrInvalidSound, options.Sound)
+	}
+	sound, err := downloadSound(ctx, attachment, options.Start, options.End)
+	if err != nil {
+		return err
+	}
+	params := &discord.SoundboardSoundParams{
+		Name:   options.Name,
+		Sound:  sound,
+		Volume: options.Volume,
+	}
+	if options.Emoji != nil {
+		params.EmojiID, params.EmojiName = parseEmoji(*options.Emoji)
+	}
+
+	created, err := b.uploadSound(ctx, params)
+	if errors.Is(err, ErrNoFreeSlots) {
+		created, err = b.overflow(ctx, func(ctx context.Context, content string) error {
+			return b.reply(ctx, interaction, user, followup, content)
+		}, params)
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.db.UpsertSound(ctx, db.Sound{
+		SoundID:   created.ID,
+		GuildID:   created.GuildID,
+		Name:      created.Name,
+		EmojiID:   created.EmojiID,
+		EmojiName: created.EmojiName,
+		Volume:    created.Volume,
+		UserID:    user.ID,
+		Added:     time.Now(),
+		Tags:      parseTags(options.Tags),
+	}); err != nil {
+		return err
+	}
+
+	guild, err := b.manager.Guild(created.GuildID, discordgo.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("Added %q to %q (%s)", created.Name, guild.Name, guild.ID)); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed add sound request", err, user)
+	}
+	logger.Info("Add sound completed", "soundID", created.ID, "soundboardID", created.GuildID)
+	return nil
+}
+
+// parseEmoji returns the ID of a custom emoji as it is sent in a message, or otherwise the emoji itself.
+func parseEmoji(emoji string) (discordgo.Snowflake, string) {
+	if m := customEmoji.FindStringSubmatch(emoji); m != nil {
+		return discordgo.Snowflake(m[1]), ""
+	}
+	return "", emoji
+}
+
+// parseTags splits comma separated tags, dropping any duplicates or empty tags.
+func parseTags(tags *string) []string {
+	out := []string{}
+	if tags == nil {
+		return out
+	}
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(*tags, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// downloadSound fetches an attachment, trimming it to play from trimStart to trimEnd if either is set, and encodes it
+// as the data URI which soundboards are uploaded with.
+func downloadSound(ctx context.Context, attachment *discordgo.MessageAttachment, trimStart, trimEnd *float64) (_ string, err error) {
+	ctx, end := step(ctx, "download sound", attribute.String("discord.attachment_id", string(attachment.ID)))
+	defer func() { end(err) }()
+	contentType, _, _ := mime.ParseMediaType(attachment.ContentType)
+	if !soundTypes[contentType] {
+		return "", fmt.Errorf("%w: %q must be an MP3 or Ogg file", ErrInvalidSound, attachment.Filename)
+	}
+	trim := trimStart != nil || trimEnd != nil
+	if trim && contentType != "audio/mpeg" {
+		return "", fmt.Errorf("%w: %q can't be trimmed, since only MP3s can be", ErrInvalidSound, attachment.Filename)
+	}
+	limit := maxSoundSize
+	if trim {
+		limit = maxTrimSize
+	}
+	if attachment.Size > limit {
+		return "", fmt.Errorf("%w: %q is larger than %dKB", ErrInvalidSound, attachment.Filename, limit/1024)
+	}
+	var data []byte
+	if trim {
+		if data, err = trimSound(ctx, attachment, trimStart, trimEnd); err != nil {
+			return "", err
+		}
+	} else if data, contentType, err = fetchSound(ctx, "attachments", attachment.URL, attachment.Filename, contentType); err != nil {
+		return "", err
+	}
+	info, err := checkSound(data, attachment.Filename, contentType)
+	if err != nil {
+		return "", err
+	}
+	klog.FromContext(ctx).V(1).Info("Inspected sound", "format", info.Format, "duration", info.Duration, "bitrate", info.Bitrate, "sampleRate", info.SampleRate, "channels", info.Channels)
+	return soundURI(contentType, data), nil
+}
+
+// trimSound fetches an MP3 attachment, and cuts it down to play from start to end, which default to its start and
+// end. Cuts fall on the nearest frames without re-encoding, see audio.TrimMP3.
+func trimSound(ctx context.Context, attachment *discordgo.MessageAttachment, start, end *float64) ([]byte, error) {
+	data, _, err := download(ctx, "attachments", attachment.URL, attachment.Filename, maxTrimSize)
+	if errors.Is(err, errTooLarge) {
+		return nil, fmt.Errorf("%w: %q is larger than %dKB", ErrInvalidSound, attachment.Filename, maxTrimSize/1024)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := audio.InspectMP3(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is damaged or not really a MP3 file, %v", ErrInvalidSound, attachment.Filename, err)
+	}
+	from, to := time.Duration(0), info.Duration
+	if start != nil {
+		from = time.Duration(*start * float64(time.Second))
+	}
+	if end != nil {
+		to = time.Duration(*end * float64(time.Second))
+	}
+	if from >= to || from >= info.Duration {
+		return nil, fmt.Errorf("%w: %q is %.1f seconds long, so it can't be trimmed to start at %.1f and end at %.1f seconds", ErrInvalidSound, attachment.Filename, info.Duration.Seconds(), from.Seconds(), to.Seconds())
+	}
+	trimmed, err := audio.TrimMP3(data, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q can't be trimmed, %v", ErrInvalidSound, attachment.Filename, err)
+	}
+	if len(trimmed) > maxSoundSize {
+		return nil, fmt.Errorf("%w: %q is still larger than %dKB once trimmed", ErrInvalidSound, attachment.Filename, maxSoundSize/1024)
+	}
+	klog.FromContext(ctx).V(1).Info("Trimmed sound", "from", from, "to", to, "bytes", len(trimmed))
+	return trimmed, nil
+}
+
+// checkSound inspects the sound called name, so that sounds Discord would reject are turned away with a clearer
+// reason than Discord gives.
+func checkSound(data []byte, name, contentType string) (audio.Info, error) {
+	inspect := audio.InspectOgg
+	if contentType == "audio/mpeg" {
+		inspect = audio.InspectMP3
+	}
+	info, err := inspect(data)
+	if errors.Is(err, audio.ErrUnsupported) {
+		return audio.Info{}, fmt.Errorf("%w: %q can't be used, %v", ErrInvalidSound, name, err)
+	}
+	if err != nil {
+		return audio.Info{}, fmt.Errorf("%w: %q is damaged or not really a %s file, %v", ErrInvalidSound, name, soundFormats[contentType], err)
+	}
+	if info.Duration > maxSoundDuration {
+		return audio.Info{}, fmt.Errorf("%w: %q is %.1f seconds long, but soundboards only take sounds up to %.1f seconds", ErrInvalidSound, name, info.Duration.Seconds(), maxSoundDuration.Seconds())
+	}
+	return info, nil
+}
+
+// soundURI encodes a sound as the data URI which soundboards are uploaded with.
+func soundURI(contentType string, data []byte) string {
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// fetchSound downloads the sound called name from url as bot, returning it along with its content type.
+// If contentType is empty, it is taken from the response, or sniffed from the sound if the response doesn't say.
+func fetchSound(ctx context.Context, bot, url, name, contentType string) ([]byte, string, error) {
+	data, header, err := download(ctx, bot, url, name, maxSoundSize)
+	if errors.Is(err, errTooLarge) {
+		return nil, "", fmt.Errorf("%w: %q is larger than %dKB", ErrInvalidSound, name, maxSoundSize/1024)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if contentType == "" {
+		contentType = header
+	}
+	contentType, err = soundType(data, name, contentType)
+	if err != nil {
+		return nil, "", err
+	}
+	klog.FromContext(ctx).V(1).Info("Downloaded sound", "bytes", len(data))
+	return data, contentType, nil
+}
+
+// download fetches the file called name from url as bot, along with the content type of the response.
+// Files larger than limit bytes fail with errTooLarge.
+func download(ctx context.Context, bot, url, name string, limit int) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to download %q", err, name)
+	}
+	client := &http.Client{Transport: instrumentedTransport{bot: bot, next: http.DefaultTransport}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to download %q", err, name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download %q: %s", name, resp.Status)
+	}
+	// Read one byte past the limit, in case the file's size was wrong.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to download %q", err, name)
+	}
+	if len(data) > limit {
+		return nil, "", errTooLarge
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return data, contentType, nil
+}
+
+// soundType returns contentType if soundboards accept it, or otherwise sniffs the sound called name's type from data.
+func soundType(data []byte, name, contentType string) (string, error) {
+	if soundTypes[contentType] {
+		return contentType, nil
+	}
+	switch sniffed := http.DetectContentType(data); sniffed {
+	case "audio/mpeg":
+		return sniffed, nil
+	case "application/ogg":
+		return "audio/ogg", nil
+	}
+	return "", fmt.Errorf("%w: %q is neither an MP3 nor an Ogg file", ErrInvalidSound, name)
+}
+
+// uploadSound uploads the sound to the first soundboard, in the order they were created, with a free slot for it.
+// Soundboards are only tried if the Sounds inventory has room for it at their boost tier.
+func (b *bot) uploadSound(ctx context.Context, params *discord.SoundboardSoundParams) (_ *discord.SoundboardSound, err error) {
+	ctx, end := step(ctx, "upload sound")
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx)
+
+	counts, err := b.db.CountSounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, guildID := range byAge(counts) {
+		guild, err := b.manager.Guild(guildID, discordgo.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		limit := discord.MaxSoundboardSounds(guild.PremiumTier)
+		if counts[guildID] >= limit {
+			logger.V(1).Info("Soundboard is full", "soundboardID", guildID, "sounds", counts[guildID], "limit", limit)
+			continue
+		}
+		created, err := b.createSound(ctx, guildID, params)
+		if errors.Is(err, ErrNoFreeSlots) {
+			// The soundboard has sounds which aren't in the inventory.
+			logger.V(1).Info("Soundboard is full of sounds outside the inventory", "soundboardID", guildID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return created, nil
+	}
+	return nil, ErrNoFreeSlots
+}
+
+// byAge returns the soundboards in counts in the order they were created.
+func byAge(counts map[discordgo.Snowflake]int) []discordgo.Snowflake {
+	var guildIDs []discordgo.Snowflake
+	for guildID := range counts {
+		guildIDs = append(guildIDs, guildID)
+	}
+	// Snowflakes grow over time, so sorting them numerically sorts them by creation.
+	sort.Slice(guildIDs, func(i, j int) bool {
+		x, _ := strconv.ParseUint(string(guildIDs[i]), 10, 64)
+		y, _ := strconv.ParseUint(string(guildIDs[j]), 10, 64)
+		return x < y
+	})
+	return guildIDs
+}
+
+// createSound uploads the sound to guildID, returning ErrNoFreeSlots if it is full.
+func (b *bot) createSound(ctx context.Context, guildID discordgo.Snowflake, params *discord.SoundboardSoundParams) (*discord.SoundboardSound, error) {
+	created, err := b.manager.GuildSoundboardSoundCreate(guildID, params, discordgo.WithContext(ctx))
+	if err != nil {
+		actual := &discordgo.RESTError{}
+		if errors.As(err, &actual) && actual.Message != nil && actual.Message.Code == discord.ErrCodeMaximumSoundboardSounds {
+			return nil, fmt.Errorf("%w: %q", ErrNoFreeSlots, guildID)
+		}
+		return nil, fmt.Errorf("%w: failed to upload %q to %q", err, params.Name, guildID)
+	}
+	if created.GuildID == "" {
+		created.GuildID = guildID
+	}
+	klog.FromContext(ctx).Info("Uploaded sound", "soundboardID", guildID, "soundID", created.ID)
+	return created, nil
+}
+
+// overflow creates a new soundboard for Config.OverflowOwner when every soundboard is full, and uploads the sound
+// to it once it has been handed over. notify is called to tell whoever is waiting on the sound about the wait.
+// Only one soundboard is created at a time, so uploads which overflow while one is being created wait for it.
+func (b *bot) overflow(ctx context.Context, notify func(context.Context, string) error, params *discord.SoundboardSoundParams) (*discord.SoundboardSound, error) {
+	s := b.current()
+	if s.overflowOwner == "" {
+		return nil, ErrNoFreeSlots
+	}
+	select {
+	case b.overflowing <- struct{}{}:
+		defer func() { <-b.overflowing }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// Another upload may have made room while this one was waiting.
+	created, err := b.uploadSound(ctx, params)
+	if !errors.Is(err, ErrNoFreeSlots) {
+		return created, err
+	}
+	soundboards, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.maxSoundboards > 0 && len(soundboards) >= s.maxSoundboards {
+		return nil, fmt.Errorf("%w: there are already %d soundboards", ErrTooManySoundboards, len(soundboards))
+	}
+
+	logger := klog.FromContext(ctx)
+	logger.Info("Every soundboard is full, creating another", "soundboards", len(soundboards), "ownerID", s.overflowOwner)
+	if err := notify(ctx, fmt.Sprintf("Every soundboard is full, so a new one is being created. %q will be added to it once it is ready.", params.Name)); err != nil {
+		return nil, err
+	}
+	guild, err := b.createGuild(ctx, strconv.Itoa(len(soundboards)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create guild: %w", err)
+	}
+	owner := &discordgo.User{ID: s.overflowOwner}
+	if err := b.handOver(ctx, guild, owner, func(ctx context.Context, invite *discordgo.Invite) error {
+		return b.sendDM(ctx, owner, fmt.Sprintf("Every soundboard is full, so I have created a new one for you to own: https://discord.gg/%s", invite.Code))
+	}); err != nil {
+		return nil, err
+	}
+	ctx, end := step(ctx, "upload sound", attribute.String("soundboard.id", string(guild.ID)))
+	created, err = b.createSound(ctx, guild.ID, params)
+	end(err)
+	return created, err
+}
@@ -0,0 +1,96 @@
+package soundboard
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-set"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxSuggestions is the most choices Discord accepts in an autocomplete response.
+	maxSuggestions = 25
+	// maxChoiceName is the longest name Discord accepts for a choice.
+	maxChoiceName = 100
+)
+
+// autocompleteSources are the sources which options can name with `autocomplete`, see suggestions. They are checked
+// by bind, since autocompletion isn't covered by recoverPanics.
+var autocompleteSources = set.New("sounds", "sound_ids", "soundboards", "tags", "backups")
+
+// autocomplete responds to an autocomplete interaction with suggestions for the option being typed.
+// Unlike calls, autocompletion isn't deferred, rate limited or audited, since Discord sends it with every keystroke
+// and only waits 3 seconds for its suggestions.
+func (b *bot) autocomplete(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, c command) error {
+	name := interaction.ApplicationCommandData().Name
+	if b.current().disabled.Has(name) {
+		return fmt.Errorf("%w: %q", ErrCommandDisabled, name)
+	}
+	if c.admin {
+		if err := b.validateUser(user, name); err != nil {
+			return err
+		}
+	}
+	field, value, ok := focusedOption(c.handler.fields, interaction.ApplicationCommandData().Options)
+	if !ok || field.autocomplete == "" {
+		return fmt.Errorf("%w: no option of %q is being autocompleted", ErrInvalidOption, name)
+	}
+	choices, err := b.suggestions(ctx, field.autocomplete, value)
+	if err != nil {
+		return err
+	}
+	if len(choices) > maxSuggestions {
+		choices = choices[:maxSuggestions]
+	}
+	for _, c := range choices {
+		if name := []rune(c.Name); len(name) > maxChoiceName {
+			c.Name = string(name[:maxChoiceName-1]) + "…"
+		}
+	}
+	klog.FromContext(ctx).V(1).Info("Suggesting values", "option", field.option.Name, "value", value, "suggestions", len(choices))
+	return b.respond(ctx, interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+}
+
+// focusedOption finds the field of the option being typed, along with what has been typed so far.
+func focusedOption(fields []optionField, options []*discordgo.ApplicationCommandInteractionDataOption) (optionField, string, bool) {
+	for _, o := range options {
+		for _, f := range fields {
+			if f.option.Name != o.Name {
+				continue
+			}
+			if f.option.Type == discordgo.ApplicationCommandOptionSubCommand {
+				if field, value, ok := focusedOption(f.subcommands, o.Options); ok {
+					return field, value, true
+				}
+				continue
+			}
+			if o.Focused {
+				value, _ := o.Value.(string)
+				return f, value, true
+			}
+		}
+	}
+	return optionField{}, "", false
+}
+
+// suggestions returns the values suggested by the named source for what has been typed so far, best first.
+func (b *bot) suggestions(ctx context.Context, source, value string) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+	switch source {
+	case "sounds":
+		return b.soundSuggestions(ctx, value)
+	case "sound_ids":
+		return b.soundIDSuggestions(ctx, value)
+	case "soundboards":
+		return b.soundboardSuggestions(ctx, value)
+	case "tags":
+		return b.tagSuggestions(ctx, value)
+	case "backups":
+		return b.backupSuggestions(value)
+	}
+	return nil, fmt.Errorf("unknown autocomplete source %q", source)
+}
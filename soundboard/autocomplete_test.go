@@ -0,0 +1,26 @@
+package soundboard
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAutocompleteSources(t *testing.T) {
+	type options struct {
+		Sound string `option:"sound,autocomplete=sonds"`
+	}
+	if _, err := parseOptionFields(reflect.TypeOf(options{})); err == nil || !strings.Contains(err.Error(), `unknown autocomplete source "sonds"`) {
+		t.Errorf("parseOptionFields() with a misspelt autocomplete source = %v, want an error", err)
+	}
+	e := newTestEnv(t, Config{})
+	if _, err := e.b.suggestions(context.Background(), "sonds", ""); err == nil {
+		t.Error("suggestions() from an unknown source succeeded, want an error")
+	}
+	for source := range autocompleteSources {
+		if _, err := e.b.suggestions(context.Background(), source, ""); err != nil && strings.Contains(err.Error(), "unknown autocomplete source") {
+			t.Errorf("suggestions() doesn't handle source %q", source)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package soundboard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	backupSoundsCommand = "backup-sounds"
+
+	// Manifests are named by when they were made, so that they sort oldest first.
+	manifestTimeFormat = "20060102T150405Z"
+)
+
+var (
+	ErrBackupsDisabled = errors.New("backups are disabled, since no backup directory is set")
+)
+
+// A backup is a manifest of every soundboard and the sounds in it, with each sound's content stored separately under
+// its SHA-256 so that it is only stored once however many backups it is in. An index records the latest manifest
+// each soundboard is in, so that backups can be listed without reading every manifest:
+//
+//	<dir>/manifests/<time>.json
+//	<dir>/objects/<first two characters of the hash>/<hash>
+//	<dir>/index.json
+type backupManifest struct {
+	Created     time.Time          `json:"created"`
+	Soundboards []backupSoundboard `json:"soundboards"`
+}
+
+type backupSoundboard struct {
+	ID     discordgo.Snowflake `json:"id"`
+	Name   string              `json:"name"`
+	Sounds []backupSound       `json:"sounds"`
+}
+
+type backupSound struct {
+	ID        discordgo.Snowflake `json:"id"`
+	Name      string              `json:"name"`
+	EmojiID   discordgo.Snowflake `json:"emoji_id,omitempty"`
+	EmojiName string              `json:"emoji_name,omitempty"`
+	Volume    float64             `json:"volume"`
+	// UserID is who added the sound, if it was added with /add-sound.
+	UserID      discordgo.Snowflake `json:"user_id,omitempty"`
+	Added       time.Time           `json:"added"`
+	Tags        []string            `json:"tags,omitempty"`
+	ContentType string              `json:"content_type"`
+	SHA256      string              `json:"sha256"`
+}
+
+type backupIndex struct {
+	Soundboards map[discordgo.Snowflake]backupIndexEntry `json:"soundboards"`
+}
+
+type backupIndexEntry struct {
+	Name     string    `json:"name"`
+	Sounds   int       `json:"sounds"`
+	BackedUp time.Time `json:"backed_up"`
+	// Manifest is the file name of the latest manifest the soundboard is in.
+	Manifest string `json:"manifest"`
+}
+
+func (b *bot) initBackupSounds() {
+	b.commands[backupSoundsCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Backs up every sound to the bot's disk",
+		},
+		handler:    bind(b.backupSounds),
+		admin:      true,
+		background: true,
+		timeout:    time.Hour,
+	}
+}
+
+func (b *bot) backupSounds(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, _ *noOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Backup requested")
+	manifest, failed, err := b.backup(ctx)
+	if err != nil {
+		return err
+	}
+	var sounds int
+	for _, soundboard := range manifest.Soundboards {
+		sounds += len(soundboard.Sounds)
+	}
+	content := fmt.Sprintf("Backed up %d sounds from %d soundboards.", sounds, len(manifest.Soundboards))
+	if failed > 0 {
+		content += fmt.Sprintf(" %d sounds couldn't be downloaded, and were left out.", failed)
+	}
+	if err := b.reply(ctx, interaction, user, followup, content); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed backup request", err, user)
+	}
+	logger.Info("Backup completed", "sounds", sounds, "failed", failed)
+	return nil
+}
+
+// backupPeriodically backs up every sound every Config.BackupInterval until the bot is closed.
+func (b *bot) backupPeriodically() {
+	ticker := time.NewTicker(b.backupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+			if !b.inflight.begin() {
+				return
+			}
+			if _, _, err := b.backup(b.ctx); err != nil {
+				klog.ErrorS(err, "Scheduled backup failed")
+			}
+			b.inflight.end()
+		}
+	}
+}
+
+// backup writes a manifest of every sound in the inventory to the backup directory, downloading any sounds whose
+// content isn't there already. Sounds which fail to download are left out rather than failing the backup, and
+// their number is returned alongside the manifest. Backups older than Config.BackupRetention are then deleted.
+// Only one backup is made at a time.
+func (b *bot) backup(ctx context.Context) (_ backupManifest, failed int, err error) {
+	if b.backupDir == "" {
+		return backupManifest{}, 0, ErrBackupsDisabled
+	}
+	b.backingUp.Lock()
+	defer b.backingUp.Unlock()
+	ctx, end := step(ctx, "backup")
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx)
+	// Sounds backed up before are only downloaded again if their content has gone missing since.
+	previous := map[discordgo.Snowflake]backupSound{}
+	names, err := b.manifestNames()
+	if err != nil {
+		return backupManifest{}, 0, err
+	}
+	if len(names) > 0 {
+		latest, err := b.readManifest(names[len(names)-1])
+		if err != nil {
+			return backupManifest{}, 0, err
+		}
+		for _, soundboard := range latest.Soundboards {
+			for _, sound := range soundboard.Sounds {
+				previous[sound.ID] = sound
+			}
+		}
+	}
+	counts, err := b.db.CountSounds(ctx)
+	if err != nil {
+		return backupManifest{}, 0, err
+	}
+	sounds, err := b.db.ListSounds(ctx)
+	if err != nil {
+		return backupManifest{}, 0, err
+	}
+	manifest := backupManifest{Created: time.Now().UTC()}
+	index := map[discordgo.Snowflake]int{}
+	guildNames := b.guildNames()
+	for _, guildID := range byAge(counts) {
+		// A soundboard backed up without its name could never be restored by name, so the backup waits until the
+		// gateway has every soundboard's name.
+		name, ok := guildNames[guildID]
+		if !ok {
+			return backupManifest{}, 0, fmt.Errorf("%w: %s isn't in the manager's state, so its name is unknown", ErrUnknownSoundboard, guildID)
+		}
+		index[guildID] = len(manifest.Soundboards)
+		manifest.Soundboards = append(manifest.Soundboards, backupSoundboard{ID: guildID, Name: name})
+	}
+	for _, sound := range sounds {
+		i, ok := index[sound.GuildID]
+		if !ok {
+			continue
+		}
+		entry := backupSound{
+			ID:        sound.SoundID,
+			Name:      sound.Name,
+			EmojiID:   sound.EmojiID,
+			EmojiName: sound.EmojiName,
+			Volume:    sound.Volume,
+			UserID:    sound.UserID,
+			Added:     sound.Added,
+			Tags:      sound.Tags,
+		}
+		if p, ok := previous[sound.SoundID]; ok && b.hasObject(p.SHA256) {
+			entry.ContentType, entry.SHA256 = p.ContentType, p.SHA256
+		} else {
+			data, contentType, err := fetchSound(ctx, "cdn", discord.SoundboardSoundURL(sound.SoundID), sound.Name, "")
+			if err == nil {
+				entry.SHA256, err = b.writeObject(data)
+			}
+			if err != nil {
+				logger.Error(err, "Failed to back up sound", "soundID", sound.SoundID)
+				failed++
+				continue
+			}
+			entry.ContentType = contentType
+		}
+		manifest.Soundboards[i].Sounds = append(manifest.Soundboards[i].Sounds, entry)
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return backupManifest{}, 0, fmt.Errorf("%w: failed to encode backup manifest", err)
+	}
+	name := manifest.Created.Format(manifestTimeFormat) + ".json"
+	path := filepath.Join(b.backupDir, "manifests", name)
+	if err := writeFileAtomic(path, content); err != nil {
+		return backupManifest{}, 0, err
+	}
+	logger.Info("Backed up sounds", "manifest", path, "soundboards", len(manifest.Soundboards), "sounds", len(sounds)-failed, "failed", failed)
+	backups, err := b.loadIndex()
+	if err != nil {
+		return backupManifest{}, 0, err
+	}
+	for _, soundboard := range manifest.Soundboards {
+		backups.Soundboards[soundboard.ID] = backupIndexEntry{Name: soundboard.Name, Sounds: len(soundboard.Sounds), BackedUp: manifest.Created, Manifest: name}
+	}
+	// A backup made in the same second as the one before replaces it.
+	if len(names) == 0 || names[len(names)-1] != name {
+		names = append(names, name)
+	}
+	if err := b.pruneBackups(ctx, backups, names); err != nil {
+		return backupManifest{}, 0, err
+	}
+	if err := b.writeIndex(backups); err != nil {
+		return backupManifest{}, 0, err
+	}
+	return manifest, failed, nil
+}
+
+// pruneBackups deletes the manifests in names, oldest first, which are older than Config.BackupRetention, other
+// than the latest, along with their entries in index and any objects which no remaining manifest refers to.
+func (b *bot) pruneBackups(ctx context.Context, index backupIndex, names []string) error {
+	if b.backupRetention <= 0 {
+		return nil
+	}
+	logger := klog.FromContext(ctx)
+	cutoff := time.Now().Add(-b.backupRetention)
+	pruned := map[string]bool{}
+	for _, name := range names[:len(names)-1] {
+		created, err := time.Parse(manifestTimeFormat, strings.TrimSuffix(name, ".json"))
+		if err != nil || !created.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.backupDir, "manifests", name)); err != nil {
+			return fmt.Errorf("%w: failed to delete backup %q", err, name)
+		}
+		logger.Info("Deleted expired backup", "manifest", name)
+		pruned[name] = true
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	for id, entry := range index.Soundboards {
+		if pruned[entry.Manifest] {
+			delete(index.Soundboards, id)
+		}
+	}
+	// Objects are shared between manifests, so only those which none of the remaining manifests refer to can go.
+	referenced := map[string]bool{}
+	for _, name := range names {
+		if pruned[name] {
+			continue
+		}
+		manifest, err := b.readManifest(name)
+		if err != nil {
+			return err
+		}
+		for _, soundboard := range manifest.Soundboards {
+			for _, sound := range soundboard.Sounds {
+				referenced[sound.SHA256] = true
+			}
+		}
+	}
+	objects, err := filepath.Glob(filepath.Join(b.backupDir, "objects", "*", "*"))
+	if err != nil {
+		return fmt.Errorf("%w: failed to list backup objects", err)
+	}
+	for _, object := range objects {
+		if sum := filepath.Base(object); !referenced[sum] && !strings.HasPrefix(sum, ".tmp-") {
+			if err := os.Remove(object); err != nil {
+				return fmt.Errorf("%w: failed to delete backup object %q", err, sum)
+			}
+		}
+	}
+	return nil
+}
+
+// manifestNames lists the file names of the manifests in the backup directory, oldest first.
+func (b *bot) manifestNames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(b.backupDir, "manifests"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list backups", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *bot) readManifest(name string) (backupManifest, error) {
+	content, err := os.ReadFile(filepath.Join(b.backupDir, "manifests", name))
+	if err != nil {
+		return backupManifest{}, fmt.Errorf("%w: failed to read backup %q", err, name)
+	}
+	var manifest backupManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return backupManifest{}, fmt.Errorf("%w: failed to decode backup %q", err, name)
+	}
+	return manifest, nil
+}
+
+// loadIndex reads the backup index. Backup directories from before there was an index have theirs built from every
+// manifest instead, until the next backup writes it.
+func (b *bot) loadIndex() (backupIndex, error) {
+	index := backupIndex{Soundboards: map[discordgo.Snowflake]backupIndexEntry{}}
+	content, err := os.ReadFile(filepath.Join(b.backupDir, "index.json"))
+	if err == nil {
+		if err := json.Unmarshal(content, &index); err != nil {
+			return backupIndex{}, fmt.Errorf("%w: failed to decode backup index", err)
+		}
+		if index.Soundboards == nil {
+			index.Soundboards = map[discordgo.Snowflake]backupIndexEntry{}
+		}
+		return index, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return backupIndex{}, fmt.Errorf("%w: failed to read backup index", err)
+	}
+	names, err := b.manifestNames()
+	if err != nil {
+		return backupIndex{}, err
+	}
+	for _, name := range names {
+		manifest, err := b.readManifest(name)
+		if err != nil {
+			return backupIndex{}, err
+		}
+		for _, soundboard := range manifest.Soundboards {
+			index.Soundboards[soundboard.ID] = backupIndexEntry{Name: soundboard.Name, Sounds: len(soundboard.Sounds), BackedUp: manifest.Created, Manifest: name}
+		}
+	}
+	return index, nil
+}
+
+func (b *bot) writeIndex(index backupIndex) error {
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: failed to encode backup index", err)
+	}
+	return writeFileAtomic(filepath.Join(b.backupDir, "index.json"), content)
+}
+
+func (b *bot) objectPath(sum string) string {
+	return filepath.Join(b.backupDir, "objects", sum[:2], sum)
+}
+
+func (b *bot) hasObject(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	_, err := os.Stat(b.objectPath(sum))
+	return err == nil
+}
+
+// writeObject stores a sound's content under its SHA-256, which it returns.
+func (b *bot) writeObject(data []byte) (string, error) {
+	hash := sha256.Sum256(data)
+	sum := hex.EncodeToString(hash[:])
+	if b.hasObject(sum) {
+		return sum, nil
+	}
+	return sum, writeFileAtomic(b.objectPath(sum), data)
+}
+
+// readObject reads the content stored under sum, checking that it hasn't been corrupted.
+func (b *bot) readObject(sum string) ([]byte, error) {
+	if len(sum) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid backup object %q", sum)
+	}
+	data, err := os.ReadFile(b.objectPath(sum))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read backup object %q", err, sum)
+	}
+	if hash := sha256.Sum256(data); hex.EncodeToString(hash[:]) != sum {
+		return nil, fmt.Errorf("backup object %q is corrupt", sum)
+	}
+	return data, nil
+}
+
+// writeFileAtomic writes a file by renaming a temporary file into place, so that it is never left half written.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("%w: failed to create %q", err, dir)
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("%w: failed to write %q", err, path)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: failed to write %q", err, path)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: failed to write %q", err, path)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("%w: failed to write %q", err, path)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package soundboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeOldBackup writes a backup made long ago of a soundboard which has since been deleted, returning the hash of
+// its only sound.
+func writeOldBackup(t *testing.T, b *bot) string {
+	t.Helper()
+	sum, err := b.writeObject([]byte("gone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	content, err := json.Marshal(backupManifest{Created: created, Soundboards: []backupSoundboard{{
+		ID:     "1",
+		Name:   "gone",
+		Sounds: []backupSound{{ID: "2", Name: "gone", ContentType: "audio/mpeg", SHA256: sum}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(b.backupDir, "manifests", created.Format(manifestTimeFormat)+".json"), content); err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+func TestBackupIndex(t *testing.T) {
+	e := newTestEnv(t, Config{BackupDir: t.TempDir()})
+	writeOldBackup(t, e.b)
+	// Backups made before the index are still listed.
+	choices, err := e.b.backupSuggestions("gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(choices) != 1 || choices[0].Value != "1" {
+		t.Fatalf("backupSuggestions() before the index is written = %+v, want the old backup", choices)
+	}
+	if _, _, err := e.b.backup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(filepath.Join(e.b.backupDir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got backupIndex
+	if err := json.Unmarshal(index, &got); err != nil {
+		t.Fatal(err)
+	}
+	if entry := got.Soundboards["1"]; entry.Name != "gone" || entry.Sounds != 1 || entry.Manifest != "20200101T000000Z.json" {
+		t.Errorf("index entry for the deleted soundboard = %+v", entry)
+	}
+	from, err := e.b.resolveBackup("gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(from.Sounds) != 1 || from.Sounds[0].Name != "gone" {
+		t.Errorf("resolveBackup() = %+v, want the deleted soundboard's sound", from)
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+	e := newTestEnv(t, Config{BackupDir: t.TempDir(), BackupRetention: 24 * time.Hour})
+	sum := writeOldBackup(t, e.b)
+	if _, _, err := e.b.backup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	names, err := e.b.manifestNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] == "20200101T000000Z.json" {
+		t.Errorf("manifests after backup = %v, want only the new one", names)
+	}
+	if e.b.hasObject(sum) {
+		t.Error("the expired backup's sound is still stored, though no other backup refers to it")
+	}
+	if _, err := e.b.resolveBackup("gone"); err == nil {
+		t.Error("resolveBackup() found the expired backup")
+	}
+	// The latest backup is kept however old it is.
+	e.b.backupRetention = time.Nanosecond
+	if _, _, err := e.b.backup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := e.b.manifestNames(); err != nil || len(names) == 0 {
+		t.Errorf("manifests after backup = %v, %v, want the latest", names, err)
+	}
+}
+
+func TestBackupUnknownName(t *testing.T) {
+	e := newTestEnv(t, Config{BackupDir: t.TempDir()})
+	// The manager isn't in this soundboard, so the gateway has no name for it.
+	if err := e.db.UpsertSoundboard(context.Background(), "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.b.backup(context.Background()); !errors.Is(err, ErrUnknownSoundboard) {
+		t.Errorf("backup() = %v, want %v", err, ErrUnknownSoundboard)
+	}
+	if names, err := e.b.manifestNames(); err != nil || len(names) != 0 {
+		t.Errorf("manifests after backup = %v, %v, want none", names, err)
+	}
+}
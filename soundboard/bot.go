@@ -2,16 +2,22 @@ package soundboard
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"k8s.io/klog/v2"
 
-	"github.com/kagadar/go-set"
 	"github.com/kagadar/go-syncmap"
 	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+	"github.com/kagadar/soundboardbot/tokens"
 )
 
 var (
@@ -20,34 +26,111 @@ var (
 
 type command struct {
 	command *discordgo.ApplicationCommand
-	handler func(context.Context, *discordgo.Interaction, *discordgo.User, map[string]*discordgo.ApplicationCommandInteractionDataOption, *discordgo.Message) error
+	handler binding
+	// admin commands may only be called by bot admins, and are registered in Config.AdminGuilds when it is set.
+	admin bool
+	// inGuild admin commands act on the guild they are called in, so are registered globally even when
+	// Config.AdminGuilds is set.
+	inGuild bool
+	// middleware is run after the common middleware, see chain.
+	middleware []middleware
+	// timeout overrides defaultTimeout.
+	timeout time.Duration
+	// background commands run as jobs, see runInBackground.
+	background bool
+	// run is the handler wrapped in all of its middleware.
+	run handler
 }
 
 type Config struct {
-	Admins             []string
-	CreatorAccessToken string
-	CreatorAppID       discordgo.Snowflake
-	ManagerAccessToken string
-	ManagerAppId       discordgo.Snowflake
-	Template           string
+	Admins      []string
+	AdminGuilds []discordgo.Snowflake
+	// BackupDir is where sounds are backed up to, on /backup-sounds and every BackupInterval unless it is 0.
+	// Backups are disabled if it is empty. Backups older than BackupRetention are deleted, unless it is 0, but the
+	// latest is always kept.
+	BackupDir       string
+	BackupInterval  time.Duration
+	BackupRetention time.Duration
+	CreatorToken    tokens.Source
+	CreatorAppID    discordgo.Snowflake
+	// DashboardAddress is where to serve the admin dashboard, which admins sign in to with Discord OAuth2 as the
+	// manager's application. DashboardURL is the dashboard's public URL, which must be one of the application's
+	// OAuth2 redirects with /callback appended, and DashboardClientSecret is the application's client secret.
+	DashboardAddress      string
+	DashboardURL          string
+	DashboardClientSecret tokens.Source
+	// DiscordURL overrides the base URL of Discord's API and gateway, such as to use a fake server.
+	DiscordURL string
+	// ExportDir is where /export-sounds writes archives which aren't attached to its reply.
+	ExportDir             string
+	InteractionsAddress   string
+	InteractionsPublicKey string
+	ManagerToken          tokens.Source
+	ManagerAppId          discordgo.Snowflake
+	// MaxSoundboards limits how many soundboards overflow and imports can create, unless it is 0.
+	MaxSoundboards    int
+	MonitoringAddress string
+	// OverflowOwner is the user who is given a new soundboard when a sound is added while every soundboard is full, or
+	// when ImportSounds has more sounds than the soundboards have room for. Sounds are turned away instead if it is
+	// empty.
+	OverflowOwner    discordgo.Snowflake
+	RateLimits       []RateLimit
+	Template         string
+	DisabledCommands []string
 }
 
 type pendingInvite struct {
 	user discordgo.Snowflake
 	done chan error
+	// ctx is the context of the command waiting on the invite, detached from its cancellation, which the handler
+	// completing it logs and traces under.
+	ctx context.Context
 }
 
 type bot struct {
 	// State
-	admins         set.Set[string]
-	db             db.DB
-	creator        *discordgo.Session
-	manager        *discordgo.Session
+	db           db.DB
+	creator      discord.Session
+	manager      discord.Session
+	creatorToken *sessionToken
+	managerToken *sessionToken
+	closed       chan struct{}
+	closeOnce    sync.Once
+	// ctx is cancelled once shutdown gives up waiting for in-flight commands, see Close.
+	ctx            context.Context
+	cancel         context.CancelFunc
+	inflight       tracker
 	creatorInvites syncmap.Map[discordgo.Snowflake, pendingInvite]
 	managerInvites syncmap.Map[discordgo.Snowflake, pendingInvite]
 	transfers      syncmap.Map[discordgo.Snowflake, pendingInvite]
-	roles          set.Set[string]
-	template       string
+	jobs           syncmap.Map[discordgo.Snowflake, *job]
+	// overflowing is held while a soundboard is created for overflow, so that only one is created at a time.
+	overflowing chan struct{}
+	appID       discordgo.Snowflake
+	// backupDir, backupInterval and backupRetention are from Config, and backingUp is held while a backup is made,
+	// see backup.
+	backupDir       string
+	backupInterval  time.Duration
+	backupRetention time.Duration
+	backingUp       sync.Mutex
+	// exportDir is from Config, see exportSounds.
+	exportDir   string
+	adminGuilds []discordgo.Snowflake
+	limiter     rateLimiter
+	settingsMu  sync.RWMutex
+	settings    settings
+	// Interactions received over HTTP
+	interactionsAddress string
+	publicKey           ed25519.PublicKey
+	interactions        *http.Server
+	// Health checks and metrics
+	monitoringAddress string
+	monitoring        *http.Server
+	// Admin dashboard
+	dashboardAddress  string
+	dashboardURL      string
+	dashboard         *http.Server
+	dashboardSessions syncmap.Map[string, *dashboardSession]
 
 	// Commands and Handlers
 	commands        map[string]command
@@ -57,130 +140,194 @@ type bot struct {
 
 type Bot interface {
 	Close() error
-}
-
-func (b *bot) Close() error {
-	return b.manager.Close()
+	// Reload applies the parts of config which can change while the bot is running.
+	Reload(config Config) error
 }
 
 func (b *bot) validateUser(user *discordgo.User, command string) error {
-	if b.admins.Has(user.Username) {
+	if b.current().admins.Has(user.Username) {
 		return nil
 	}
 	return fmt.Errorf("%w: %q cannot call %q", ErrPermissionDenied, user, command)
 }
 
 func (b *bot) commandler(_ *discordgo.Session, event *discordgo.InteractionCreate) {
-	if event.Type != discordgo.InteractionApplicationCommand {
+	if event.Type != discordgo.InteractionApplicationCommand && event.Type != discordgo.InteractionApplicationCommandAutocomplete {
 		return
 	}
-	command, ok := b.commands[event.ApplicationCommandData().Name]
-	if !ok {
-		klog.Warningf("received command for unexpected interaction type: %q\n%+v", event.ApplicationCommandData().Name, event)
-		return
+	if err := b.dispatch(b.ctx, event.Interaction); err != nil {
+		klog.ErrorS(err, "Interaction failed", "correlationID", event.Interaction.ID)
 	}
-	var user *discordgo.User
-	if event.User != nil {
-		user = event.User
-	} else {
-		if event.Member == nil {
-			klog.Warningf("interaction request recevied without any identified user: %+v", event)
-			return
-		}
-		user = event.Member.User
-	}
-	options := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
-	for _, option := range event.ApplicationCommandData().Options {
-		options[option.Name] = option
-	}
-	if err := b.manager.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Flags: discordgo.MessageFlagsEphemeral,
-		},
-	}); err != nil {
-		klog.Errorf("%v: failed to respond to interaction request", err)
-		return
+}
+
+// newSessions creates the creator and manager sessions, without connecting them to the gateway.
+func newSessions(config Config) (*sessionToken, *sessionToken, error) {
+	if config.DiscordURL != "" {
+		discord.SetBaseURL(config.DiscordURL)
 	}
-	followup, err := b.manager.FollowupMessageCreate(event.Interaction, true, &discordgo.WebhookParams{
-		Content: "Working...",
-	})
+	ctx := context.Background()
+	creator, err := newSessionToken(ctx, "creator", config.CreatorToken)
 	if err != nil {
-		klog.Errorf("%v: failed to send follow-up message", err)
-		return
+		return nil, nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*10)
-	defer cancel()
-	if err := command.handler(ctx, event.Interaction, user, options, followup); err != nil {
-		klog.Error(err)
-		b.manager.FollowupMessageEdit(event.Interaction, followup.ID, &discordgo.WebhookEdit{
-			Content: toPtr(err.Error()),
-		})
-		return
+	manager, err := newSessionToken(ctx, "manager", config.ManagerToken)
+	if err != nil {
+		return nil, nil, err
 	}
+	return creator, manager, nil
 }
 
-func New(config Config, db db.DB) (Bot, error) {
+// newBot prepares the bot's commands for sessions which are not yet connected to the gateway.
+func newBot(config Config, db db.DB, creator, manager discord.Session) (*bot, error) {
 	b := &bot{
-		roles:    set.New[string](),
-		commands: map[string]command{},
-		db:       db,
-		admins:   set.New(config.Admins...),
-		template: config.Template,
-	}
+		creator:      creator,
+		manager:      manager,
+		creatorToken: &sessionToken{name: "creator", session: creator},
+		managerToken: &sessionToken{name: "manager", session: manager},
+		closed:       make(chan struct{}),
+		overflowing:  make(chan struct{}, 1),
+		commands:     map[string]command{},
+		db:           db,
+		adminGuilds:  config.AdminGuilds,
+		appID:        config.ManagerAppId,
 
-	// Connect to Discord
-	var err error
-	b.creator, err = discordgo.New(fmt.Sprintf("Bot %s", config.CreatorAccessToken))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create soundboard session: %w", err)
-	}
-	b.creator.Identify.Intents |= discordgo.IntentGuildMembers
-	if err := b.creator.Open(); err != nil {
-		return nil, fmt.Errorf("failed to connect creator to discord: %w", err)
-	}
-	b.manager, err = discordgo.New(fmt.Sprintf("Bot %s", config.ManagerAccessToken))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create soundboard session: %w", err)
-	}
-	b.manager.Identify.Intents |= discordgo.IntentGuildMembers
-	if err := b.manager.Open(); err != nil {
-		return nil, fmt.Errorf("failed to connect soundboard to discord: %w", err)
-	}
+		backupDir:       config.BackupDir,
+		backupInterval:  config.BackupInterval,
+		backupRetention: config.BackupRetention,
+		exportDir:       config.ExportDir,
 
-	// Load role names from provided template
-	template, err := b.manager.GuildTemplate(b.template)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load template %q: %w", b.template, err)
+		interactionsAddress: config.InteractionsAddress,
+		monitoringAddress:   config.MonitoringAddress,
+		dashboardAddress:    config.DashboardAddress,
+		dashboardURL:        strings.TrimSuffix(config.DashboardURL, "/"),
 	}
-	for _, role := range template.SerializedSourceGuild.Roles {
-		if role.Name != "@everyone" {
-			b.roles.Put(role.Name)
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.limiter.configure(config.RateLimits)
+	if b.interactionsAddress != "" {
+		key, err := hex.DecodeString(config.InteractionsPublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid interactions public key %q", config.InteractionsPublicKey)
 		}
+		b.publicKey = key
+	}
+	if b.dashboardAddress != "" && b.dashboardURL == "" {
+		return nil, errors.New("the dashboard's public URL is required to sign in to it")
 	}
 
 	// Attach handlers and application commands
 	b.initAddAutorole()
+	b.initAddSound()
+	b.initBackupSounds()
 	b.initFixRoles()
 	b.initCreateSoundboard()
 	b.initDeleteServer()
+	b.initExportSounds()
+	b.initFindSound()
+	b.initImportSounds()
 	b.initInitialiseServer()
+	b.initInventory()
 	b.initInvite()
+	b.initJobs()
 	b.initListServers()
+	b.initMoveSound()
+	b.initRebalance()
+	b.initRestoreSoundboard()
+	for name, command := range b.commands {
+		command.command.Name = name
+		command.run = b.chain(name, command)
+		b.commands[name] = command
+	}
+	settings, err := b.loadSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	b.settings = settings
+	return b, nil
+}
 
+// connect attaches the bot's event handlers and connects it to the gateway. Commands are only handled once start
+// has attached commandler.
+func (b *bot) connect() error {
 	for _, handler := range b.creatorHandlers {
 		b.creator.AddHandler(handler)
 	}
-	b.manager.AddHandler(b.commandler)
 	for _, handler := range b.managerHandlers {
 		b.manager.AddHandler(handler)
 	}
-	for name, command := range b.commands {
-		command.command.Name = name
-		if _, err := b.manager.ApplicationCommandCreate(config.ManagerAppId, "", command.command); err != nil {
-			return nil, fmt.Errorf("failed to create application command %q: %w", name, err)
+	if err := b.creator.Open(); err != nil {
+		return fmt.Errorf("failed to connect creator to discord: %w", err)
+	}
+	if err := b.manager.Open(); err != nil {
+		return fmt.Errorf("failed to connect soundboard to discord: %w", err)
+	}
+	return nil
+}
+
+// start attaches the bot's handlers and connects it to Discord.
+func (b *bot) start() error {
+	if b.monitoringAddress != "" {
+		b.serveMonitoring(b.monitoringAddress)
+	}
+	if b.interactionsAddress == "" {
+		b.manager.AddHandler(b.commandler)
+	}
+	if err := b.connect(); err != nil {
+		return err
+	}
+	if err := b.registerCommands(); err != nil {
+		return err
+	}
+	// Sounds are synced in the background, since events will keep the inventory up to date from here on anyway.
+	b.inflight.extend()
+	go func() {
+		defer b.inflight.end()
+		if err := b.syncSounds(b.ctx); err != nil {
+			klog.ErrorS(err, "Failed to sync sound inventory")
 		}
+	}()
+	if b.interactionsAddress != "" {
+		b.serveInteractionsHTTP(b.interactionsAddress)
+	}
+	if b.dashboardAddress != "" {
+		b.serveDashboard(b.dashboardAddress)
+	}
+	go b.watchTokens()
+	if b.backupDir != "" && b.backupInterval > 0 {
+		go b.backupPeriodically()
+	}
+	klog.Infof("Server started as creator:%q manager:%q", b.creator.CurrentUser().ID, b.manager.CurrentUser().ID)
+	return nil
+}
+
+func New(config Config, db db.DB) (Bot, error) {
+	creator, manager, err := newSessions(config)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newBot(config, db, creator.session, manager.session)
+	if err != nil {
+		return nil, err
+	}
+	b.creatorToken, b.managerToken = creator, manager
+	if err := b.start(); err != nil {
+		return nil, err
 	}
-	klog.Infof("Server started as creator:%q manager:%q", b.creator.State.User.ID, b.manager.State.User.ID)
 	return b, nil
 }
+
+// RegisterCommands registers the bot's application commands without connecting to the gateway, and clears them from
+// guilds which are no longer admin guilds.
+func RegisterCommands(config Config) error {
+	creator, manager, err := newSessions(config)
+	if err != nil {
+		return err
+	}
+	b, err := newBot(config, nil, creator.session, manager.session)
+	if err != nil {
+		return err
+	}
+	if err := b.registerCommands(); err != nil {
+		return err
+	}
+	return b.clearStaleCommands()
+}
@@ -0,0 +1,139 @@
+package soundboard
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-pipeline/api"
+	"k8s.io/klog/v2"
+)
+
+// registerCommands brings the registered application commands in line with b.commands, leaving out disabled commands.
+// Admin commands are registered in each of b.adminGuilds when it is set, and globally otherwise, except for those
+// which act on the guild they are called in. Either way, only members with the Administrator permission see them.
+func (b *bot) registerCommands() error {
+	global := []*discordgo.ApplicationCommand{}
+	scoped := []*discordgo.ApplicationCommand{}
+	disabled := b.current().disabled
+	for name, command := range b.commands {
+		if disabled.Has(name) {
+			continue
+		}
+		// Options are built each time, since their choices can change on reload.
+		c := *command.command
+		c.Type = discordgo.ChatApplicationCommand
+		c.Options = b.optionSchema(command.handler.fields)
+		if command.admin {
+			c.DefaultMemberPermissions = toPtr(int64(discordgo.PermissionAdministrator))
+		}
+		if command.admin && !command.inGuild && len(b.adminGuilds) > 0 {
+			scoped = append(scoped, &c)
+		} else {
+			global = append(global, &c)
+		}
+	}
+	if err := b.syncCommands("", global); err != nil {
+		return err
+	}
+	for _, guildID := range b.adminGuilds {
+		if err := b.syncCommands(guildID, scoped); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearStaleCommands removes the commands registered in guilds which the manager is in but which are no longer in
+// b.adminGuilds, since guild commands outlive the config which registered them. This looks in every guild, soundboards
+// included, so it is only done by the register-commands subcommand rather than on every start.
+func (b *bot) clearStaleCommands() error {
+	adminGuilds := map[discordgo.Snowflake]bool{}
+	for _, guildID := range b.adminGuilds {
+		adminGuilds[guildID] = true
+	}
+	guilds, err := api.Paginate(func(last *discordgo.UserGuild) ([]*discordgo.UserGuild, error) {
+		var id discordgo.Snowflake
+		if last != nil {
+			id = last.ID
+		}
+		return b.manager.UserGuilds(200, "", id)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list guilds: %w", err)
+	}
+	for _, guild := range guilds {
+		if adminGuilds[guild.ID] {
+			continue
+		}
+		if err := b.syncCommands(guild.ID, []*discordgo.ApplicationCommand{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncCommands overwrites the commands registered in guildID (or globally, if empty) with desired.
+// Nothing is sent if the registered commands already match.
+func (b *bot) syncCommands(guildID discordgo.Snowflake, desired []*discordgo.ApplicationCommand) error {
+	scope := "global"
+	if guildID != "" {
+		scope = string(guildID)
+	}
+	existing, err := b.manager.ApplicationCommands(b.appID, guildID)
+	if err != nil {
+		return fmt.Errorf("failed to list %s application commands: %w", scope, err)
+	}
+	if commandsEqual(existing, desired) {
+		klog.Infof("%s application commands are up to date", scope)
+		return nil
+	}
+	if _, err := b.manager.ApplicationCommandBulkOverwrite(b.appID, guildID, desired); err != nil {
+		return fmt.Errorf("failed to overwrite %s application commands: %w", scope, err)
+	}
+	klog.Infof("overwrote %d %s application commands", len(desired), scope)
+	return nil
+}
+
+func commandsEqual(existing, desired []*discordgo.ApplicationCommand) bool {
+	if len(existing) != len(desired) {
+		return false
+	}
+	byName := map[string]*discordgo.ApplicationCommand{}
+	for _, c := range existing {
+		byName[c.Name] = c
+	}
+	for _, d := range desired {
+		e, ok := byName[d.Name]
+		if !ok {
+			return false
+		}
+		if e.Type != d.Type || e.Description != d.Description || !ptrEqual(e.DefaultMemberPermissions, d.DefaultMemberPermissions) {
+			return false
+		}
+		if !optionsEqual(e.Options, d.Options) {
+			return false
+		}
+	}
+	return true
+}
+
+func optionsEqual(existing, desired []*discordgo.ApplicationCommandOption) bool {
+	return slices.EqualFunc(existing, desired, func(e, d *discordgo.ApplicationCommandOption) bool {
+		return e.Type == d.Type &&
+			e.Name == d.Name &&
+			e.Description == d.Description &&
+			e.Required == d.Required &&
+			e.Autocomplete == d.Autocomplete &&
+			slices.Equal(e.ChannelTypes, d.ChannelTypes) &&
+			ptrEqual(e.MinValue, d.MinValue) &&
+			e.MaxValue == d.MaxValue &&
+			ptrEqual(e.MinLength, d.MinLength) &&
+			e.MaxLength == d.MaxLength &&
+			slices.EqualFunc(e.Choices, d.Choices, func(e, d *discordgo.ApplicationCommandOptionChoice) bool {
+				// Discord returns numeric choice values as float64, so compare their printed forms.
+				return e.Name == d.Name && fmt.Sprint(e.Value) == fmt.Sprint(d.Value)
+			}) &&
+			optionsEqual(e.Options, d.Options)
+	})
+}
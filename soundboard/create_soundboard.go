@@ -6,9 +6,12 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/kagadar/go-pipeline/channels"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 )
 
@@ -16,136 +19,168 @@ const (
 	authUrl        = "https://discord.com/oauth2/authorize?client_id=%s&permissions=%d&scope=bot&disable_guild_select=true&guild_id=%s"
 	botPermissions = discordgo.PermissionAdministrator
 
-	createSoundboardCommand      = "create-soundboard"
-	createSoundboardSuffixOption = "server_suffix"
+	createSoundboardCommand = "create-soundboard"
 )
 
+type createSoundboardOptions struct {
+	Suffix *int64 `option:"server_suffix,min=0" description:"The suffix to add to the created server"`
+}
+
 func (b *bot) initCreateSoundboard() {
-	b.commands[createSoundboardCommand] = command{&discordgo.ApplicationCommand{
+	b.commands[createSoundboardCommand] = command{command: &discordgo.ApplicationCommand{
 		Description: "Creates a soundboard and assigns ownership to the calling user",
-		Options: []*discordgo.ApplicationCommandOption{
-			{
-				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        createSoundboardSuffixOption,
-				Description: "The suffix to add to the created server",
-			},
-		},
-	}, b.createSoundboard}
+	}, handler: bind(b.createSoundboard), admin: true, background: true, timeout: time.Hour}
 	b.managerHandlers = append(b.managerHandlers, b.transferServer)
 }
 
-func (b *bot) createSoundboard(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	if err := b.validateUser(user, createSoundboardCommand); err != nil {
-		return err
-	}
-
-	klog.Infof("create guild request received from %q", user)
+func (b *bot) createSoundboard(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *createSoundboardOptions, followup *discordgo.Message) error {
+	klog.FromContext(ctx).Info("Create soundboard requested")
 	var suffix string
-	if options[createSoundboardSuffixOption] != nil {
-		suffix = " " + strings.Join(strings.Split(strconv.Itoa(int(options[createSoundboardSuffixOption].IntValue())), ""), " ")
+	if options.Suffix != nil {
+		suffix = strconv.Itoa(int(*options.Suffix))
 	}
-	guild, err := b.creator.GuildCreateWithTemplate(b.template, fmt.Sprintf("soundboardhost%s", suffix), "")
+	guild, err := b.createGuild(ctx, suffix)
 	if err != nil {
 		mainErr := fmt.Errorf("failed to create guild: %w", err)
-		_, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-			Content: toPtr("Failed to create Server"),
-		})
-		if err != nil {
+		if err := b.reply(ctx, interaction, user, followup, "Failed to create Server"); err != nil {
 			mainErr = fmt.Errorf("failed to notify user of error: %w", mainErr)
 		}
 		return mainErr
 	}
-	klog.Infof("created guild: %q with default invite channel %q", guild.ID, guild.SystemChannelID)
+	return b.handOver(ctx, guild, user, func(ctx context.Context, invite *discordgo.Invite) error {
+		return b.reply(ctx, interaction, user, followup, fmt.Sprintf("https://discord.gg/%s", invite.Code))
+	})
+}
+
+// createGuild creates a soundboard from the template as the creator, named with suffix (if any) spelt out digit by
+// digit.
+func (b *bot) createGuild(ctx context.Context, suffix string) (_ *discordgo.Guild, err error) {
+	if suffix != "" {
+		suffix = " " + strings.Join(strings.Split(suffix, ""), " ")
+	}
+	ctx, end := step(ctx, "create guild")
+	defer func() { end(err) }()
+	klog.FromContext(ctx).Info("Creating guild", "template", b.current().template)
+	return b.creator.GuildCreateWithTemplate(b.current().template, fmt.Sprintf("soundboardhost%s", suffix), "", discordgo.WithContext(ctx))
+}
+
+// handOver gives a soundboard which the creator has just created to owner. sendInvite is called with the invite
+// owner must join, after which they are asked to authorize the manager, and are made the owner once it has joined.
+func (b *bot) handOver(ctx context.Context, guild *discordgo.Guild, owner *discordgo.User, sendInvite func(context.Context, *discordgo.Invite) error) error {
+	// Everything from here on is about the new soundboard, rather than the guild the command was called in.
+	logger := klog.FromContext(ctx).WithValues("soundboardID", guild.ID)
+	ctx = klog.NewContext(ctx, logger)
+	logger.Info("Created soundboard", "inviteChannelID", guild.SystemChannelID)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("soundboard.id", string(guild.ID)))
 	if err := b.initialiseDB(ctx, guild); err != nil {
 		return err
 	}
-	klog.Infof("creating invite for %q...", guild.ID)
-	invite, doneInvite, err := createInvite(b.creator, &b.creatorInvites, guild, user.ID)
+	invite, doneInvite, err := createInvite(ctx, b.creator, &b.creatorInvites, guild, owner.ID)
 	if err != nil {
 		return err
 	}
-	doneTransfer := make(chan error)
-	b.transfers.Store(guild.ID, pendingInvite{user: user.ID, done: doneTransfer})
-	if _, err = b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr(fmt.Sprintf("https://discord.gg/%s", invite.Code)),
-	}); err != nil {
-		return fmt.Errorf("failed to update follow-up message: %w", err)
-	}
-	klog.Infof("creator waiting for %q to join %q", user, guild.ID)
-	if err, _, _ := channels.Await(ctx, doneInvite); err != nil {
-		return fmt.Errorf("%w: failed to wait for %q to join %q", err, user, guild.ID)
+	doneTransfer := make(chan error, 1)
+	b.transfers.Store(guild.ID, pendingInvite{user: owner.ID, done: doneTransfer, ctx: context.WithoutCancel(ctx)})
+	if err := sendInvite(ctx, invite); err != nil {
+		return err
 	}
-	klog.Infof("creator requesting authorization for manager in %q", guild.ID)
-	dm, err := b.manager.UserChannelCreate(user.ID)
+	stepCtx, end := step(ctx, "await join")
+	klog.FromContext(stepCtx).Info("Waiting for user to join")
+	err, _, _ = channels.Await(ctx, doneInvite)
+	end(err)
 	if err != nil {
-		return fmt.Errorf("failed to open dm with %q: %w", user.ID, err)
+		return fmt.Errorf("%w: failed to wait for %q to join %q", err, owner, guild.ID)
 	}
-	authMsg, err := b.manager.ChannelMessageSend(dm.ID, fmt.Sprintf(authUrl, b.manager.State.Application.ID, botPermissions, guild.ID))
+	stepCtx, end = step(ctx, "request authorization")
+	klog.FromContext(stepCtx).Info("Requesting authorization for manager")
+	dm, authMsg, err := b.requestAuthorization(stepCtx, owner, guild)
+	end(err)
 	if err != nil {
-		return fmt.Errorf("failed to send dm to %q: %w", user.ID, err)
+		return err
 	}
-	klog.Infof("creator waiting for authorization for manager in %q", guild.ID)
-	if err, _, _ := channels.Await(ctx, doneTransfer); err != nil {
-		return fmt.Errorf("%w: failed to wait for %q to authorize manager in %q", err, user, guild.ID)
+	stepCtx, end = step(ctx, "await authorization")
+	klog.FromContext(stepCtx).Info("Waiting for authorization for manager")
+	err, _, _ = channels.Await(ctx, doneTransfer)
+	end(err)
+	if err != nil {
+		return fmt.Errorf("%w: failed to wait for %q to authorize manager in %q", err, owner, guild.ID)
 	}
-	if err := b.manager.ChannelMessageDelete(dm.ID, authMsg.ID); err != nil {
-		return fmt.Errorf("failed to delete dm to %q: %w", user.ID, err)
+	if err := b.manager.ChannelMessageDelete(dm.ID, authMsg.ID, discordgo.WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to delete dm to %q: %w", owner.ID, err)
 	}
+	logger.Info("Soundboard handed over")
 	return nil
 }
 
+// requestAuthorization asks user to authorize the manager to join guild by DM, returning the DM channel and message.
+func (b *bot) requestAuthorization(ctx context.Context, user *discordgo.User, guild *discordgo.Guild) (*discordgo.Channel, *discordgo.Message, error) {
+	dm, err := b.manager.UserChannelCreate(user.ID, discordgo.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open dm with %q: %w", user.ID, err)
+	}
+	authMsg, err := b.manager.ChannelMessageSend(dm.ID, fmt.Sprintf(authUrl, b.manager.CurrentApplication().ID, botPermissions, guild.ID), discordgo.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send dm to %q: %w", user.ID, err)
+	}
+	return dm, authMsg, nil
+}
+
 func (b *bot) transferServer(_ *discordgo.Session, event *discordgo.GuildCreate) {
 	// Check if event has a related grant
 	grant, ok := b.transfers.Load(event.Guild.ID)
 	if !ok {
 		return
 	}
-	if event.OwnerID != b.creator.State.User.ID {
+	if event.OwnerID != b.creator.CurrentUser().ID {
 		return
 	}
-	// Claim the grant
-	if _, ok := b.transfers.LoadAndDelete(event.Guild.ID); !ok {
-		// Another thread claimed this grant already
+	ok = false
+	for _, guild := range b.creator.StateGuilds() {
+		if guild.ID == event.Guild.ID && guild.OwnerID == b.creator.CurrentUser().ID {
+			ok = true
+			break
+		}
+	}
+	if !ok {
 		return
 	}
-	func() {
-		b.creator.RLock()
-		defer b.creator.RUnlock()
-		ok = false
-		for _, guild := range b.creator.State.Guilds {
-			if guild.ID == event.Guild.ID && guild.OwnerID == b.creator.State.User.ID {
-				ok = true
-				break
-			}
-		}
-		if !ok {
-			return
-		}
-	}()
 	// Claim the grant
 	if _, ok := b.transfers.LoadAndDelete(event.Guild.ID); !ok {
 		// Another thread claimed this grant already
 		return
 	}
 	var err error
-	klog.Infof("manager has joined %q", event.Guild.ID)
 	defer func() { grant.done <- err }()
-	klog.Infof("moving %q to top priority role in %q", b.manager.State.Application.Name, event.Guild.ID)
+	// The transfer is logged and traced as part of the command waiting on it.
+	ctx, end := step(grant.ctx, "transfer")
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx)
+	logger.Info("Manager has joined soundboard")
 	event.Guild.Roles[slices.IndexFunc(event.Guild.Roles, func(r *discordgo.Role) bool {
-		return r.Name == b.manager.State.Application.Name
+		return r.Name == b.manager.CurrentApplication().Name
 	})].Position = slices.MaxFunc(event.Guild.Roles, func(x, y *discordgo.Role) int {
 		return x.Position - y.Position
 	}).Position + 1
-	if _, err = b.creator.GuildRoleReorder(event.Guild.ID, event.Guild.Roles); err != nil {
+	stepCtx, endStep := span(ctx, "reorder roles")
+	logger.Info("Moving manager to top priority role", "role", b.manager.CurrentApplication().Name)
+	_, err = b.creator.GuildRoleReorder(event.Guild.ID, event.Guild.Roles, discordgo.WithContext(stepCtx))
+	endStep(err)
+	if err != nil {
 		err = fmt.Errorf("failed to reorder roles: %w", err)
 		return
 	}
-	klog.Infof("granting ownership of %q to %q", event.Guild.ID, grant.user)
-	if _, err = b.creator.GuildEdit(event.Guild.ID, &discordgo.GuildParams{OwnerID: grant.user}); err != nil {
+	stepCtx, endStep = span(ctx, "transfer ownership")
+	logger.Info("Granting ownership", "ownerID", grant.user)
+	_, err = b.creator.GuildEdit(event.Guild.ID, &discordgo.GuildParams{OwnerID: grant.user}, discordgo.WithContext(stepCtx))
+	endStep(err)
+	if err != nil {
 		err = fmt.Errorf("failed to change guild owner: %w", err)
 		return
 	}
-	if err = b.creator.GuildLeave(event.Guild.ID); err != nil {
+	stepCtx, endStep = span(ctx, "leave guild")
+	err = b.creator.GuildLeave(event.Guild.ID, discordgo.WithContext(stepCtx))
+	endStep(err)
+	if err != nil {
 		err = fmt.Errorf("failed to leave guild: %w", err)
 		return
 	}
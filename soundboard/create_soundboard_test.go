@@ -0,0 +1,30 @@
+package soundboard
+
+import (
+	"testing"
+)
+
+func TestCreateSoundboard(t *testing.T) {
+	e := newTestEnv(t, Config{})
+	g := e.createSoundboard(t, 7)
+	if g.Name != "soundboardhost 7" {
+		t.Errorf("created soundboard is named %q, want %q", g.Name, "soundboardhost 7")
+	}
+	if !hasMember(g, e.manager.CurrentUser().ID) {
+		t.Error("manager isn't a member of the created soundboard")
+	}
+	for _, name := range []string{"Member", "DJ"} {
+		if roleID(g, name) == "" {
+			t.Errorf("created soundboard is missing template role %q", name)
+		}
+	}
+	soundboards, err := e.db.ListSoundboards(e.b.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !soundboards.Has(g.ID) {
+		t.Errorf("created soundboard %q isn't in the DB", g.ID)
+	}
+	// The authorisation link is deleted once it has been followed.
+	waitFor(t, "authorisation DM deletion", func() (bool, bool) { return true, len(e.d.DirectMessages(e.admin.ID)) == 0 })
+}
@@ -0,0 +1,647 @@
+package soundboard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-syncmap"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/tokens"
+)
+
+const (
+	dashboardCookie = "soundboard_dashboard"
+	// dashboardLoginCookie holds the state of a sign in, so that only the browser which started it can finish it.
+	dashboardLoginCookie = "soundboard_dashboard_login"
+	// dashboardSessionLifetime is how long an admin stays signed in to the dashboard.
+	dashboardSessionLifetime = time.Hour * 12
+	// dashboardLoginLifetime is how long an admin has to approve the dashboard on Discord.
+	dashboardLoginLifetime = time.Minute * 10
+	dashboardAuditLogLimit = 50
+
+	// discordEpoch is the first millisecond of 2015, which Discord's IDs count from.
+	discordEpoch = 1420070400000
+)
+
+var (
+	ErrNotSignedIn = errors.New("not signed in")
+
+	//go:embed dashboard.html
+	dashboardHTML     string
+	dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+	snowflakeSequence atomic.Uint64
+)
+
+// dashboardSession is an admin signed in to the dashboard.
+type dashboardSession struct {
+	user    *discordgo.User
+	csrf    string
+	expires time.Time
+
+	mu sync.Mutex
+	// flash holds the replies to the last action, shown once on the next page load.
+	flash []string
+}
+
+func (s *dashboardSession) setFlash(flash []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flash = flash
+}
+
+func (s *dashboardSession) takeFlash() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flash := s.flash
+	s.flash = nil
+	return flash
+}
+
+type dashboardCallKey struct{}
+
+// dashboardCall collects the replies to a command called from the dashboard, in place of its follow-up.
+type dashboardCall struct {
+	mu      sync.Mutex
+	replies []string
+}
+
+func (c *dashboardCall) reply(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replies = append(c.replies, content)
+}
+
+func (c *dashboardCall) result() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.replies...)
+}
+
+func dashboardCallFromContext(ctx context.Context) *dashboardCall {
+	c, _ := ctx.Value(dashboardCallKey{}).(*dashboardCall)
+	return c
+}
+
+// newSnowflake makes up a Discord-like ID for something which Discord doesn't create, such as a dashboard call.
+func newSnowflake() discordgo.Snowflake {
+	id := uint64(time.Now().UnixMilli()-discordEpoch)<<22 | snowflakeSequence.Add(1)&0xfff
+	return discordgo.Snowflake(strconv.FormatUint(id, 10))
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// signedIn returns the admin's dashboard session, if they have one which hasn't expired.
+func (b *bot) signedIn(r *http.Request) (*dashboardSession, error) {
+	cookie, err := r.Cookie(dashboardCookie)
+	if err != nil {
+		return nil, ErrNotSignedIn
+	}
+	s, ok := b.dashboardSessions.Load(cookie.Value)
+	if !ok {
+		return nil, ErrNotSignedIn
+	}
+	if time.Now().After(s.expires) {
+		b.dashboardSessions.Delete(cookie.Value)
+		return nil, ErrNotSignedIn
+	}
+	// Admins can be removed by Reload, which signs them out.
+	if err := b.validateUser(s.user, "the dashboard"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dashboardLogin sends the admin to Discord to approve the dashboard, see
+// https://discord.com/developers/docs/topics/oauth2#authorization-code-grant.
+func (b *bot) dashboardLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, "failed to start sign in", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardLoginCookie,
+		Value:    state,
+		Path:     "/callback",
+		MaxAge:   int(dashboardLoginLifetime.Seconds()),
+		Secure:   strings.HasPrefix(b.dashboardURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, discordgo.EndpointDiscord+"oauth2/authorize?"+url.Values{
+		"client_id":     {string(b.appID)},
+		"redirect_uri":  {b.dashboardURL + "/callback"},
+		"response_type": {"code"},
+		"scope":         {"identify"},
+		"state":         {state},
+	}.Encode(), http.StatusFound)
+}
+
+// dashboardCallback signs the admin in once Discord redirects them back with an authorization code.
+func (b *bot) dashboardCallback(w http.ResponseWriter, r *http.Request) {
+	login, err := r.Cookie(dashboardLoginCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("state")), []byte(login.Value)) != 1 {
+		http.Error(w, "sign in has expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: dashboardLoginCookie, Path: "/callback", MaxAge: -1})
+	user, err := b.exchangeCode(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		klog.FromContext(r.Context()).Error(err, "Failed to sign in to dashboard")
+		http.Error(w, "failed to sign in", http.StatusBadGateway)
+		return
+	}
+	if err := b.validateUser(user, "the dashboard"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	id, err := randomToken()
+	if err != nil {
+		http.Error(w, "failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		http.Error(w, "failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(dashboardSessionLifetime)
+	b.dashboardSessions.Store(id, &dashboardSession{user: user, csrf: csrf, expires: expires})
+	klog.InfoS("Signed in to dashboard", "userID", user.ID)
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(b.dashboardURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// exchangeCode swaps an authorization code for an access token, and looks up the user who granted it.
+func (b *bot) exchangeCode(ctx context.Context, code string) (*discordgo.User, error) {
+	source := b.current().dashboardSecret
+	if source == nil {
+		return nil, fmt.Errorf("%w: the dashboard client secret is not configured", tokens.ErrNoToken)
+	}
+	secret, err := source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dashboard client secret: %w", err)
+	}
+	client := &http.Client{Transport: instrumentedTransport{bot: "dashboard", next: http.DefaultTransport}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discordgo.EndpointOAuth2+"token", strings.NewReader(url.Values{
+		"client_id":     {string(b.appID)},
+		"client_secret": {secret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {b.dashboardURL + "/callback"},
+	}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := doJSON(client, req, &token); err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, discordgo.EndpointUsers+"@me", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token.TokenType+" "+token.AccessToken)
+	var user discordgo.User
+	if err := doJSON(client, req, &user); err != nil {
+		return nil, fmt.Errorf("failed to look up signed in user: %w", err)
+	}
+	return &user, nil
+}
+
+func doJSON(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// checkCSRF rejects form posts which didn't come from a page served to the signed in admin.
+func checkCSRF(s *dashboardSession, r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(s.csrf)) == 1
+}
+
+func (b *bot) dashboardLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s, err := b.signedIn(r)
+	if err != nil || !checkCSRF(s, r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	cookie, _ := r.Cookie(dashboardCookie)
+	b.dashboardSessions.Delete(cookie.Value)
+	http.SetCookie(w, &http.Cookie{Name: dashboardCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// dashboardAction calls a command as the signed in admin, with options taken from the posted form.
+// The call goes through the same checks, rate limits, audit log and jobs as one from Discord.
+func (b *bot) dashboardAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s, err := b.signedIn(r)
+	if errors.Is(err, ErrNotSignedIn) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkCSRF(s, r) {
+		http.Error(w, "invalid form, please reload the dashboard", http.StatusForbidden)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/actions/")
+	command, ok := b.commands[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("%v: %q", ErrUnknownCommand, name), http.StatusNotFound)
+		return
+	}
+	options, err := formOptions(b.optionSchema(command.handler.fields), r.PostForm, "")
+	if err != nil {
+		s.setFlash([]string{err.Error()})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	interaction := &discordgo.Interaction{
+		ID:    newSnowflake(),
+		AppID: b.appID,
+		Type:  discordgo.InteractionApplicationCommand,
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name:    name,
+			Options: options,
+		},
+	}
+	if guildID := discordgo.Snowflake(strings.TrimSpace(r.PostFormValue("guild_id"))); guildID != "" {
+		interaction.GuildID = guildID
+		interaction.Member = &discordgo.Member{GuildID: guildID, User: s.user}
+	} else {
+		interaction.User = s.user
+	}
+	call := &dashboardCall{}
+	// Like interactions, the command outlives the request which started it.
+	if err := b.dispatch(context.WithValue(b.ctx, dashboardCallKey{}, call), interaction); err != nil {
+		klog.ErrorS(err, "Dashboard action failed", "correlationID", interaction.ID)
+	}
+	s.setFlash(call.result())
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// formOptions converts a posted form into the options of a command with schema, as Discord would send them.
+// Subcommands are chosen by the form's subcommand field, and their options are prefixed with the subcommand's name.
+func formOptions(schema []*discordgo.ApplicationCommandOption, form url.Values, prefix string) ([]*discordgo.ApplicationCommandInteractionDataOption, error) {
+	var out []*discordgo.ApplicationCommandInteractionDataOption
+	for _, o := range schema {
+		option := &discordgo.ApplicationCommandInteractionDataOption{Name: o.Name, Type: o.Type}
+		if o.Type == discordgo.ApplicationCommandOptionSubCommand {
+			if form.Get(prefix+"subcommand") != o.Name {
+				continue
+			}
+			sub, err := formOptions(o.Options, form, prefix+o.Name+".")
+			if err != nil {
+				return nil, err
+			}
+			option.Options = sub
+			out = append(out, option)
+			continue
+		}
+		value := strings.TrimSpace(form.Get(prefix + o.Name))
+		if value == "" {
+			continue
+		}
+		switch o.Type {
+		case discordgo.ApplicationCommandOptionInteger, discordgo.ApplicationCommandOptionNumber:
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q must be a number, got %q", ErrInvalidOption, o.Name, value)
+			}
+			option.Value = v
+		case discordgo.ApplicationCommandOptionBoolean:
+			option.Value = value == "true" || value == "on"
+		default:
+			option.Value = value
+		}
+		out = append(out, option)
+	}
+	return out, nil
+}
+
+type dashboardPage struct {
+	User        *discordgo.User
+	CSRF        string
+	Flash       []string
+	Errors      []string
+	Soundboards []soundboardView
+	AutoRoles   []autoRoleView
+	Jobs        []jobView
+	Pending     []pendingView
+	AuditLog    []auditView
+	Commands    []commandView
+}
+
+type soundboardView struct {
+	ID      discordgo.Snowflake
+	Name    string
+	Owner   string
+	Roles   []roleView
+	Members []memberView
+}
+
+type roleView struct {
+	TemplateName string
+	ID           discordgo.Snowflake
+	Name         string
+}
+
+type memberView struct {
+	Name  string
+	Roles []string
+}
+
+type autoRoleView struct {
+	Guild        string
+	Role         string
+	TemplateName string
+}
+
+type jobView struct {
+	ID      discordgo.Snowflake
+	Command string
+	User    string
+	Age     time.Duration
+	Status  string
+}
+
+type pendingView struct {
+	Kind       string
+	Soundboard discordgo.Snowflake
+	User       string
+}
+
+type auditView struct {
+	Time    string
+	User    string
+	Guild   string
+	Command string
+	Outcome string
+}
+
+type commandView struct {
+	Name        string
+	Description string
+	Options     []optionView
+	Subcommands []subcommandView
+}
+
+type subcommandView struct {
+	Name        string
+	Description string
+	Options     []optionView
+}
+
+type optionView struct {
+	// Field is the name of the form field holding the option.
+	Field       string
+	Name        string
+	Description string
+	Required    bool
+	// Input is the type of the HTML input, or "select" if the option has choices.
+	Input   string
+	Choices []string
+}
+
+// dashboardIndex shows everything the bot is managing, along with a form for each command.
+func (b *bot) dashboardIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s, err := b.signedIn(r)
+	if errors.Is(err, ErrNotSignedIn) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	page := b.dashboardPage(r.Context())
+	page.User, page.CSRF, page.Flash = s.user, s.csrf, s.takeFlash()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		klog.FromContext(r.Context()).Error(err, "Failed to render dashboard")
+	}
+}
+
+// dashboardPage gathers what the dashboard shows. Anything which can't be read is reported on the page, rather than
+// hiding everything else.
+func (b *bot) dashboardPage(ctx context.Context) dashboardPage {
+	var page dashboardPage
+	guilds := map[discordgo.Snowflake]*discordgo.Guild{}
+	for _, g := range b.manager.StateGuilds() {
+		guilds[g.ID] = g
+	}
+	guildName := func(id discordgo.Snowflake) string {
+		if g, ok := guilds[id]; ok {
+			return fmt.Sprintf("%s (%s)", g.Name, id)
+		}
+		return string(id)
+	}
+	roleName := func(guildID, roleID discordgo.Snowflake) string {
+		if r, err := b.manager.StateRole(guildID, roleID); err == nil {
+			return r.Name
+		}
+		return string(roleID)
+	}
+
+	soundboards, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		page.Errors = append(page.Errors, err.Error())
+	}
+	roles, err := b.db.ListSoundboardRoles(ctx)
+	if err != nil {
+		page.Errors = append(page.Errors, err.Error())
+	}
+	for id := range soundboards {
+		view := soundboardView{ID: id}
+		if g, ok := guilds[id]; ok {
+			view.Name, view.Owner = g.Name, string(g.OwnerID)
+			for _, m := range g.Members {
+				member := memberView{Name: m.User.String()}
+				for _, role := range m.Roles {
+					member.Roles = append(member.Roles, roleName(id, role))
+				}
+				view.Members = append(view.Members, member)
+			}
+		}
+		for templateName, roleID := range roles[id] {
+			view.Roles = append(view.Roles, roleView{TemplateName: templateName, ID: roleID, Name: roleName(id, roleID)})
+		}
+		sort.Slice(view.Roles, func(i, j int) bool { return view.Roles[i].TemplateName < view.Roles[j].TemplateName })
+		page.Soundboards = append(page.Soundboards, view)
+	}
+	sort.Slice(page.Soundboards, func(i, j int) bool { return page.Soundboards[i].ID < page.Soundboards[j].ID })
+
+	autoRoles, err := b.db.ListAutoRoles(ctx)
+	if err != nil {
+		page.Errors = append(page.Errors, err.Error())
+	}
+	for _, a := range autoRoles {
+		page.AutoRoles = append(page.AutoRoles, autoRoleView{
+			Guild:        guildName(a.GuildID),
+			Role:         roleName(a.GuildID, a.RoleID),
+			TemplateName: a.TemplateRoleName,
+		})
+	}
+
+	b.jobs.Range(func(_ discordgo.Snowflake, j *job) bool {
+		j.mu.Lock()
+		status := j.status
+		j.mu.Unlock()
+		page.Jobs = append(page.Jobs, jobView{ID: j.id, Command: j.command, User: j.user.String(), Age: time.Since(j.started).Round(time.Second), Status: status})
+		return true
+	})
+	sort.Slice(page.Jobs, func(i, j int) bool { return page.Jobs[i].Age > page.Jobs[j].Age })
+	for _, pending := range []struct {
+		kind string
+		m    *syncmap.Map[discordgo.Snowflake, pendingInvite]
+	}{
+		{"creator invite", &b.creatorInvites},
+		{"manager invite", &b.managerInvites},
+		{"transfer", &b.transfers},
+	} {
+		pending.m.Range(func(guildID discordgo.Snowflake, p pendingInvite) bool {
+			page.Pending = append(page.Pending, pendingView{Kind: pending.kind, Soundboard: guildID, User: string(p.user)})
+			return true
+		})
+	}
+	sort.Slice(page.Pending, func(i, j int) bool { return page.Pending[i].Soundboard < page.Pending[j].Soundboard })
+
+	entries, err := b.db.ListAuditLog(ctx, dashboardAuditLogLimit)
+	if err != nil {
+		page.Errors = append(page.Errors, err.Error())
+	}
+	for _, e := range entries {
+		page.AuditLog = append(page.AuditLog, auditView{
+			Time:    e.Time.UTC().Format(time.DateTime),
+			User:    string(e.UserID),
+			Guild:   guildName(e.GuildID),
+			Command: e.Command,
+			Outcome: e.Outcome,
+		})
+	}
+
+	disabled := b.current().disabled
+	for name, c := range b.commands {
+		// The dashboard can't upload attachments, so commands which take them are left to Discord.
+		if disabled.Has(name) || takesAttachment(c.handler.fields) {
+			continue
+		}
+		view := commandView{Name: name, Description: c.command.Description}
+		for _, o := range b.optionSchema(c.handler.fields) {
+			if o.Type != discordgo.ApplicationCommandOptionSubCommand {
+				view.Options = append(view.Options, newOptionView("", o))
+				continue
+			}
+			sub := subcommandView{Name: o.Name, Description: o.Description}
+			for _, so := range o.Options {
+				sub.Options = append(sub.Options, newOptionView(o.Name+".", so))
+			}
+			view.Subcommands = append(view.Subcommands, sub)
+		}
+		page.Commands = append(page.Commands, view)
+	}
+	sort.Slice(page.Commands, func(i, j int) bool { return page.Commands[i].Name < page.Commands[j].Name })
+	return page
+}
+
+// takesAttachment reports whether any of the options, including those of subcommands, is an attachment.
+func takesAttachment(fields []optionField) bool {
+	for _, f := range fields {
+		if f.option.Type == discordgo.ApplicationCommandOptionAttachment || takesAttachment(f.subcommands) {
+			return true
+		}
+	}
+	return false
+}
+
+func newOptionView(prefix string, o *discordgo.ApplicationCommandOption) optionView {
+	view := optionView{Field: prefix + o.Name, Name: o.Name, Description: o.Description, Required: o.Required, Input: "text"}
+	switch o.Type {
+	case discordgo.ApplicationCommandOptionInteger, discordgo.ApplicationCommandOptionNumber:
+		view.Input = "number"
+	case discordgo.ApplicationCommandOptionBoolean:
+		view.Input = "checkbox"
+	}
+	if len(o.Choices) > 0 {
+		view.Input = "select"
+		for _, c := range o.Choices {
+			view.Choices = append(view.Choices, c.Name)
+		}
+	}
+	return view
+}
+
+// serveDashboard serves the admin dashboard on address until the bot is closed.
+func (b *bot) serveDashboard(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.dashboardIndex)
+	mux.HandleFunc("/login", b.dashboardLogin)
+	mux.HandleFunc("/callback", b.dashboardCallback)
+	mux.HandleFunc("/logout", b.dashboardLogout)
+	mux.HandleFunc("/actions/", b.dashboardAction)
+	b.dashboard = &http.Server{Addr: address, Handler: mux}
+	go func() {
+		klog.Infof("serving dashboard on %s", address)
+		if err := b.dashboard.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("%v: dashboard stopped", err)
+		}
+	}()
+}
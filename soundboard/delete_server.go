@@ -10,61 +10,48 @@ import (
 )
 
 const (
-	deleteServerCommand  = "delete-server"
-	deleteServerIDOption = "server_id"
+	deleteServerCommand = "delete-server"
 )
 
 var (
 	ErrServerNotOwned = errors.New("creator bot does not own server")
 )
 
-func (b *bot) initDeleteServer() {
-	b.commands[deleteServerCommand] = command{&discordgo.ApplicationCommand{Description: "Deletes a borked server", Options: []*discordgo.ApplicationCommandOption{
-		{
-			Type:        discordgo.ApplicationCommandOptionString,
-			Name:        deleteServerIDOption,
-			Description: "The server to be deleted",
-			Required:    true,
-		},
-	}}, b.deleteServer}
+type deleteServerOptions struct {
+	ServerID discordgo.Snowflake `option:"server_id,required" description:"The server to be deleted"`
 }
 
-func (b *bot) deleteServer(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	if err := b.validateUser(user, deleteServerCommand); err != nil {
-		return err
-	}
+func (b *bot) initDeleteServer() {
+	b.commands[deleteServerCommand] = command{command: &discordgo.ApplicationCommand{Description: "Deletes a borked server"}, handler: bind(b.deleteServer), admin: true}
+}
 
-	guildID := discordgo.Snowflake(options[deleteServerIDOption].StringValue())
-	klog.Infof("delete guild %q request received from %q", guildID, user)
+func (b *bot) deleteServer(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *deleteServerOptions, followup *discordgo.Message) error {
+	guildID := options.ServerID
+	logger := klog.FromContext(ctx).WithValues("soundboardID", guildID)
+	logger.Info("Delete guild requested")
 	var owned bool
-	func() {
-		b.creator.State.RLock()
-		defer b.creator.State.RUnlock()
-		for _, guild := range b.creator.State.Guilds {
-			if guild.ID != guildID {
-				continue
-			}
-			if guild.OwnerID != b.creator.State.User.ID {
-				break
-			}
-			owned = true
+	for _, guild := range b.creator.StateGuilds() {
+		if guild.ID != guildID {
+			continue
+		}
+		if guild.OwnerID != b.creator.CurrentUser().ID {
+			break
 		}
-	}()
+		owned = true
+	}
 	if !owned {
 		return ErrServerNotOwned
 	}
-	if err := b.creator.GuildDelete(guildID); err != nil {
+	if err := b.creator.GuildDelete(guildID, discordgo.WithContext(ctx)); err != nil {
 		if !errors.Is(err, discordgo.ErrJSONUnmarshal) {
 			// DiscordGo incorrectly tries to unmarshal the response from the Guild Delete request.
 			// This is doomed to fail, since the request returns `204 No Content`: https://discord.com/developers/docs/resources/guild#delete-guild
 			return fmt.Errorf("failed to delete guild %q: %w", guildID, err)
 		}
 	}
-	if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr(fmt.Sprintf("%q has been deleted.", guildID)),
-	}); err != nil {
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("%q has been deleted.", guildID)); err != nil {
 		return fmt.Errorf("failed to notify %q of completed delete request: %w", user, err)
 	}
-	klog.Infof("guild %q has been deleted by %q", guildID, user)
+	logger.Info("Guild deleted")
 	return nil
 }
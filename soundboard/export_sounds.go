@@ -0,0 +1,271 @@
+package soundboard
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	exportSoundsCommand = "export-sounds"
+	// maxAttachmentSize is the largest file Discord accepts from bots in guilds without boosts.
+	maxAttachmentSize = 10 * 1024 * 1024
+)
+
+var (
+	ErrExportsDisabled = errors.New("exports can only be attached, since no export directory is set")
+	ErrExportFailed    = errors.New("export failed")
+
+	// soundExtensions are the file extensions sounds are exported with, by content type.
+	soundExtensions = map[string]string{"audio/mpeg": ".mp3", "audio/ogg": ".ogg"}
+)
+
+type exportSoundsOptions struct {
+	Soundboard *string `option:"soundboard,autocomplete=soundboards" description:"Only export the sounds in this soundboard"`
+	Tag        *string `option:"tag,autocomplete=tags" description:"Only export the sounds with this tag"`
+	Local      *bool   `option:"local" description:"Write the archive to the bot's export directory instead of attaching it"`
+}
+
+func (b *bot) initExportSounds() {
+	b.commands[exportSoundsCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Exports sounds as a zip which /import-sounds accepts",
+		},
+		handler:    bind(b.exportSounds),
+		admin:      true,
+		background: true,
+		timeout:    time.Hour,
+	}
+}
+
+func (b *bot) exportSounds(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *exportSoundsOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Export sounds requested")
+	sounds, err := b.db.ListSounds(ctx)
+	if err != nil {
+		return err
+	}
+	if options.Soundboard != nil {
+		guildID, err := b.resolveSoundboard(ctx, *options.Soundboard)
+		if err != nil {
+			return err
+		}
+		sounds = filterSounds(sounds, func(sound db.Sound) bool { return sound.GuildID == guildID })
+	}
+	if options.Tag != nil {
+		tag := strings.ToLower(strings.TrimSpace(*options.Tag))
+		sounds = filterSounds(sounds, func(sound db.Sound) bool {
+			for _, t := range sound.Tags {
+				if t == tag {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if len(sounds) == 0 {
+		return b.reply(ctx, interaction, user, followup, "No sounds match, so there is nothing to export.")
+	}
+	// The dashboard can't show attachments.
+	local := (options.Local != nil && *options.Local) || dashboardCallFromContext(ctx) != nil
+	if local && b.exportDir == "" {
+		return ErrExportsDisabled
+	}
+	// Archives are written to disk as they are built, since they may be too large to attach anyway.
+	dir := b.exportDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("%w: failed to create %q", err, dir)
+	}
+	var parts []*os.File
+	defer func() {
+		for _, f := range parts {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	exported, failed, err := b.writeExport(ctx, func() (io.Writer, error) {
+		f, err := os.CreateTemp(dir, ".tmp-*.zip")
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to create export", err)
+		}
+		parts = append(parts, f)
+		return f, nil
+	}, sounds)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range parts {
+		info, err := f.Stat()
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to write export", err)
+		}
+		size = max(size, info.Size())
+	}
+	if !local && (len(parts) > 1 || size > maxAttachmentSize) {
+		if b.exportDir == "" {
+			return fmt.Errorf("%w: the export is too large to attach", ErrExportsDisabled)
+		}
+		local = true
+	}
+	name := "sounds-" + time.Now().UTC().Format(manifestTimeFormat)
+	notes := ""
+	if failed > 0 {
+		notes = fmt.Sprintf(" %d sounds couldn't be downloaded, and were left out.", failed)
+	}
+	if !local {
+		data, err := os.ReadFile(parts[0].Name())
+		if err != nil {
+			return fmt.Errorf("%w: failed to read export", err)
+		}
+		file := &discordgo.File{Name: name + ".zip", ContentType: "application/zip", Reader: bytes.NewReader(data)}
+		err = b.replyWithFiles(ctx, interaction, user, followup, fmt.Sprintf("Exported %d sounds.", exported)+notes, file)
+		if err == nil {
+			logger.Info("Export sounds completed", "exported", exported, "failed", failed, "local", local)
+			return nil
+		}
+		// Boosts raise how large an attachment can be, so the limit above is only a guess which Discord may not share.
+		actual := &discordgo.RESTError{}
+		if !errors.As(err, &actual) || actual.Response == nil || actual.Response.StatusCode != http.StatusRequestEntityTooLarge || b.exportDir == "" {
+			return fmt.Errorf("%w: failed to notify %q of completed export sounds request", err, user)
+		}
+		logger.Info("Export is too large to attach, writing it to the export directory instead", "size", size)
+		local = true
+	}
+	var paths []string
+	for i, f := range parts {
+		path := filepath.Join(b.exportDir, name+".zip")
+		if len(parts) > 1 {
+			path = filepath.Join(b.exportDir, fmt.Sprintf("%s-%d.zip", name, i+1))
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			return fmt.Errorf("%w: failed to write %q", err, path)
+		}
+		paths = append(paths, path)
+	}
+	content := fmt.Sprintf("Exported %d sounds to %s on the bot's disk.", exported, strings.Join(paths, ", "))
+	if len(paths) > 1 {
+		content = fmt.Sprintf("Exported %d sounds to %s on the bot's disk, split so that each has at most the %d sounds /%s takes at once.", exported, strings.Join(paths, ", "), maxImportSounds, importSoundsCommand)
+	}
+	if err := b.reply(ctx, interaction, user, followup, content+notes); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed export sounds request", err, user)
+	}
+	logger.Info("Export sounds completed", "exported", exported, "failed", failed, "local", local, "archives", len(paths))
+	return nil
+}
+
+func filterSounds(sounds []db.Sound, keep func(db.Sound) bool) []db.Sound {
+	var out []db.Sound
+	for _, sound := range sounds {
+		if keep(sound) {
+			out = append(out, sound)
+		}
+	}
+	return out
+}
+
+// writeExport writes sounds as zips in the form /import-sounds accepts, see importManifest, to writers from next. A
+// zip holds at most maxImportSounds sounds, so that each can be imported, and next is called again for each zip after
+// the first. Sounds which fail to download are left out rather than failing the export, and their number is returned
+// alongside how many were exported. It fails with ErrExportFailed if none could be downloaded.
+func (b *bot) writeExport(ctx context.Context, next func() (io.Writer, error), sounds []db.Sound) (exported, failed int, err error) {
+	ctx, end := step(ctx, "export sounds")
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx)
+	var zw *zip.Writer
+	var manifest importManifest
+	// finish writes the manifest of the zip being written.
+	finish := func() error {
+		content, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%w: failed to encode export manifest", err)
+		}
+		fw, err := zw.Create(importManifestName)
+		if err != nil {
+			return fmt.Errorf("%w: failed to write export", err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			return fmt.Errorf("%w: failed to write export", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("%w: failed to write export", err)
+		}
+		return nil
+	}
+	guildNames := b.guildNames()
+	for i, sound := range sounds {
+		jobFromContext(ctx).setStatus(fmt.Sprintf("Exporting %q (%d of %d)", sound.Name, i+1, len(sounds)))
+		data, contentType, err := fetchSound(ctx, "cdn", discord.SoundboardSoundURL(sound.SoundID), sound.Name, "")
+		if err != nil {
+			logger.Error(err, "Failed to export sound", "soundID", sound.SoundID)
+			failed++
+			continue
+		}
+		if zw != nil && len(manifest.Sounds) == maxImportSounds {
+			if err := finish(); err != nil {
+				return 0, 0, err
+			}
+			zw = nil
+		}
+		if zw == nil {
+			w, err := next()
+			if err != nil {
+				return 0, 0, err
+			}
+			zw, manifest = zip.NewWriter(w), importManifest{}
+		}
+		file := "sounds/" + string(sound.SoundID) + soundExtensions[contentType]
+		fw, err := zw.Create(file)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: failed to write export", err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			return 0, 0, fmt.Errorf("%w: failed to write export", err)
+		}
+		entry := importEntry{
+			File:         file,
+			Name:         sound.Name,
+			Emoji:        sound.EmojiName,
+			Volume:       toPtr(sound.Volume),
+			Tags:         sound.Tags,
+			UserID:       sound.UserID,
+			SoundboardID: sound.GuildID,
+			Soundboard:   guildNames[sound.GuildID],
+		}
+		if sound.EmojiID != "" {
+			// Custom emoji are written as they are sent in messages, which is how they are given to /add-sound.
+			entry.Emoji = fmt.Sprintf("<:emoji:%s>", sound.EmojiID)
+		}
+		manifest.Sounds = append(manifest.Sounds, entry)
+		exported++
+	}
+	if zw == nil {
+		return 0, failed, fmt.Errorf("%w: none of the %d sounds could be downloaded", ErrExportFailed, len(sounds))
+	}
+	if err := finish(); err != nil {
+		return 0, 0, err
+	}
+	logger.Info("Exported sounds", "exported", exported, "failed", failed)
+	return exported, failed, nil
+}
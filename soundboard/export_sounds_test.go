@@ -0,0 +1,110 @@
+package soundboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+// addSound uploads a sound called name to the soundboard as the manager.
+func (e *serverEnv) addSound(t *testing.T, guildID discordgo.Snowflake, name string) *discord.SoundboardSound {
+	t.Helper()
+	sound, err := e.manager.GuildSoundboardSoundCreate(guildID, &discord.SoundboardSoundParams{
+		Name:  name,
+		Sound: "data:audio/mpeg;base64," + base64.StdEncoding.EncodeToString(silentMP3(name)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sound
+}
+
+// TestExportSoundsSplit exports more sounds than can be imported at once, which are split between archives.
+func TestExportSoundsSplit(t *testing.T) {
+	e := newServerEnv(t)
+	b := e.start(t, e.config())
+	var sounds []db.Sound
+	for len(sounds) <= maxImportSounds {
+		board := e.addSoundboard(t, fmt.Sprintf("soundboardhost %d", len(sounds)))
+		if err := e.d.SetPremiumTier(board.ID, discordgo.PremiumTier3); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < discord.MaxSoundboardSounds(discordgo.PremiumTier3) && len(sounds) <= maxImportSounds; i++ {
+			sound := e.addSound(t, board.ID, fmt.Sprintf("sound %d", len(sounds)))
+			sounds = append(sounds, db.Sound{SoundID: sound.ID, GuildID: board.ID, Name: sound.Name})
+		}
+	}
+	// A sound which can't be downloaded is left out.
+	sounds = append(sounds, db.Sound{SoundID: "1", GuildID: sounds[0].GuildID, Name: "missing"})
+	var archives []*bytes.Buffer
+	exported, failed, err := b.writeExport(context.Background(), func() (io.Writer, error) {
+		archives = append(archives, &bytes.Buffer{})
+		return archives[len(archives)-1], nil
+	}, sounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != maxImportSounds+1 || failed != 1 {
+		t.Errorf("writeExport() = %d, %d, want %d exported and 1 failed", exported, failed, maxImportSounds+1)
+	}
+	var got []int
+	for _, archive := range archives {
+		a, err := readArchive(archive.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, len(a.sounds))
+	}
+	if len(got) != 2 || got[0] != maxImportSounds || got[1] != 1 {
+		t.Errorf("archives hold %v sounds, want %d then 1", got, maxImportSounds)
+	}
+
+	_, _, err = b.writeExport(context.Background(), func() (io.Writer, error) {
+		t.Error("writeExport() started an archive without any sounds to put in it")
+		return io.Discard, nil
+	}, sounds[len(sounds)-1:])
+	if !errors.Is(err, ErrExportFailed) {
+		t.Errorf("writeExport() of a sound which can't be downloaded = %v, want ErrExportFailed", err)
+	}
+}
+
+// TestExportSoundsTooLarge writes exports which Discord won't take as an attachment to the export directory instead.
+func TestExportSoundsTooLarge(t *testing.T) {
+	for _, exportDir := range []bool{false, true} {
+		t.Run(fmt.Sprintf("exportDir=%t", exportDir), func(t *testing.T) {
+			e := newServerEnv(t)
+			board := e.addSoundboard(t, "soundboardhost 1")
+			e.addSound(t, board.ID, "honk")
+			config := e.config()
+			if exportDir {
+				config.ExportDir = t.TempDir()
+			}
+			e.start(t, config)
+			waitFor(t, "inventory", func() (bool, bool) {
+				sounds, err := e.db.ListSounds(context.Background())
+				return true, err == nil && len(sounds) == 1
+			})
+			e.d.SetAttachmentLimit(100)
+			i := e.d.Interact(e.manager, e.admin.ID, e.main.ID, exportSoundsCommand)
+			if !exportDir {
+				waitFor(t, "export", followupMatching(e.d, i, regexp.MustCompile(`Request entity too large`)))
+				return
+			}
+			path := waitFor(t, "export", followupMatching(e.d, i, regexp.MustCompile(`^Exported 1 sounds to (.*\.zip) on the bot's disk\.$`)))[1]
+			if filepath.Dir(path) != config.ExportDir {
+				t.Errorf("export written to %q, want it in %q", path, config.ExportDir)
+			}
+		})
+	}
+}
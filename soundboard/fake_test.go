@@ -0,0 +1,158 @@
+package soundboard
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord/fake"
+)
+
+// testEnv is a bot running against a fake Discord, with a main guild owned by an admin and with a regular member.
+type testEnv struct {
+	d                *fake.Discord
+	creator, manager *fake.Session
+	admin, member    *discordgo.User
+	main             *discordgo.Guild
+	// regular is the main guild's role which is the member's only role.
+	regular discordgo.Snowflake
+	db      db.DB
+	b       *bot
+}
+
+// newTestEnv starts a bot against a fake Discord with a "tmpl" template holding the Member and DJ roles. config is
+// filled in with the admin, app ID and template.
+func newTestEnv(t *testing.T, config Config) *testEnv {
+	t.Helper()
+	e := &testEnv{d: fake.New()}
+	e.creator = e.d.AddBot("creator")
+	e.manager = e.d.AddBot("manager")
+	e.admin = e.d.AddUser("admin")
+	e.member = e.d.AddUser("member")
+	e.d.AddTemplate("tmpl", "Member", "DJ")
+	e.main = e.d.AddGuild("main", e.admin.ID, "Regular")
+	e.regular = e.main.Roles[1].ID
+	if err := e.d.Authorize(e.manager, e.main.ID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.d.AddMember(e.main.ID, e.member.ID, e.regular); err != nil {
+		t.Fatal(err)
+	}
+	database, err := db.New(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.db = database
+	config.Admins = append(config.Admins, e.admin.Username)
+	config.ManagerAppId = e.manager.CurrentApplication().ID
+	config.Template = "tmpl"
+	e.b, err = newBot(config, database, e.creator, e.manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.b.start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.b.Close() })
+	return e
+}
+
+// addAutorole makes the main guild's regular role an autorole for the DJ role.
+func (e *testEnv) addAutorole(t *testing.T) {
+	t.Helper()
+	i := e.d.Interact(e.manager, e.admin.ID, e.main.ID, autoroleCommand, opt("role", string(e.regular)), opt("template_role_name", "DJ"))
+	waitFor(t, "add-autorole", followupMatching(e.d, i, regexp.MustCompile("will assign")))
+}
+
+// createSoundboard creates a soundboard as the admin, following the invite and authorisation it asks for, and waits
+// for the creator to hand it over to the admin.
+func (e *testEnv) createSoundboard(t *testing.T, suffix int) *discordgo.Guild {
+	t.Helper()
+	i := e.d.Interact(e.manager, e.admin.ID, "", createSoundboardCommand, opt("server_suffix", float64(suffix)))
+	code := waitFor(t, "invite", followupMatching(e.d, i, regexp.MustCompile(`discord\.gg/(\d+)`)))[1]
+	if err := e.d.JoinInvite(code, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	guildID := waitFor(t, "authorisation DM", func() (discordgo.Snowflake, bool) {
+		for _, m := range e.d.DirectMessages(e.admin.ID) {
+			if m := regexp.MustCompile(`guild_id=(\d+)`).FindStringSubmatch(m.Content); m != nil {
+				return discordgo.Snowflake(m[1]), true
+			}
+		}
+		return "", false
+	})
+	if err := e.d.Authorize(e.manager, guildID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	return waitFor(t, "handover", func() (*discordgo.Guild, bool) {
+		g, _ := e.d.Guild(guildID)
+		return g, g.OwnerID == e.admin.ID && !hasMember(g, e.creator.CurrentUser().ID)
+	})
+}
+
+func hasMember(g *discordgo.Guild, userID discordgo.Snowflake) bool {
+	return slices.ContainsFunc(g.Members, func(m *discordgo.Member) bool { return m.User.ID == userID })
+}
+
+func roleID(g *discordgo.Guild, name string) discordgo.Snowflake {
+	for _, r := range g.Roles {
+		if r.Name == name {
+			return r.ID
+		}
+	}
+	return ""
+}
+
+func memberRoles(g *discordgo.Guild, userID discordgo.Snowflake) []discordgo.Snowflake {
+	for _, m := range g.Members {
+		if m.User.ID == userID {
+			return m.Roles
+		}
+	}
+	return nil
+}
+
+// waitFor polls f until it reports success, failing the test if it doesn't within a few seconds.
+func waitFor[T any](t *testing.T, what string, f func() (T, bool)) T {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, ok := f(); ok {
+			return v
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+	var zero T
+	return zero
+}
+
+// followupMatching finds a follow-up to the interaction matching re, returning its submatches.
+func followupMatching(d *fake.Discord, i *discordgo.Interaction, re *regexp.Regexp) func() ([]string, bool) {
+	return func() ([]string, bool) {
+		for _, f := range d.Followups(i.ID) {
+			if m := re.FindStringSubmatch(f); m != nil {
+				return m, true
+			}
+		}
+		return nil, false
+	}
+}
+
+// responseMatching checks whether the interaction was responded to with a message matching re, returning its
+// submatches.
+func responseMatching(d *fake.Discord, i *discordgo.Interaction, re *regexp.Regexp) func() ([]string, bool) {
+	return func() ([]string, bool) {
+		m := re.FindStringSubmatch(d.Response(i.ID))
+		return m, m != nil
+	}
+}
+
+func opt(name string, v any) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Value: v}
+}
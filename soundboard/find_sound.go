@@ -0,0 +1,66 @@
+package soundboard
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+)
+
+const (
+	findSoundCommand = "find-sound"
+	// maxFoundSounds is how many sounds /find-sound lists.
+	maxFoundSounds = 10
+)
+
+type findSoundOptions struct {
+	Query string `option:"query,required,maxlen=100,autocomplete=sounds" description:"The name, emoji or tag of the sound"`
+}
+
+func (b *bot) initFindSound() {
+	b.commands[findSoundCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Finds which soundboard a sound is in",
+		},
+		handler: bind(b.findSound),
+	}
+}
+
+func (b *bot) findSound(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *findSoundOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx).WithValues("query", options.Query)
+	logger.Info("Find sound requested")
+	sounds, err := b.searchSounds(ctx, options.Query)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	guildNames := b.guildNames()
+	for i, sound := range sounds {
+		if i == maxFoundSounds {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(sounds)-maxFoundSounds))
+			break
+		}
+		line := fmt.Sprintf("%q in %q (%s)", sound.Name, guildNames[sound.GuildID], sound.GuildID)
+		switch {
+		case sound.EmojiName != "":
+			line = sound.EmojiName + " " + line
+		case sound.EmojiID != "":
+			line = fmt.Sprintf("<:emoji:%s> %s", sound.EmojiID, line)
+		}
+		if sound.UserID != "" {
+			line += fmt.Sprintf(", added by <@%s>", sound.UserID)
+		}
+		lines = append(lines, line)
+	}
+	content := fmt.Sprintf("No sounds match %q.", options.Query)
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n")
+	}
+	if err := b.reply(ctx, interaction, user, followup, content); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed find sound request", err, user)
+	}
+	logger.Info("Find sound completed", "matches", len(sounds))
+	return nil
+}
@@ -15,9 +15,11 @@ const (
 
 func (b *bot) initFixRoles() {
 	b.commands[fixRolesCommand] = command{
-		&discordgo.ApplicationCommand{
+		command: &discordgo.ApplicationCommand{
 			Description: "Fix AutoRoles for calling user",
-		}, b.fixRolesCommand}
+		},
+		handler: bind(b.fixRolesCommand),
+	}
 }
 
 func (b *bot) findMainRoles(ctx context.Context, user *discordgo.User) (set.Set[discordgo.Snowflake], error) {
@@ -27,10 +29,10 @@ func (b *bot) findMainRoles(ctx context.Context, user *discordgo.User) (set.Set[
 	}
 	roles := set.New[discordgo.Snowflake]()
 	for guildID := range guildIDs {
-		member, err := b.manager.GuildMember(guildID, user.ID)
+		member, err := b.manager.GuildMember(guildID, user.ID, discordgo.WithContext(ctx))
 		if err != nil {
 			// Just assume that an error here means that the user isn't part of this guild.
-			klog.Warningf("%v: failed to look up membership of %q in %q", err, user, guildID)
+			klog.FromContext(ctx).Info("Failed to look up membership, assuming user is not a member", "memberGuildID", guildID, "err", err)
 			continue
 		}
 		for _, mr := range member.Roles {
@@ -40,8 +42,8 @@ func (b *bot) findMainRoles(ctx context.Context, user *discordgo.User) (set.Set[
 	return roles, nil
 }
 
-func (b *bot) fixRolesCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	klog.Infof("fix roles requested by %q", user)
+func (b *bot) fixRolesCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, _ *noOptions, followup *discordgo.Message) error {
+	klog.FromContext(ctx).Info("Fix roles requested")
 	mainRoles, err := b.findMainRoles(ctx, user)
 	if err != nil {
 		return err
@@ -52,19 +54,19 @@ func (b *bot) fixRolesCommand(ctx context.Context, interaction *discordgo.Intera
 	}
 	missingRoles := map[discordgo.Snowflake]set.Set[discordgo.Snowflake]{}
 	for guildID, roleIDs := range requiredRoles {
-		guild, err := b.manager.Guild(guildID)
+		guild, err := b.manager.Guild(guildID, discordgo.WithContext(ctx))
 		if err != nil {
-			klog.Warningf("%v: failed to look up guild %q", err, guildID)
+			klog.FromContext(ctx).Error(err, "Failed to look up guild", "soundboardID", guildID)
 			continue
 		}
 		if guild.OwnerID == user.ID {
 			continue
 		}
-		member, err := b.manager.GuildMember(guildID, user.ID)
+		member, err := b.manager.GuildMember(guildID, user.ID, discordgo.WithContext(ctx))
 		if err != nil {
 			// Just assume that an error here means that the user isn't part of this guild.
 			// This means we should invite them to the guild.
-			klog.Warningf("%v: failed to look up membership of %q in %q", err, user, guildID)
+			klog.FromContext(ctx).Info("Failed to look up membership, assuming user is not a member", "memberGuildID", guildID, "err", err)
 			continue
 		}
 		for _, mr := range member.Roles {
@@ -72,19 +74,18 @@ func (b *bot) fixRolesCommand(ctx context.Context, interaction *discordgo.Intera
 		}
 		missingRoles[guildID] = roleIDs
 		for roleID := range roleIDs {
-			if err := b.manager.GuildMemberRoleAdd(guildID, user.ID, roleID); err != nil {
-				g, _ := b.manager.Guild(guildID)
-				r, _ := b.manager.State.Role(guildID, roleID)
+			if err := b.manager.GuildMemberRoleAdd(guildID, user.ID, roleID, discordgo.WithContext(ctx)); err != nil {
+				g, _ := b.manager.Guild(guildID, discordgo.WithContext(ctx))
+				r, _ := b.manager.StateRole(guildID, roleID)
 				return fmt.Errorf("%w: failed to grant %q role %q (%s) in %q (%s)", err, user, roleID, r.Name, guildID, g.Name)
 			}
+			roleChanges.WithLabelValues("grant").Inc()
 		}
 	}
 
-	if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr(fmt.Sprintf("%q requires roles %+v", user, missingRoles)),
-	}); err != nil {
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("%q requires roles %+v", user, missingRoles)); err != nil {
 		return fmt.Errorf("%w: failed to notify %q of completed fix roles request", err, user)
 	}
-	klog.Infof("fix roles for %q completed", user)
+	klog.FromContext(ctx).Info("Fix roles completed", "missingRoles", len(missingRoles))
 	return nil
 }
@@ -0,0 +1,23 @@
+package soundboard
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestFixRoles(t *testing.T) {
+	e := newTestEnv(t, Config{})
+	e.addAutorole(t)
+	g := e.createSoundboard(t, 1)
+	// The member joined without going through /invite-me, so was never granted their autoroles.
+	if err := e.d.AddMember(g.ID, e.member.ID); err != nil {
+		t.Fatal(err)
+	}
+	i := e.d.Interact(e.manager, e.member.ID, e.main.ID, fixRolesCommand)
+	waitFor(t, "fix-roles", followupMatching(e.d, i, regexp.MustCompile(`requires roles`)))
+	g, _ = e.d.Guild(g.ID)
+	if roles, dj := memberRoles(g, e.member.ID), roleID(g, "DJ"); !slices.Contains(roles, dj) {
+		t.Errorf("member has roles %v after fix-roles, want DJ role %q from their autorole", roles, dj)
+	}
+}
@@ -3,3 +3,10 @@ package soundboard
 func toPtr[T any](x T) *T {
 	return &x
 }
+
+func ptrEqual[T comparable](x, y *T) bool {
+	if x == nil || y == nil {
+		return x == y
+	}
+	return *x == *y
+}
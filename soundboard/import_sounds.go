@@ -0,0 +1,446 @@
+package soundboard
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	importSoundsCommand = "import-sounds"
+	// importManifestName is the file in an archive which lists the sounds to import, see importManifest.
+	importManifestName = "manifest.json"
+	// maxArchiveSize is the largest archive /import-sounds downloads.
+	maxArchiveSize  = 25 * 1024 * 1024
+	maxManifestSize = 1024 * 1024
+	maxImportSounds = 1000
+	// maxImportProblems is how many problems are listed when an archive is rejected.
+	maxImportProblems = 10
+)
+
+var (
+	ErrInvalidArchive = errors.New("invalid archive")
+)
+
+type importSoundsOptions struct {
+	Archive discordgo.Snowflake `option:"archive,required,type=attachment" description:"A zip of MP3 or Ogg files, with a manifest.json listing them"`
+}
+
+// importManifest lists the sounds in an archive, such as:
+//
+//	{"sounds": [{"file": "birds/honk.mp3", "name": "honk", "emoji": "🪿", "volume": 0.5, "tags": ["bird"]}]}
+//
+// Only file and name are required. Each file may only be listed once, since an interrupted import carries on with the
+// files it hasn't imported yet. /export-sounds writes archives in the same form.
+type importManifest struct {
+	Sounds []importEntry `json:"sounds"`
+}
+
+type importEntry struct {
+	File   string   `json:"file"`
+	Name   string   `json:"name"`
+	Emoji  string   `json:"emoji,omitempty"`
+	Volume *float64 `json:"volume,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// UserID is who added the sound, who it is credited to in place of whoever imports it.
+	UserID discordgo.Snowflake `json:"user_id,omitempty"`
+	// SoundboardID and Soundboard are where an exported sound was, which are only kept for reference.
+	SoundboardID discordgo.Snowflake `json:"soundboard_id,omitempty"`
+	Soundboard   string              `json:"soundboard,omitempty"`
+}
+
+// soundArchive is an archive whose sounds have all been validated.
+type soundArchive struct {
+	// sum is the SHA-256 of the archive, which the sounds imported from it are recorded under.
+	sum    string
+	sounds []archivedSound
+}
+
+type archivedSound struct {
+	importEntry
+	file        *zip.File
+	contentType string
+}
+
+func (b *bot) initImportSounds() {
+	b.commands[importSoundsCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Uploads every sound in a zip to the soundboards",
+		},
+		handler:    bind(b.importSounds),
+		admin:      true,
+		background: true,
+		timeout:    time.Hour,
+	}
+}
+
+func (b *bot) importSounds(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *importSoundsOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Import sounds requested")
+	var attachment *discordgo.MessageAttachment
+	if resolved := interaction.ApplicationCommandData().Resolved; resolved != nil {
+		attachment = resolved.Attachments[options.Archive]
+	}
+	if attachment == nil {
+		return fmt.Errorf("%w: attachment %q was not sent with the command", ErrInvalidArchive, options.Archive)
+	}
+	if attachment.Size > maxArchiveSize {
+		return fmt.Errorf("%w: %q is larger than %dMB", ErrInvalidArchive, attachment.Filename, maxArchiveSize/1024/1024)
+	}
+	data, _, err := download(ctx, "attachments", attachment.URL, attachment.Filename, maxArchiveSize)
+	if errors.Is(err, errTooLarge) {
+		return fmt.Errorf("%w: %q is larger than %dMB", ErrInvalidArchive, attachment.Filename, maxArchiveSize/1024/1024)
+	}
+	if err != nil {
+		return err
+	}
+	archive, err := readArchive(data)
+	if err != nil {
+		return err
+	}
+	imported, skipped, err := b.importArchive(ctx, archive, user, func(ctx context.Context, content string) error {
+		return b.reply(ctx, interaction, user, followup, content)
+	})
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("Imported %d sounds from %q.", imported, attachment.Filename)
+	if skipped > 0 {
+		content += fmt.Sprintf(" %d had already been imported.", skipped)
+	}
+	if err := b.reply(ctx, interaction, user, followup, content); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed import sounds request", err, user)
+	}
+	logger.Info("Import sounds completed", "imported", imported, "skipped", skipped)
+	return nil
+}
+
+// ImportSounds imports the sounds in the archive at path as /import-sounds does, without a user to credit them to.
+// It connects to Discord without handling commands, so that it can be run alongside the bot.
+func ImportSounds(ctx context.Context, config Config, db db.DB, path string) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read archive", err)
+	}
+	archive, err := readArchive(data)
+	if err != nil {
+		return err
+	}
+	creator, manager, err := newSessions(config)
+	if err != nil {
+		return err
+	}
+	b, err := newBot(config, db, creator.session, manager.session)
+	if err != nil {
+		return err
+	}
+	b.creatorToken, b.managerToken = creator, manager
+	if err := b.connect(); err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, b.Close()) }()
+	logger := klog.FromContext(ctx).WithValues("archive", filepath.Base(path))
+	ctx = klog.NewContext(ctx, logger)
+	// The inventory decides which soundboards have room, and may be behind if the bot isn't running.
+	if err := b.syncSounds(ctx); err != nil {
+		return err
+	}
+	imported, skipped, err := b.importArchive(ctx, archive, nil, func(ctx context.Context, content string) error {
+		klog.FromContext(ctx).Info(content)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Imported sounds", "imported", imported, "skipped", skipped)
+	return nil
+}
+
+// readArchive opens a zip of sounds, checking that its manifest and every sound it lists are valid so that nothing
+// is uploaded from an archive which can't be imported in full.
+func readArchive(data []byte) (soundArchive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return soundArchive{}, fmt.Errorf("%w: not a zip file", ErrInvalidArchive)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[path.Clean(f.Name)] = f
+	}
+	f, ok := files[importManifestName]
+	if !ok {
+		return soundArchive{}, fmt.Errorf("%w: %s is missing", ErrInvalidArchive, importManifestName)
+	}
+	content, err := readZipFile(f, maxManifestSize)
+	if err != nil {
+		return soundArchive{}, err
+	}
+	var manifest importManifest
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return soundArchive{}, fmt.Errorf("%w: failed to parse %s: %w", ErrInvalidArchive, importManifestName, err)
+	}
+	if len(manifest.Sounds) == 0 {
+		return soundArchive{}, fmt.Errorf("%w: %s lists no sounds", ErrInvalidArchive, importManifestName)
+	}
+	if len(manifest.Sounds) > maxImportSounds {
+		return soundArchive{}, fmt.Errorf("%w: %s lists %d sounds, more than the %d which can be imported at once", ErrInvalidArchive, importManifestName, len(manifest.Sounds), maxImportSounds)
+	}
+	sum := sha256.Sum256(data)
+	archive := soundArchive{sum: hex.EncodeToString(sum[:])}
+	var problems []string
+	listed := map[string]bool{}
+	for _, entry := range manifest.Sounds {
+		entry.Name = strings.TrimSpace(entry.Name)
+		entry.File = path.Clean(entry.File)
+		if listed[entry.File] {
+			problems = append(problems, fmt.Sprintf("%q is listed more than once", entry.File))
+			continue
+		}
+		listed[entry.File] = true
+		sound, err := validateEntry(entry, files)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		archive.sounds = append(archive.sounds, sound)
+	}
+	if len(problems) > maxImportProblems {
+		problems = append(problems[:maxImportProblems], fmt.Sprintf("and %d more", len(problems)-maxImportProblems))
+	}
+	if len(problems) > 0 {
+		return soundArchive{}, fmt.Errorf("%w: %s", ErrInvalidArchive, strings.Join(problems, "; "))
+	}
+	return archive, nil
+}
+
+// validateEntry checks a sound listed by an archive's manifest as /add-sound would, returning what is needed to
+// upload it.
+func validateEntry(entry importEntry, files map[string]*zip.File) (archivedSound, error) {
+	if n := utf8.RuneCountInString(entry.Name); n < 2 || n > 32 {
+		return archivedSound{}, fmt.Errorf("%q must be 2 to 32 characters long", entry.Name)
+	}
+	if entry.Volume != nil && (*entry.Volume < 0 || *entry.Volume > 1) {
+		return archivedSound{}, fmt.Errorf("%q must have a volume from 0 to 1", entry.Name)
+	}
+	if entry.UserID != "" {
+		if _, err := strconv.ParseUint(string(entry.UserID), 10, 64); err != nil {
+			return archivedSound{}, fmt.Errorf("%q must have a Discord ID as its user_id", entry.Name)
+		}
+	}
+	if entry.File == "." {
+		return archivedSound{}, fmt.Errorf("%q has no file", entry.Name)
+	}
+	f, ok := files[entry.File]
+	if !ok {
+		return archivedSound{}, fmt.Errorf("%q is missing from the archive", entry.File)
+	}
+	data, err := readZipFile(f, maxSoundSize)
+	if errors.Is(err, errTooLarge) {
+		return archivedSound{}, fmt.Errorf("%q is larger than %dKB", entry.File, maxSoundSize/1024)
+	}
+	if err != nil {
+		return archivedSound{}, err
+	}
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(entry.File)))
+	contentType, err = soundType(data, entry.File, contentType)
+	if err != nil {
+		return archivedSound{}, err
+	}
+	if _, err := checkSound(data, entry.File, contentType); err != nil {
+		return archivedSound{}, err
+	}
+	tags := strings.Join(entry.Tags, ",")
+	entry.Tags = parseTags(&tags)
+	return archivedSound{importEntry: entry, file: f, contentType: contentType}, nil
+}
+
+// readZipFile reads a file from an archive, failing with errTooLarge if it is larger than limit bytes.
+func readZipFile(f *zip.File, limit int) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, errTooLarge
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %q: %w", ErrInvalidArchive, f.Name, err)
+	}
+	defer r.Close()
+	// The size in the archive may be wrong, so read one byte past the limit to check.
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %q: %w", ErrInvalidArchive, f.Name, err)
+	}
+	if len(data) > limit {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+// importArchive uploads the sounds in an archive which haven't already been imported from it to the first
+// soundboards with room for them. Before anything is uploaded, soundboards are created for the sounds which the
+// existing soundboards don't have room for, see makeRoom. Sounds are credited to user, unless the manifest says who
+// added them. notify is called to tell whoever is waiting on the import about any new soundboards. It returns how
+// many sounds were imported and skipped.
+// Sounds are uploaded one at a time through the manager's session, whose rate limit buckets hold back uploads when
+// Discord's limits are reached. Each sound is recorded as imported as it is uploaded, so an import which is
+// interrupted carries on where it left off when the same archive is imported again.
+func (b *bot) importArchive(ctx context.Context, archive soundArchive, user *discordgo.User, notify func(context.Context, string) error) (imported, skipped int, err error) {
+	ctx, end := step(ctx, "import sounds", attribute.String("import.archive", archive.sum))
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx)
+	done, err := b.db.ListImportedSounds(ctx, archive.sum)
+	if err != nil {
+		return 0, 0, err
+	}
+	var userID discordgo.Snowflake
+	if user != nil {
+		userID = user.ID
+	}
+	var pending []archivedSound
+	for _, sound := range archive.sounds {
+		if _, ok := done[sound.File]; ok {
+			logger.V(1).Info("Sound already imported", "file", sound.File)
+			skipped++
+			continue
+		}
+		pending = append(pending, sound)
+	}
+	if err := b.makeRoom(ctx, len(pending), user, notify); err != nil {
+		return 0, skipped, err
+	}
+	for i, sound := range pending {
+		jobFromContext(ctx).setStatus(fmt.Sprintf("Importing %q (%d of %d)", sound.Name, skipped+i+1, len(archive.sounds)))
+		data, err := readZipFile(sound.file, maxSoundSize)
+		if err != nil {
+			return imported, skipped, err
+		}
+		params := &discord.SoundboardSoundParams{
+			Name:   sound.Name,
+			Sound:  soundURI(sound.contentType, data),
+			Volume: sound.Volume,
+		}
+		if sound.Emoji != "" {
+			params.EmojiID, params.EmojiName = parseEmoji(sound.Emoji)
+		}
+		created, err := b.uploadSound(ctx, params)
+		if errors.Is(err, ErrNoFreeSlots) {
+			// Other sounds were added while importing.
+			created, err = b.overflow(ctx, notify, params)
+		}
+		if err != nil {
+			return imported, skipped, fmt.Errorf("%w: imported %d of %d sounds, import the same archive again to carry on", err, imported+skipped, len(archive.sounds))
+		}
+		addedBy := userID
+		if sound.UserID != "" {
+			addedBy = sound.UserID
+		}
+		if err := b.db.ImportSound(ctx, archive.sum, sound.File, db.Sound{
+			SoundID:   created.ID,
+			GuildID:   created.GuildID,
+			Name:      created.Name,
+			EmojiID:   created.EmojiID,
+			EmojiName: created.EmojiName,
+			Volume:    created.Volume,
+			UserID:    addedBy,
+			Added:     time.Now(),
+			Tags:      sound.Tags,
+		}); err != nil {
+			return imported, skipped, err
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// makeRoom creates as many soundboards as it takes for count more sounds to fit, handing them over to owner, who is
+// sent invites to them through notify. Without an owner, they are handed over to Config.OverflowOwner by DM, and
+// ErrNoFreeSlots is returned if there is no overflow owner.
+func (b *bot) makeRoom(ctx context.Context, count int, owner *discordgo.User, notify func(context.Context, string) error) error {
+	free, err := b.freeSlots(ctx)
+	if err != nil {
+		return err
+	}
+	if count <= free {
+		return nil
+	}
+	s := b.current()
+	sendInvite := func(ctx context.Context, invite *discordgo.Invite) error {
+		return notify(ctx, fmt.Sprintf("The soundboards only have room for %d of the %d sounds, join https://discord.gg/%s to own a new one for the rest.", free, count, invite.Code))
+	}
+	if owner == nil {
+		if s.overflowOwner == "" {
+			return fmt.Errorf("%w: %d sounds don't fit in the %d free slots", ErrNoFreeSlots, count, free)
+		}
+		owner = &discordgo.User{ID: s.overflowOwner}
+		sendInvite = func(ctx context.Context, invite *discordgo.Invite) error {
+			return b.sendDM(ctx, owner, fmt.Sprintf("The soundboards are too full to import %d sounds into, so I have created a new one for you to own: https://discord.gg/%s", count, invite.Code))
+		}
+	}
+	select {
+	case b.overflowing <- struct{}{}:
+		defer func() { <-b.overflowing }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// New soundboards aren't boosted.
+	perSoundboard := discord.MaxSoundboardSounds(discordgo.PremiumTierNone)
+	needed := (count - free + perSoundboard - 1) / perSoundboard
+	soundboards, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		return err
+	}
+	if s.maxSoundboards > 0 && len(soundboards)+needed > s.maxSoundboards {
+		return fmt.Errorf("%w: %d sounds need %d more soundboards, and there are already %d", ErrTooManySoundboards, count, needed, len(soundboards))
+	}
+	logger := klog.FromContext(ctx)
+	logger.Info("Creating soundboards to import into", "sounds", count, "free", free, "soundboards", needed, "ownerID", owner.ID)
+	for i := 0; i < needed; i++ {
+		guild, err := b.createGuild(ctx, strconv.Itoa(len(soundboards)+i+1))
+		if err != nil {
+			return fmt.Errorf("failed to create guild: %w", err)
+		}
+		if err := b.handOver(ctx, guild, owner, sendInvite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeSlots returns how many more sounds the soundboards in the Sounds inventory have room for at their boost tiers.
+func (b *bot) freeSlots(ctx context.Context) (int, error) {
+	counts, err := b.db.CountSounds(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var free int
+	for guildID, count := range counts {
+		guild, err := b.manager.Guild(guildID, discordgo.WithContext(ctx))
+		if err != nil {
+			return 0, err
+		}
+		free += max(discord.MaxSoundboardSounds(guild.PremiumTier)-count, 0)
+	}
+	return free, nil
+}
@@ -0,0 +1,107 @@
+package soundboard
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// soundZip is an archive of count sounds, as /import-sounds takes.
+func soundZip(t *testing.T, count int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	var entries []string
+	for i := 0; i < count; i++ {
+		name, file := fmt.Sprintf("sound %02d", i), fmt.Sprintf("%02d.mp3", i)
+		f, err := w.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(silentMP3(name)); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fmt.Sprintf(`{"file": %q, "name": %q}`, file, name))
+	}
+	f, err := w.Create(importManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(`{"sounds": [` + strings.Join(entries, ",") + `]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// addSoundboard adds a soundboard owned by the admin to the fake Discord and the inventory.
+func (e *serverEnv) addSoundboard(t *testing.T, name string) *discordgo.Guild {
+	t.Helper()
+	g := e.d.AddGuild(name, e.admin.ID)
+	if err := e.d.Authorize(e.manager, g.ID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.db.UpsertSoundboard(context.Background(), g.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// TestImportSoundsCapacity imports more sounds than the soundboards have room for, which uploads nothing unless
+// there is someone to hand a new soundboard to. Attachments are only served over HTTP, so it runs against the fake
+// server.
+func TestImportSoundsCapacity(t *testing.T) {
+	e := newServerEnv(t)
+	b := e.start(t, e.config())
+	board := e.addSoundboard(t, "soundboardhost 1")
+	archive, err := readArchive(soundZip(t, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify := func(context.Context, string) error { return nil }
+	if _, _, err := b.importArchive(context.Background(), archive, nil, notify); !errors.Is(err, ErrNoFreeSlots) || !strings.Contains(err.Error(), "10 sounds don't fit in the 8 free slots") {
+		t.Errorf("importArchive() without an overflow owner = %v, want ErrNoFreeSlots", err)
+	}
+	if got := len(e.d.Sounds(board.ID)); got != 0 {
+		t.Errorf("importArchive() uploaded %d sounds before failing", got)
+	}
+
+	// The admin importing the archive is given a new soundboard for the sounds which don't fit.
+	a := e.d.AddAttachment("sounds.zip", "application/zip", soundZip(t, 10))
+	i := e.d.Interact(e.manager, e.admin.ID, e.main.ID, importSoundsCommand,
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "archive", Type: discordgo.ApplicationCommandOptionAttachment, Value: string(a.ID)})
+	code := waitFor(t, "invite", followupMatching(e.d, i, regexp.MustCompile(`room for 8 of the 10 sounds, join https://discord\.gg/(\d+)`)))[1]
+	if got := len(e.d.Sounds(board.ID)); got != 0 {
+		t.Errorf("uploaded %d sounds before the new soundboard was ready", got)
+	}
+	if err := e.d.JoinInvite(code, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	guildID := waitFor(t, "authorisation DM", func() (discordgo.Snowflake, bool) {
+		for _, m := range e.d.DirectMessages(e.admin.ID) {
+			if m := regexp.MustCompile(`guild_id=(\d+)`).FindStringSubmatch(m.Content); m != nil {
+				return discordgo.Snowflake(m[1]), true
+			}
+		}
+		return "", false
+	})
+	if err := e.d.Authorize(e.manager, guildID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "import", followupMatching(e.d, i, regexp.MustCompile(`Imported 10 sounds from "sounds.zip"\.$`)))
+	if got, want := []int{len(e.d.Sounds(board.ID)), len(e.d.Sounds(guildID))}, []int{8, 2}; got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("sounds per soundboard = %v, want %v", got, want)
+	}
+	if g, _ := e.d.Guild(guildID); g.OwnerID != e.admin.ID {
+		t.Errorf("new soundboard is owned by %q, want the admin", g.OwnerID)
+	}
+}
@@ -13,27 +13,18 @@ import (
 )
 
 const (
-	initialiseDBCommand       = "initialise-db"
-	initialiseDBOptionAll     = "all"
-	initialiseDBOptionCurrent = "current"
+	initialiseDBCommand = "initialise-db"
 )
 
+type initialiseDBOptions struct {
+	All     *struct{} `option:"all" description:"Reinitialise all servers that the bot is a member of"`
+	Current *struct{} `option:"current" description:"Reinitialise the server that this command is executing in"`
+}
+
 func (b *bot) initInitialiseServer() {
-	b.commands[initialiseDBCommand] = command{&discordgo.ApplicationCommand{
+	b.commands[initialiseDBCommand] = command{command: &discordgo.ApplicationCommand{
 		Description: "Initialises the bot's DB entries for soundboard servers",
-		Options: []*discordgo.ApplicationCommandOption{
-			{
-				Type:        discordgo.ApplicationCommandOptionSubCommand,
-				Name:        initialiseDBOptionAll,
-				Description: "Reinitialise all servers that the bot is a member of",
-			},
-			{
-				Type:        discordgo.ApplicationCommandOptionSubCommand,
-				Name:        initialiseDBOptionCurrent,
-				Description: "Reinitialise the server that this command is executing in",
-			},
-		},
-	}, b.initialiseDBCommand}
+	}, handler: bind(b.initialiseDBCommand), admin: true, inGuild: true}
 }
 
 func (b *bot) checkRoleOrder(guild *discordgo.Guild, appName string) bool {
@@ -42,8 +33,9 @@ func (b *bot) checkRoleOrder(guild *discordgo.Guild, appName string) bool {
 
 func (b *bot) initialiseDB(ctx context.Context, guild *discordgo.Guild) error {
 	roles := map[string]discordgo.Snowflake{}
+	templateRoles := b.current().roles
 	for _, role := range guild.Roles {
-		if b.roles.Has(role.Name) {
+		if templateRoles.Has(role.Name) {
 			roles[role.Name] = role.ID
 		}
 	}
@@ -53,78 +45,72 @@ func (b *bot) initialiseDB(ctx context.Context, guild *discordgo.Guild) error {
 	return nil
 }
 
-func (b *bot) initialiseDBCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	if err := b.validateUser(user, initialiseDBCommand); err != nil {
-		return err
-	}
-	klog.Infof("initialise db request received from %q", user)
+func (b *bot) initialiseDBCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *initialiseDBOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Initialise DB requested")
 	invalidPriority := set.New[string]()
-	for opt := range options {
-		switch opt {
-		case initialiseDBOptionAll:
-			klog.Info("initialising db for all guilds")
-			unmanagedGuilds, err := b.db.ListGuilds(ctx)
-			if err != nil {
-				return err
-			}
-			staleData, err := b.db.ListSoundboards(ctx)
-			if err != nil {
-				return err
+	switch {
+	case options.All != nil:
+		logger.Info("Initialising DB for all guilds")
+		unmanagedGuilds, err := b.db.ListGuilds(ctx)
+		if err != nil {
+			return err
+		}
+		staleData, err := b.db.ListSoundboards(ctx)
+		if err != nil {
+			return err
+		}
+		ugs, err := api.Paginate(func(last *discordgo.UserGuild) ([]*discordgo.UserGuild, error) {
+			var id discordgo.Snowflake
+			if last != nil {
+				id = last.ID
 			}
-			ugs, err := api.Paginate(func(last *discordgo.UserGuild) ([]*discordgo.UserGuild, error) {
-				var id discordgo.Snowflake
-				if last != nil {
-					id = last.ID
-				}
-				return b.manager.UserGuilds(200, "", id)
-			})
+			return b.manager.UserGuilds(200, "", id, discordgo.WithContext(ctx))
+		})
+		if err != nil {
+			return err
+		}
+		for _, ug := range ugs {
+			guild, err := b.manager.Guild(ug.ID, discordgo.WithContext(ctx))
 			if err != nil {
 				return err
 			}
-			for _, ug := range ugs {
-				guild, err := b.manager.Guild(ug.ID)
-				if err != nil {
-					return err
-				}
-				if unmanagedGuilds.Has(guild.ID) {
-					continue
-				}
-				if staleData.Has(guild.ID) {
-					delete(staleData, guild.ID)
-				}
-				klog.Infof("initialising db for %q(%s)", guild.Name, guild.ID)
-				if err := b.initialiseDB(ctx, guild); err != nil {
-					return err
-				}
-				if !b.checkRoleOrder(guild, b.manager.State.User.Username) {
-					invalidPriority.Put(guild.Name)
-				}
+			if unmanagedGuilds.Has(guild.ID) {
+				continue
 			}
-			for guildID := range staleData {
-				klog.Infof("deleting stale db entry %q", guildID)
-				if err := b.db.DeleteSoundboard(ctx, guildID); err != nil {
-					return err
-				}
-			}
-		case initialiseDBOptionCurrent:
-			klog.Infof("initialising db for %q only", interaction.GuildID)
-			guild, err := b.manager.Guild(interaction.GuildID)
-			if err != nil {
-				return fmt.Errorf("%w: failed to lookup guild %q", err, interaction.GuildID)
+			if staleData.Has(guild.ID) {
+				delete(staleData, guild.ID)
 			}
+			logger.Info("Initialising DB for guild", "soundboardID", guild.ID, "name", guild.Name)
 			if err := b.initialiseDB(ctx, guild); err != nil {
 				return err
 			}
-			if !b.checkRoleOrder(guild, b.manager.State.User.Username) {
-				invalidPriority.Put(string(guild.Name))
+			if !b.checkRoleOrder(guild, b.manager.CurrentUser().Username) {
+				invalidPriority.Put(guild.Name)
+			}
+		}
+		for guildID := range staleData {
+			logger.Info("Deleting stale DB entry", "soundboardID", guildID)
+			if err := b.db.DeleteSoundboard(ctx, guildID); err != nil {
+				return err
 			}
 		}
+	case options.Current != nil:
+		logger.Info("Initialising DB for the current guild only")
+		guild, err := b.manager.Guild(interaction.GuildID, discordgo.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("%w: failed to lookup guild %q", err, interaction.GuildID)
+		}
+		if err := b.initialiseDB(ctx, guild); err != nil {
+			return err
+		}
+		if !b.checkRoleOrder(guild, b.manager.CurrentUser().Username) {
+			invalidPriority.Put(string(guild.Name))
+		}
 	}
-	if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr(fmt.Sprintf("db has been initialised\nThe following guilds need manual role reordering:\n\t%s", strings.Join(invalidPriority.Elements(), "\n\t"))),
-	}); err != nil {
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("db has been initialised\nThe following guilds need manual role reordering:\n\t%s", strings.Join(invalidPriority.Elements(), "\n\t"))); err != nil {
 		return fmt.Errorf("%w: failed to notify %q of completed initialise DB request", err, user)
 	}
-	klog.Infof("db has been initialised by %q", user)
+	logger.Info("DB initialised")
 	return nil
 }
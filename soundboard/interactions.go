@@ -0,0 +1,187 @@
+package soundboard
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+)
+
+const (
+	interactionsPath = "/interactions"
+	// Discord gives up on an interaction which isn't responded to within 3 seconds.
+	interactionResponseDeadline = time.Second * 3
+	maxInteractionSize          = 1 << 20
+)
+
+var (
+	ErrUnknownCommand     = errors.New("unknown command")
+	ErrInteractionExpired = errors.New("interaction expired")
+)
+
+// dispatch runs the command which the interaction calls, or suggests values for the option being typed if it is
+// an autocomplete interaction.
+func (b *bot) dispatch(ctx context.Context, interaction *discordgo.Interaction) (err error) {
+	ctx, end := span(ctx, "interaction "+interaction.ApplicationCommandData().Name,
+		attribute.String("interaction.id", string(interaction.ID)),
+		attribute.String("guild.id", string(interaction.GuildID)),
+	)
+	defer func() { end(err) }()
+	command, ok := b.commands[interaction.ApplicationCommandData().Name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, interaction.ApplicationCommandData().Name)
+	}
+	var user *discordgo.User
+	if interaction.User != nil {
+		user = interaction.User
+	} else {
+		if interaction.Member == nil {
+			return fmt.Errorf("interaction request received without any identified user: %+v", interaction)
+		}
+		user = interaction.Member.User
+	}
+	// The interaction ID correlates every log line of the call, including those of any job it starts.
+	ctx = klog.NewContext(ctx, klog.FromContext(ctx).WithValues(
+		"correlationID", interaction.ID,
+		"command", interaction.ApplicationCommandData().Name,
+		"userID", user.ID,
+		"guildID", interaction.GuildID,
+	))
+	if interaction.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		return b.autocomplete(ctx, interaction, user, command)
+	}
+	if !b.inflight.begin() {
+		if err := b.turnAway(ctx, interaction); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to turn away user")
+		}
+		return fmt.Errorf("%w: %q called %q", ErrShuttingDown, user, interaction.ApplicationCommandData().Name)
+	}
+	defer b.inflight.end()
+	return command.run(ctx, interaction, user, interaction.ApplicationCommandData().Options, nil)
+}
+
+type responderKey struct{}
+
+// httpResponder hands the response to an interaction back to the HTTP request which delivered it.
+type httpResponder struct {
+	responses chan *discordgo.InteractionResponse
+	written   chan error
+	closed    chan struct{}
+}
+
+func (r *httpResponder) respond(resp *discordgo.InteractionResponse) error {
+	select {
+	case r.responses <- resp:
+		return <-r.written
+	case <-r.closed:
+		return ErrInteractionExpired
+	}
+}
+
+// respond acknowledges the interaction, through the HTTP response if it was received over HTTP.
+// Calls from the dashboard have nothing to acknowledge.
+func (b *bot) respond(ctx context.Context, interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
+	if dashboardCallFromContext(ctx) != nil {
+		return nil
+	}
+	if r, ok := ctx.Value(responderKey{}).(*httpResponder); ok {
+		return r.respond(resp)
+	}
+	return b.manager.InteractionRespond(interaction, resp, discordgo.WithContext(ctx))
+}
+
+// verify checks that the request was signed by Discord, see
+// https://discord.com/developers/docs/interactions/receiving-and-responding#security-and-authorization.
+func (b *bot) verify(r *http.Request, body []byte) bool {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(b.publicKey, append([]byte(r.Header.Get("X-Signature-Timestamp")), body...), signature)
+}
+
+func writeInteractionResponse(w http.ResponseWriter, resp *discordgo.InteractionResponse) error {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// serveInteractions receives interactions as webhooks from Discord, instead of through the manager's gateway.
+func (b *bot) serveInteractions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxInteractionSize))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	if !b.verify(r, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+	var interaction discordgo.Interaction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+	switch interaction.Type {
+	case discordgo.InteractionPing:
+		if err := writeInteractionResponse(w, &discordgo.InteractionResponse{Type: discordgo.InteractionResponsePong}); err != nil {
+			klog.Errorf("%v: failed to respond to ping", err)
+		}
+		return
+	case discordgo.InteractionApplicationCommand, discordgo.InteractionApplicationCommandAutocomplete:
+	default:
+		http.Error(w, fmt.Sprintf("unsupported interaction type %v", interaction.Type), http.StatusBadRequest)
+		return
+	}
+	responder := &httpResponder{
+		responses: make(chan *discordgo.InteractionResponse),
+		written:   make(chan error, 1),
+		closed:    make(chan struct{}),
+	}
+	defer close(responder.closed)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The command outlives the request, which only carries the initial response.
+		if err := b.dispatch(context.WithValue(b.ctx, responderKey{}, responder), &interaction); err != nil {
+			klog.ErrorS(err, "Interaction failed", "correlationID", interaction.ID)
+		}
+	}()
+	select {
+	case resp := <-responder.responses:
+		responder.written <- writeInteractionResponse(w, resp)
+	case <-done:
+		http.Error(w, "command failed without responding", http.StatusInternalServerError)
+	case <-time.After(interactionResponseDeadline):
+		klog.ErrorS(nil, "Interaction was not responded to in time", "correlationID", interaction.ID, "deadline", interactionResponseDeadline)
+		http.Error(w, "timed out", http.StatusServiceUnavailable)
+	}
+}
+
+// serveInteractionsHTTP listens for interactions on address until the bot is closed.
+func (b *bot) serveInteractionsHTTP(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(interactionsPath, b.serveInteractions)
+	b.interactions = &http.Server{Addr: address, Handler: mux}
+	go func() {
+		klog.Infof("receiving interactions on %s%s", address, interactionsPath)
+		if err := b.interactions.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("%v: interactions server stopped", err)
+		}
+	}()
+}
@@ -0,0 +1,126 @@
+package soundboard
+
+import (
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+// initInventory keeps the Sounds table in step with the sounds in every soundboard, which syncSounds catches up on
+// at startup and the manager's soundboard events keep up to date afterwards.
+func (b *bot) initInventory() {
+	b.managerHandlers = append(b.managerHandlers,
+		func(_ *discordgo.Session, event *discord.SoundboardSoundCreate) {
+			b.recordSound(event.SoundboardSound)
+		},
+		func(_ *discordgo.Session, event *discord.SoundboardSoundUpdate) {
+			b.recordSound(event.SoundboardSound)
+		},
+		b.forgetSound,
+	)
+}
+
+// inventorySound converts a sound to its record in the Sounds table.
+// Sounds uploaded by the manager are recorded without a user, since /add-sound records who asked for them.
+func (b *bot) inventorySound(sound *discord.SoundboardSound) db.Sound {
+	added, err := discordgo.SnowflakeTimestamp(sound.ID)
+	if err != nil {
+		added = time.Now()
+	}
+	s := db.Sound{
+		SoundID:   sound.ID,
+		GuildID:   sound.GuildID,
+		Name:      sound.Name,
+		EmojiID:   sound.EmojiID,
+		EmojiName: sound.EmojiName,
+		Volume:    sound.Volume,
+		Added:     added,
+	}
+	if sound.User != nil && sound.User.ID != b.manager.CurrentUser().ID {
+		s.UserID = sound.User.ID
+	}
+	return s
+}
+
+// syncSounds lists the sounds in every soundboard, replacing those in the Sounds table, so that changes made while
+// the bot wasn't running are caught up on.
+func (b *bot) syncSounds(ctx context.Context) (err error) {
+	ctx, end := span(ctx, "sync sounds")
+	defer func() { end(err) }()
+	soundboards, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		return err
+	}
+	var total int
+	for guildID := range soundboards {
+		logger := klog.FromContext(ctx).WithValues("soundboardID", guildID)
+		sounds, err := b.manager.GuildSoundboardSounds(guildID, discordgo.WithContext(ctx))
+		if err != nil {
+			// The soundboard may not have been handed over yet, which shouldn't hold up the others.
+			logger.Error(err, "Failed to list sounds")
+			continue
+		}
+		var records []db.Sound
+		for _, sound := range sounds {
+			if sound.GuildID == "" {
+				sound.GuildID = guildID
+			}
+			records = append(records, b.inventorySound(sound))
+		}
+		if err := b.db.ReplaceSounds(ctx, guildID, records); err != nil {
+			return err
+		}
+		logger.V(1).Info("Synced sounds", "sounds", len(records))
+		total += len(records)
+	}
+	klog.FromContext(ctx).Info("Synced sound inventory", "soundboards", len(soundboards), "sounds", total)
+	return nil
+}
+
+// tracked reports whether guildID is a soundboard, since the manager also gets events for other guilds it is in.
+func (b *bot) tracked(ctx context.Context, guildID discordgo.Snowflake) (bool, error) {
+	soundboards, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		return false, err
+	}
+	return soundboards.Has(guildID), nil
+}
+
+// recordSound adds or updates a sound in the Sounds table, when it is in a soundboard.
+func (b *bot) recordSound(sound *discord.SoundboardSound) {
+	var err error
+	ctx, end := span(b.ctx, "record sound", attribute.String("soundboard.id", string(sound.GuildID)), attribute.String("sound.id", string(sound.ID)))
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx).WithValues("soundboardID", sound.GuildID, "soundID", sound.ID)
+	ok, err := b.tracked(ctx, sound.GuildID)
+	if err != nil {
+		logger.Error(err, "Failed to look up soundboard")
+		return
+	}
+	if !ok {
+		return
+	}
+	if err = b.db.UpsertSound(ctx, b.inventorySound(sound)); err != nil {
+		logger.Error(err, "Failed to record sound")
+		return
+	}
+	logger.V(1).Info("Recorded sound", "name", sound.Name)
+}
+
+// forgetSound removes a deleted sound from the Sounds table.
+func (b *bot) forgetSound(_ *discordgo.Session, event *discord.SoundboardSoundDelete) {
+	var err error
+	ctx, end := span(b.ctx, "forget sound", attribute.String("soundboard.id", string(event.GuildID)), attribute.String("sound.id", string(event.SoundID)))
+	defer func() { end(err) }()
+	if err = b.db.DeleteSound(ctx, event.SoundID); err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to forget sound", "soundboardID", event.GuildID, "soundID", event.SoundID)
+		return
+	}
+	klog.FromContext(ctx).V(1).Info("Forgot sound", "soundboardID", event.GuildID, "soundID", event.SoundID)
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/kagadar/go-pipeline/channels"
 	"github.com/kagadar/go-syncmap"
+	"github.com/kagadar/soundboardbot/discord"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
@@ -17,9 +20,13 @@ const (
 
 func (b *bot) initInvite() {
 	b.commands[inviteCommand] = command{
-		&discordgo.ApplicationCommand{
+		command: &discordgo.ApplicationCommand{
 			Description: "Invite to all soundboards",
-		}, b.inviteCommand}
+		},
+		handler:    bind(b.inviteCommand),
+		background: true,
+		timeout:    time.Hour,
+	}
 	b.creatorHandlers = append(b.creatorHandlers, func(_ *discordgo.Session, event *discordgo.GuildMemberAdd) {
 		b.grantAutoRoles(b.creator, event, &b.creatorInvites)
 	})
@@ -28,7 +35,7 @@ func (b *bot) initInvite() {
 	})
 }
 
-func (b *bot) grantAutoRoles(session *discordgo.Session, event *discordgo.GuildMemberAdd, pending *syncmap.Map[discordgo.Snowflake, pendingInvite]) {
+func (b *bot) grantAutoRoles(session discord.Session, event *discordgo.GuildMemberAdd, pending *syncmap.Map[discordgo.Snowflake, pendingInvite]) {
 	// Check if event has a related grant
 	grant, ok := pending.Load(event.GuildID)
 	if !ok {
@@ -42,10 +49,13 @@ func (b *bot) grantAutoRoles(session *discordgo.Session, event *discordgo.GuildM
 		// Another thread claimed this grant already
 		return
 	}
-	klog.Infof("%q has joined %q, granting autoroles", event.User, event.GuildID)
-	ctx := context.Background()
 	var err error
 	defer func() { grant.done <- err }()
+	// Roles are granted as part of the command waiting on the invite, so are logged and traced under it.
+	ctx, end := step(grant.ctx, "grant autoroles", attribute.String("guild.id", string(event.GuildID)))
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx).WithValues("inviteGuildID", event.GuildID)
+	logger.Info("User has joined, granting autoroles")
 	mainRoles, err := b.findMainRoles(ctx, event.User)
 	if err != nil {
 		return
@@ -55,62 +65,66 @@ func (b *bot) grantAutoRoles(session *discordgo.Session, event *discordgo.GuildM
 		return
 	}
 	for role := range neededRoles {
-		if err = session.GuildMemberRoleAdd(event.GuildID, grant.user, role); err != nil {
+		if err = session.GuildMemberRoleAdd(event.GuildID, grant.user, role, discordgo.WithContext(ctx)); err != nil {
 			return
 		}
+		roleChanges.WithLabelValues("grant").Inc()
 	}
-	klog.Infof("%q has been granted autoroles in %q", event.User, event.GuildID)
+	logger.Info("Granted autoroles", "roles", len(neededRoles))
 }
 
-func createInvite(session *discordgo.Session, pending *syncmap.Map[discordgo.Snowflake, pendingInvite], guild *discordgo.Guild, userID discordgo.Snowflake) (*discordgo.Invite, chan error, error) {
-	done := make(chan error)
-	pending.Store(guild.ID, pendingInvite{user: userID, done: done})
-	invite, err := session.ChannelInviteCreate(guild.SystemChannelID, discordgo.Invite{})
+func createInvite(ctx context.Context, session discord.Session, pending *syncmap.Map[discordgo.Snowflake, pendingInvite], guild *discordgo.Guild, userID discordgo.Snowflake) (*discordgo.Invite, chan error, error) {
+	done := make(chan error, 1)
+	pending.Store(guild.ID, pendingInvite{user: userID, done: done, ctx: context.WithoutCancel(ctx)})
+	ctx, end := step(ctx, "create invite", attribute.String("guild.id", string(guild.ID)))
+	klog.FromContext(ctx).Info("Creating invite", "inviteGuildID", guild.ID)
+	invite, err := session.ChannelInviteCreate(guild.SystemChannelID, discordgo.Invite{}, discordgo.WithContext(ctx))
+	end(err)
 	if err != nil {
 		return nil, nil, err
 	}
 	return invite, done, nil
 }
 
-func (b *bot) inviteCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	klog.Infof("invite requested by %q", user)
+func (b *bot) inviteCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, _ *noOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Invite requested")
 
 	guildIDs, err := b.db.ListSoundboards(ctx)
 	if err != nil {
 		return err
 	}
 	for guildID := range guildIDs {
-		if _, err := b.manager.GuildMember(guildID, user.ID); err != nil {
+		if _, err := b.manager.GuildMember(guildID, user.ID, discordgo.WithContext(ctx)); err != nil {
 			actual := &discordgo.RESTError{}
 			if !errors.As(err, &actual) {
 				return fmt.Errorf("%w: failed to look up membership of %q in %q", err, user, guildID)
 			}
 			if actual.Message.Message == "Unknown Member" {
-				guild, err := b.manager.Guild(guildID)
+				guild, err := b.manager.Guild(guildID, discordgo.WithContext(ctx))
 				if err != nil {
 					return err
 				}
-				invite, done, err := createInvite(b.manager, &b.managerInvites, guild, user.ID)
+				invite, done, err := createInvite(ctx, b.manager, &b.managerInvites, guild, user.ID)
 				if err != nil {
 					return err
 				}
-				if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-					Content: toPtr(fmt.Sprintf("https://discord.gg/%s", invite.Code)),
-				}); err != nil {
+				if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("https://discord.gg/%s", invite.Code)); err != nil {
 					return fmt.Errorf("%w: failed to notify %q of invite request", err, user)
 				}
-				klog.Infof("waiting for %q to join %q", user, guild.ID)
-				if err, _, _ := channels.Await(ctx, done); err != nil {
+				stepCtx, end := step(ctx, "await join", attribute.String("guild.id", string(guild.ID)))
+				klog.FromContext(stepCtx).Info("Waiting for user to join", "inviteGuildID", guild.ID)
+				err, _, _ = channels.Await(ctx, done)
+				end(err)
+				if err != nil {
 					return fmt.Errorf("%w: failed to wait for %q to join %q", err, user, guild.ID)
 				}
 			}
 		}
 	}
-	if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr("done"),
-	}); err != nil {
+	if err := b.reply(ctx, interaction, user, followup, "done"); err != nil {
 		return fmt.Errorf("%w: failed to notify %q of completed invite request", err, user)
 	}
-	klog.Infof("invite for %q completed", user)
+	logger.Info("Invite completed")
 	return nil
 }
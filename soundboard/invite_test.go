@@ -0,0 +1,23 @@
+package soundboard
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestInviteMe(t *testing.T) {
+	e := newTestEnv(t, Config{})
+	e.addAutorole(t)
+	g := e.createSoundboard(t, 1)
+	i := e.d.Interact(e.manager, e.member.ID, e.main.ID, inviteCommand)
+	code := waitFor(t, "invite", followupMatching(e.d, i, regexp.MustCompile(`discord\.gg/(\d+)`)))[1]
+	if err := e.d.JoinInvite(code, e.member.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "invite completion", followupMatching(e.d, i, regexp.MustCompile(`^done$`)))
+	g, _ = e.d.Guild(g.ID)
+	if roles, dj := memberRoles(g, e.member.ID), roleID(g, "DJ"); !slices.Contains(roles, dj) {
+		t.Errorf("invited member has roles %v, want DJ role %q from their autorole", roles, dj)
+	}
+}
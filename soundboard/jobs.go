@@ -0,0 +1,147 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+)
+
+const (
+	jobsCommand      = "jobs"
+	cancelJobCommand = "cancel-job"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+)
+
+// job is a command which runs in the background, rather than holding up its interaction.
+type job struct {
+	id      discordgo.Snowflake
+	command string
+	user    *discordgo.User
+	started time.Time
+	cancel  context.CancelFunc
+
+	mu     sync.Mutex
+	status string
+}
+
+func (j *job) setStatus(status string) {
+	// Work which is also done outside of commands, such as imports, has no job to report to.
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+func (j *job) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return fmt.Sprintf("`%s` %s for %s, started %v ago: %s", j.id, j.command, j.user, time.Since(j.started).Round(time.Second), j.status)
+}
+
+type jobKey struct{}
+
+func jobFromContext(ctx context.Context) *job {
+	j, _ := ctx.Value(jobKey{}).(*job)
+	return j
+}
+
+type cancelJobOptions struct {
+	JobID discordgo.Snowflake `option:"job_id,required" description:"The job to cancel, as shown by /jobs"`
+}
+
+func (b *bot) initJobs() {
+	b.commands[jobsCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Lists your running jobs",
+		},
+		handler: bind(b.jobsCommand),
+	}
+	b.commands[cancelJobCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Cancels one of your running jobs",
+		},
+		handler: bind(b.cancelJobCommand),
+	}
+}
+
+// runInBackground runs the rest of the chain as a job, so that the interaction is freed up straight away.
+// The job is identified by the ID of the interaction which started it.
+func (b *bot) runInBackground(name string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		j := &job{
+			id:      interaction.ID,
+			command: name,
+			user:    user,
+			started: time.Now(),
+			cancel:  cancel,
+			status:  "Working...",
+		}
+		ctx = context.WithValue(ctx, jobKey{}, j)
+		b.jobs.Store(j.id, j)
+		if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("Working... (job `%s`, see /%s)", j.id, jobsCommand)); err != nil {
+			b.jobs.Delete(j.id)
+			cancel()
+			return err
+		}
+		audit := takeOverAudit(ctx)
+		// The job outlives the interaction, so shutdown must wait for it separately.
+		b.inflight.extend()
+		go func() {
+			defer b.inflight.end()
+			defer b.jobs.Delete(j.id)
+			defer cancel()
+			err := next(ctx, interaction, user, options, followup)
+			if err != nil {
+				klog.FromContext(ctx).Error(err, "Job failed")
+			}
+			audit(err)
+		}()
+		return nil
+	}
+}
+
+func (b *bot) jobsCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, _ *noOptions, followup *discordgo.Message) error {
+	var jobs []*job
+	b.jobs.Range(func(_ discordgo.Snowflake, j *job) bool {
+		if j.user.ID == user.ID || b.current().admins.Has(user.Username) {
+			jobs = append(jobs, j)
+		}
+		return true
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].started.Before(jobs[j].started) })
+	content := "There are no running jobs."
+	if len(jobs) > 0 {
+		var lines []string
+		for _, j := range jobs {
+			lines = append(lines, j.String())
+		}
+		content = strings.Join(lines, "\n")
+	}
+	return b.reply(ctx, interaction, user, followup, content)
+}
+
+func (b *bot) cancelJobCommand(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *cancelJobOptions, followup *discordgo.Message) error {
+	j, ok := b.jobs.Load(options.JobID)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownJob, options.JobID)
+	}
+	if j.user.ID != user.ID && !b.current().admins.Has(user.Username) {
+		return fmt.Errorf("%w: %q cannot cancel job %q", ErrPermissionDenied, user, j.id)
+	}
+	klog.FromContext(ctx).Info("Cancelling job", "jobID", j.id, "jobUserID", j.user.ID)
+	j.cancel()
+	return b.reply(ctx, interaction, user, followup, fmt.Sprintf("job `%s` has been cancelled", j.id))
+}
@@ -15,27 +15,22 @@ const (
 
 func (b *bot) initListServers() {
 	b.commands[listServerCommand] = command{
-		&discordgo.ApplicationCommand{
+		command: &discordgo.ApplicationCommand{
 			Description: "Lists all servers that the bot owns",
-		}, b.listServers}
-}
-
-func (b *bot) listServers(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
-	if err := b.validateUser(user, listServerCommand); err != nil {
-		return err
+		},
+		handler: bind(b.listServers),
+		admin:   true,
 	}
+}
 
-	klog.Infof("list guilds request received from %q", user)
+func (b *bot) listServers(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, _ *noOptions, followup *discordgo.Message) error {
+	klog.FromContext(ctx).Info("List guilds requested")
 	var guilds []string
-	func() {
-		b.creator.State.RLock()
-		defer b.creator.State.RUnlock()
-		for _, guild := range b.creator.State.Guilds {
-			if guild.OwnerID == b.creator.State.User.ID {
-				guilds = append(guilds, fmt.Sprintf("%q (%s)", guild.Name, guild.ID))
-			}
+	for _, guild := range b.creator.StateGuilds() {
+		if guild.OwnerID == b.creator.CurrentUser().ID {
+			guilds = append(guilds, fmt.Sprintf("%q (%s)", guild.Name, guild.ID))
 		}
-	}()
+	}
 
 	var content string
 	if len(guilds) == 0 {
@@ -43,11 +38,9 @@ func (b *bot) listServers(ctx context.Context, interaction *discordgo.Interactio
 	} else {
 		content = strings.Join(guilds, "\n")
 	}
-	if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
-		Content: toPtr(content),
-	}); err != nil {
+	if err := b.reply(ctx, interaction, user, followup, content); err != nil {
 		return fmt.Errorf("%w: failed to notify %q of completed list request", err, user)
 	}
-	klog.Infof("sent guild list to %q", user)
+	klog.FromContext(ctx).Info("Sent guild list")
 	return nil
 }
@@ -0,0 +1,197 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-syncmap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+const (
+	metricsNamespace = "soundboard"
+)
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_total",
+		Help:      "Commands called, by command and outcome.",
+	}, []string{"command", "outcome"})
+	commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "command_duration_seconds",
+		Help:      "How long commands took to run, by command and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"command", "outcome"})
+	discordRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "discord_requests_total",
+		Help:      "REST calls made to Discord, by bot, method, route and status code.",
+	}, []string{"bot", "method", "route", "code"})
+	discordRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "discord_rate_limited_total",
+		Help:      "REST calls to Discord which were rate limited (429), by bot, route and scope.",
+	}, []string{"bot", "route", "scope"})
+	pendingInvites = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_invites",
+		Help:      "Invites waiting for their user to join, by the bot which created them.",
+	}, []string{"bot"})
+	pendingTransfers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_transfers",
+		Help:      "Soundboards waiting for their user to authorise the manager, before ownership is transferred.",
+	})
+	soundboards = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "soundboards",
+		Help:      "Soundboard servers known to the bot.",
+	})
+	roleChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "role_changes_total",
+		Help:      "Roles granted to or revoked from members, by change.",
+	}, []string{"change"})
+)
+
+// outcome classifies err into one of a handful of values, so that it can be used as a label.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrPermissionDenied):
+		return "permission_denied"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrCommandDisabled):
+		return "disabled"
+	case errors.Is(err, ErrInvalidOption):
+		return "invalid_option"
+	case errors.Is(err, ErrShuttingDown):
+		return "shutting_down"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return "error"
+	}
+}
+
+// instrument records the count and latency of calls to the command, and traces them.
+func instrument(name string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		start := time.Now()
+		ctx, end := span(ctx, "command "+name, attribute.String("user.id", string(user.ID)))
+		err := next(ctx, interaction, user, options, followup)
+		end(err)
+		o := outcome(err)
+		commandsTotal.WithLabelValues(name, o).Inc()
+		commandDuration.WithLabelValues(name, o).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// route turns the path of a REST call into its route, replacing IDs, tokens and codes with placeholders so
+// that it can be used as a label.
+func route(path string) string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	// Drop the /api/v<version> prefix.
+	if len(segments) >= 2 && segments[0] == "api" && strings.HasPrefix(segments[1], "v") {
+		segments = segments[2:]
+	}
+	for i, s := range segments {
+		if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+			segments[i] = ":id"
+			continue
+		}
+		if i < 2 {
+			continue
+		}
+		switch segments[i-2] {
+		case "webhooks", "interactions":
+			segments[i] = ":token"
+		case "attachments":
+			segments[i] = ":filename"
+		}
+		if segments[i-1] == "templates" {
+			segments[i] = ":code"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// instrumentedTransport records the REST calls made by a bot.
+type instrumentedTransport struct {
+	bot  string
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := route(req.URL.Path)
+	ctx, s := tracer.Start(req.Context(), "discord "+req.Method+" "+r, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("discord.bot", t.bot),
+		semconv.HTTPMethod(req.Method),
+		semconv.HTTPRoute(r),
+	))
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		endSpan(s, err)
+		discordRequests.WithLabelValues(t.bot, req.Method, r, "error").Inc()
+		return nil, err
+	}
+	s.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		s.SetStatus(codes.Error, resp.Status)
+	}
+	s.End()
+	discordRequests.WithLabelValues(t.bot, req.Method, r, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		scope := resp.Header.Get("X-RateLimit-Scope")
+		if scope == "" {
+			scope = "unknown"
+		}
+		discordRateLimited.WithLabelValues(t.bot, r, scope).Inc()
+	}
+	return resp, nil
+}
+
+func countPending(pending *syncmap.Map[discordgo.Snowflake, pendingInvite]) float64 {
+	n := 0
+	pending.Range(func(discordgo.Snowflake, pendingInvite) bool {
+		n++
+		return true
+	})
+	return float64(n)
+}
+
+// observe updates the gauges which are read from the bot's state rather than counted as things happen.
+func (b *bot) observe(ctx context.Context) {
+	pendingInvites.WithLabelValues("creator").Set(countPending(&b.creatorInvites))
+	pendingInvites.WithLabelValues("manager").Set(countPending(&b.managerInvites))
+	pendingTransfers.Set(countPending(&b.transfers))
+	guilds, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		klog.Warningf("%v: failed to count soundboards", err)
+		return
+	}
+	soundboards.Set(float64(len(guilds)))
+}
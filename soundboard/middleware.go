@@ -0,0 +1,213 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+)
+
+const (
+	defaultTimeout = time.Minute * 10
+)
+
+// middleware wraps the handler for the named command.
+type middleware func(name string, c command, next handler) handler
+
+// chain builds the handler for the named command.
+// Middlewares run in order, with the command's own middlewares running closest to its handler.
+// Calls which are rejected before deferResponse are answered straight away, so that they cost a single response.
+func (b *bot) chain(name string, c command) handler {
+	middlewares := []middleware{
+		b.reportRejections,
+		recoverPanics,
+		b.checkEnabled,
+	}
+	if c.background {
+		// Everything after the call is accepted happens in the job, whose outcome is the one audited.
+		middlewares = append(middlewares, b.auditLog, b.authorise, b.rateLimit, b.deferResponse, b.reportErrors, b.runInBackground, b.reportErrors, recoverPanics, instrument)
+	} else {
+		middlewares = append(middlewares, instrument, b.auditLog, b.authorise, b.rateLimit, b.deferResponse, b.reportErrors, recoverPanics)
+	}
+	middlewares = append(middlewares, withTimeout)
+	middlewares = append(middlewares, c.middleware...)
+	h := c.handler.handle
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](name, c, h)
+	}
+	return h
+}
+
+type deferredKey struct{}
+
+// reportRejections answers calls which fail before deferResponse with the error, as an ephemeral message.
+// Errors after deferResponse are left to reportErrors, since the interaction has already been answered.
+func (b *bot) reportRejections(_ string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		deferred := new(bool)
+		err := next(context.WithValue(ctx, deferredKey{}, deferred), interaction, user, options, followup)
+		if err == nil || *deferred {
+			return err
+		}
+		if call := dashboardCallFromContext(ctx); call != nil {
+			call.reply(err.Error())
+			return err
+		}
+		if respondErr := b.respond(ctx, interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: err.Error(),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		}); respondErr != nil {
+			klog.FromContext(ctx).Error(respondErr, "Failed to report rejection to user", "reportedError", err.Error())
+		}
+		return err
+	}
+}
+
+// deferResponse acknowledges the interaction and passes a "Working..." follow-up for the handler to edit.
+func (b *bot) deferResponse(_ string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, _ *discordgo.Message) error {
+		if err := b.respond(ctx, interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+			},
+		}); err != nil {
+			return fmt.Errorf("%w: failed to respond to interaction request", err)
+		}
+		if deferred, ok := ctx.Value(deferredKey{}).(*bool); ok {
+			*deferred = true
+		}
+		followup, err := b.followup(ctx, interaction, "Working...")
+		if err != nil {
+			return fmt.Errorf("%w: failed to send follow-up message", err)
+		}
+		return next(ctx, interaction, user, options, followup)
+	}
+}
+
+// reportErrors replaces the follow-up with any error returned by the handler.
+func (b *bot) reportErrors(_ string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		err := next(ctx, interaction, user, options, followup)
+		if err != nil {
+			if b.shuttingDown() && errors.Is(err, context.Canceled) {
+				// The command was interrupted by shutdown, which the user is still told about.
+				err = fmt.Errorf("%w: %w", ErrShuttingDown, err)
+				ctx = context.WithoutCancel(ctx)
+			}
+			if replyErr := b.reply(ctx, interaction, user, followup, err.Error()); replyErr != nil {
+				klog.FromContext(ctx).Error(replyErr, "Failed to report error to user", "reportedError", err.Error())
+			}
+		}
+		return err
+	}
+}
+
+// recoverPanics turns a panicking handler into an error, so that the user still gets a reply.
+func recoverPanics(name string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				klog.FromContext(ctx).Error(nil, "Command panicked", "panic", r, "stack", string(debug.Stack()))
+				err = fmt.Errorf("%q failed unexpectedly", name)
+			}
+		}()
+		return next(ctx, interaction, user, options, followup)
+	}
+}
+
+type auditKey struct{}
+
+// auditLog records every call to the command, along with its outcome.
+// A job can take over recording the outcome with takeOverAudit, so that calls are audited whether or not they are
+// accepted, and jobs once they finish.
+func (b *bot) auditLog(name string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		start := time.Now()
+		logger := klog.FromContext(ctx)
+		logger.Info("Command called")
+		record := func(err error) {
+			outcome := "ok"
+			if err != nil {
+				outcome = err.Error()
+			}
+			logger.Info("Command finished", "duration", time.Since(start), "outcome", outcome)
+			// The handler's context may have expired, but the audit log should still be written.
+			dbCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*10)
+			defer cancel()
+			if dbErr := b.db.InsertAuditLog(dbCtx, db.AuditEntry{
+				Time:    start,
+				UserID:  user.ID,
+				GuildID: interaction.GuildID,
+				Command: name,
+				Outcome: outcome,
+			}); dbErr != nil {
+				logger.Error(dbErr, "Failed to write audit log")
+			}
+		}
+		takenOver := false
+		ctx = context.WithValue(ctx, auditKey{}, func() func(error) {
+			takenOver = true
+			return record
+		})
+		err := next(ctx, interaction, user, options, followup)
+		if !takenOver {
+			record(err)
+		}
+		return err
+	}
+}
+
+// takeOverAudit stops auditLog from recording the call's outcome when it returns, and returns the function which
+// records it instead. It must be called before the call returns.
+func takeOverAudit(ctx context.Context) func(error) {
+	if takeOver, ok := ctx.Value(auditKey{}).(func() func(error)); ok {
+		return takeOver()
+	}
+	return func(error) {}
+}
+
+// checkEnabled rejects calls to commands which have been disabled, since Discord may take a while to stop offering them.
+func (b *bot) checkEnabled(name string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		if b.current().disabled.Has(name) {
+			return fmt.Errorf("%w: %q", ErrCommandDisabled, name)
+		}
+		return next(ctx, interaction, user, options, followup)
+	}
+}
+
+// authorise rejects calls to admin commands from users who aren't bot admins.
+func (b *bot) authorise(name string, c command, next handler) handler {
+	if !c.admin {
+		return next
+	}
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		if err := b.validateUser(user, name); err != nil {
+			return err
+		}
+		return next(ctx, interaction, user, options, followup)
+	}
+}
+
+// withTimeout limits the command to its timeout, or defaultTimeout if it doesn't have one.
+func withTimeout(_ string, c command, next handler) handler {
+	timeout := c.timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return next(ctx, interaction, user, options, followup)
+	}
+}
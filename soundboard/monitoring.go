@@ -0,0 +1,78 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// readinessTimeout bounds how long /readyz and /metrics spend reading from the DB.
+	readinessTimeout = time.Second * 5
+)
+
+// ready reports everything which stops the bot from serving commands, or nil if it can.
+func (b *bot) ready(ctx context.Context) error {
+	var errs []error
+	if b.shuttingDown() {
+		errs = append(errs, ErrShuttingDown)
+	}
+	if !b.creator.Ready() {
+		errs = append(errs, errors.New("creator is not connected to the gateway"))
+	}
+	if !b.manager.Ready() {
+		errs = append(errs, errors.New("manager is not connected to the gateway"))
+	}
+	if err := b.db.Ping(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("database is unreachable: %w", err))
+	}
+	if s := b.current(); len(s.roles) == 0 {
+		errs = append(errs, fmt.Errorf("template %q is not loaded", s.template))
+	}
+	return errors.Join(errs...)
+}
+
+// healthz reports that the bot is running, whether or not it is ready.
+func (b *bot) healthz(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (b *bot) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := b.ready(ctx); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (b *bot) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		b.observe(ctx)
+		cancel()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveMonitoring serves /healthz, /readyz and /metrics on address until the bot is closed.
+func (b *bot) serveMonitoring(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", b.healthz)
+	mux.HandleFunc("/readyz", b.readyz)
+	mux.Handle("/metrics", b.metrics(promhttp.Handler()))
+	b.monitoring = &http.Server{Addr: address, Handler: mux}
+	go func() {
+		klog.Infof("serving health checks and metrics on %s", address)
+		if err := b.monitoring.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("%v: monitoring server stopped", err)
+		}
+	}()
+}
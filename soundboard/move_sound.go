@@ -0,0 +1,157 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	moveSoundCommand = "move-sound"
+)
+
+var (
+	ErrUnknownSound      = errors.New("unknown sound")
+	ErrAmbiguousSound    = errors.New("ambiguous sound")
+	ErrUnknownSoundboard = errors.New("unknown soundboard")
+)
+
+type moveSoundOptions struct {
+	Sound string `option:"sound,required,autocomplete=sound_ids" description:"The sound to move"`
+	To    string `option:"to,required,autocomplete=soundboards" description:"The soundboard to move it to"`
+}
+
+func (b *bot) initMoveSound() {
+	b.commands[moveSoundCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Moves a sound to another soundboard",
+		},
+		handler: bind(b.moveSound),
+		admin:   true,
+	}
+}
+
+func (b *bot) moveSound(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *moveSoundOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Move sound requested", "sound", options.Sound, "to", options.To)
+	sound, err := b.resolveSound(ctx, options.Sound)
+	if err != nil {
+		return err
+	}
+	to, err := b.resolveSoundboard(ctx, options.To)
+	if err != nil {
+		return err
+	}
+	if sound.GuildID == to {
+		return fmt.Errorf("%w: %q is already in %q", ErrInvalidOption, sound.Name, b.guildNames()[to])
+	}
+	if _, err := b.relocateSound(ctx, sound, to); err != nil {
+		return err
+	}
+	names := b.guildNames()
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("Moved %q from %q to %q", sound.Name, names[sound.GuildID], names[to])); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed move sound request", err, user)
+	}
+	logger.Info("Move sound completed", "soundID", sound.SoundID, "from", sound.GuildID, "to", to)
+	return nil
+}
+
+// resolveSound finds the sound in the inventory with the given ID, or failing that the given name.
+func (b *bot) resolveSound(ctx context.Context, value string) (db.Sound, error) {
+	sounds, err := b.db.ListSounds(ctx)
+	if err != nil {
+		return db.Sound{}, err
+	}
+	var named []db.Sound
+	for _, sound := range sounds {
+		if string(sound.SoundID) == value {
+			return sound, nil
+		}
+		if strings.EqualFold(sound.Name, value) {
+			named = append(named, sound)
+		}
+	}
+	switch len(named) {
+	case 0:
+		return db.Sound{}, fmt.Errorf("%w: %q", ErrUnknownSound, value)
+	case 1:
+		return named[0], nil
+	}
+	return db.Sound{}, fmt.Errorf("%w: %q is in %d soundboards, pick one of the suggestions", ErrAmbiguousSound, value, len(named))
+}
+
+// resolveSoundboard finds the soundboard with the given ID, or failing that the given name.
+func (b *bot) resolveSoundboard(ctx context.Context, value string) (discordgo.Snowflake, error) {
+	soundboards, err := b.db.ListSoundboards(ctx)
+	if err != nil {
+		return "", err
+	}
+	if soundboards.Has(discordgo.Snowflake(value)) {
+		return discordgo.Snowflake(value), nil
+	}
+	names := b.guildNames()
+	for guildID := range soundboards {
+		if strings.EqualFold(names[guildID], value) {
+			return guildID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownSoundboard, value)
+}
+
+// guildNames returns the names of the guilds the manager is in, from the gateway's state rather than REST, so that
+// autocomplete can look up every soundboard's name without spending the manager's rate limit.
+func (b *bot) guildNames() map[discordgo.Snowflake]string {
+	names := map[discordgo.Snowflake]string{}
+	for _, guild := range b.manager.StateGuilds() {
+		names[guild.ID] = guild.Name
+	}
+	return names
+}
+
+// relocateSound moves a sound to another soundboard, by uploading it there again with the same name, emoji and
+// volume and then deleting the original. It returns the sound's new record in the inventory.
+func (b *bot) relocateSound(ctx context.Context, sound db.Sound, to discordgo.Snowflake) (_ db.Sound, err error) {
+	ctx, end := step(ctx, "move sound", attribute.String("sound.id", string(sound.SoundID)), attribute.String("soundboard.id", string(to)))
+	defer func() { end(err) }()
+	data, contentType, err := fetchSound(ctx, "cdn", discord.SoundboardSoundURL(sound.SoundID), sound.Name, "")
+	if err != nil {
+		return db.Sound{}, err
+	}
+	created, err := b.createSound(ctx, to, &discord.SoundboardSoundParams{
+		Name:      sound.Name,
+		Sound:     soundURI(contentType, data),
+		Volume:    toPtr(sound.Volume),
+		EmojiID:   sound.EmojiID,
+		EmojiName: sound.EmojiName,
+	})
+	if err != nil {
+		return db.Sound{}, err
+	}
+	moved := db.Sound{
+		SoundID:   created.ID,
+		GuildID:   created.GuildID,
+		Name:      created.Name,
+		EmojiID:   created.EmojiID,
+		EmojiName: created.EmojiName,
+		Volume:    created.Volume,
+		UserID:    sound.UserID,
+		Added:     sound.Added,
+		Tags:      sound.Tags,
+	}
+	if err := b.db.MoveSound(ctx, sound.SoundID, moved); err != nil {
+		return db.Sound{}, err
+	}
+	if err := b.manager.GuildSoundboardSoundDelete(sound.GuildID, sound.SoundID, discordgo.WithContext(ctx)); err != nil {
+		return db.Sound{}, fmt.Errorf("%w: copied %q to %q, but failed to delete the original", err, sound.Name, to)
+	}
+	klog.FromContext(ctx).Info("Moved sound", "soundID", sound.SoundID, "from", sound.GuildID, "newSoundID", created.ID, "to", to)
+	return moved, nil
+}
@@ -0,0 +1,332 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// handler is the signature commandler calls, with the interaction's options still undecoded.
+type handler func(context.Context, *discordgo.Interaction, *discordgo.User, []*discordgo.ApplicationCommandInteractionDataOption, *discordgo.Message) error
+
+// noOptions is used by commands which take no options.
+type noOptions struct{}
+
+// binding is a handler along with the options it expects, see bind.
+type binding struct {
+	fields []optionField
+	handle handler
+}
+
+type optionField struct {
+	index     int
+	option    discordgo.ApplicationCommandOption
+	optional  bool
+	snowflake bool
+	// maxValue is the option's maximum, if any, since option.MaxValue can't tell a maximum of 0 from none.
+	maxValue *float64
+	choices  string
+	// autocomplete names the source which suggests values for the option as it is typed, see suggestions.
+	autocomplete string
+	subcommands  []optionField
+}
+
+var snowflakeType = reflect.TypeOf(discordgo.Snowflake(""))
+
+// bind wraps a handler which takes its options as a struct of type T.
+// Each field of T with an `option` tag becomes an option of the command:
+//
+//	Name   string              `option:"name,required,maxlen=32" description:"The name"`
+//	Role   discordgo.Snowflake `option:"role,required,type=role" description:"The role"`
+//	Suffix *int64              `option:"suffix,min=0" description:"The suffix"`
+//	All    *struct{}           `option:"all" description:"The subcommand"`
+//
+// Pointer fields are left nil when the option isn't provided, and pointers to structs are subcommands.
+// Snowflake fields must hold a Discord ID, and can be sent as a role, user, channel, mentionable or attachment
+// with `type`. Attachments are looked up by their ID in the interaction's resolved data.
+// String options may take their choices from a named source with `choices`, see optionChoices, or have values
+// suggested as they are typed by a named source with `autocomplete`, see suggestions.
+// bind panics if T is malformed, since this can only be a programming error.
+func bind[T any](h func(context.Context, *discordgo.Interaction, *discordgo.User, *T, *discordgo.Message) error) binding {
+	fields, err := parseOptionFields(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		panic(fmt.Sprintf("failed to bind options for %T: %v", h, err))
+	}
+	return binding{fields: fields, handle: func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		out := new(T)
+		if err := decodeOptions(fields, options, reflect.ValueOf(out).Elem()); err != nil {
+			return err
+		}
+		return h(ctx, interaction, user, out, followup)
+	}}
+}
+
+func parseOptionFields(t reflect.Type) ([]optionField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%v is not a struct", t)
+	}
+	var fields []optionField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("option")
+		if !ok {
+			continue
+		}
+		name, modifiers, _ := strings.Cut(tag, ",")
+		f := optionField{index: i, option: discordgo.ApplicationCommandOption{
+			Name:        name,
+			Description: sf.Tag.Get("description"),
+		}}
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			f.optional = true
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct:
+			if !f.optional {
+				return nil, fmt.Errorf("subcommand %q must be a pointer to a struct", name)
+			}
+			f.option.Type = discordgo.ApplicationCommandOptionSubCommand
+			subcommands, err := parseOptionFields(ft)
+			if err != nil {
+				return nil, fmt.Errorf("subcommand %q: %w", name, err)
+			}
+			for _, s := range subcommands {
+				if s.option.Type == discordgo.ApplicationCommandOptionSubCommand {
+					return nil, fmt.Errorf("subcommand %q cannot have nested subcommands", name)
+				}
+			}
+			f.subcommands = subcommands
+		case reflect.String:
+			f.option.Type = discordgo.ApplicationCommandOptionString
+			f.snowflake = ft == snowflakeType
+		case reflect.Int, reflect.Int64:
+			f.option.Type = discordgo.ApplicationCommandOptionInteger
+		case reflect.Float64:
+			f.option.Type = discordgo.ApplicationCommandOptionNumber
+		case reflect.Bool:
+			f.option.Type = discordgo.ApplicationCommandOptionBoolean
+		default:
+			return nil, fmt.Errorf("option %q has unsupported type %v", name, sf.Type)
+		}
+		if modifiers != "" {
+			for _, m := range strings.Split(modifiers, ",") {
+				if err := f.modify(m); err != nil {
+					return nil, fmt.Errorf("option %q: %w", name, err)
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (f *optionField) modify(modifier string) error {
+	key, value, _ := strings.Cut(modifier, "=")
+	switch key {
+	case "required":
+		f.option.Required = true
+	case "type":
+		if !f.snowflake {
+			return fmt.Errorf("type can only be set on snowflakes")
+		}
+		switch value {
+		case "role":
+			f.option.Type = discordgo.ApplicationCommandOptionRole
+		case "user":
+			f.option.Type = discordgo.ApplicationCommandOptionUser
+		case "channel":
+			f.option.Type = discordgo.ApplicationCommandOptionChannel
+		case "mentionable":
+			f.option.Type = discordgo.ApplicationCommandOptionMentionable
+		case "attachment":
+			f.option.Type = discordgo.ApplicationCommandOptionAttachment
+		default:
+			return fmt.Errorf("unknown type %q", value)
+		}
+	case "choices":
+		if f.option.Type != discordgo.ApplicationCommandOptionString {
+			return fmt.Errorf("choices can only be set on strings")
+		}
+		f.choices = value
+	case "autocomplete":
+		if f.option.Type != discordgo.ApplicationCommandOptionString {
+			return fmt.Errorf("autocomplete can only be set on strings")
+		}
+		if !autocompleteSources.Has(value) {
+			return fmt.Errorf("unknown autocomplete source %q", value)
+		}
+		f.option.Autocomplete = true
+		f.autocomplete = value
+	case "min", "max":
+		if f.option.Type != discordgo.ApplicationCommandOptionInteger && f.option.Type != discordgo.ApplicationCommandOptionNumber {
+			return fmt.Errorf("%s can only be set on numbers", key)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s", err, key)
+		}
+		if key == "min" {
+			f.option.MinValue = &v
+		} else {
+			f.option.MaxValue, f.maxValue = v, &v
+		}
+	case "minlen", "maxlen":
+		if f.option.Type != discordgo.ApplicationCommandOptionString {
+			return fmt.Errorf("%s can only be set on strings", key)
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s", err, key)
+		}
+		if key == "minlen" {
+			f.option.MinLength = &v
+		} else {
+			f.option.MaxLength = v
+		}
+	default:
+		return fmt.Errorf("unknown modifier %q", key)
+	}
+	return nil
+}
+
+// optionChoices returns the choices available from the named source.
+func (b *bot) optionChoices(source string) []string {
+	switch source {
+	case "template_roles":
+		// Sorted so that the registered choices only change when the template does.
+		roles := b.current().roles.Elements()
+		sort.Strings(roles)
+		return roles
+	case "rebalance_policies":
+		var policies []string
+		for policy := range rebalancePolicies {
+			policies = append(policies, policy)
+		}
+		sort.Strings(policies)
+		return policies
+	}
+	panic(fmt.Sprintf("unknown choices source %q", source))
+}
+
+// optionSchema builds the options to register for fields.
+func (b *bot) optionSchema(fields []optionField) []*discordgo.ApplicationCommandOption {
+	var out []*discordgo.ApplicationCommandOption
+	for _, f := range fields {
+		option := f.option
+		option.Options = b.optionSchema(f.subcommands)
+		if f.choices != "" {
+			for _, c := range b.optionChoices(f.choices) {
+				option.Choices = append(option.Choices, &discordgo.ApplicationCommandOptionChoice{Name: c, Value: c})
+			}
+		}
+		out = append(out, &option)
+	}
+	// Discord requires required options to be listed first.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Required && !out[j].Required })
+	return out
+}
+
+func decodeOptions(fields []optionField, options []*discordgo.ApplicationCommandInteractionDataOption, out reflect.Value) error {
+	byName := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, o := range options {
+		byName[o.Name] = o
+	}
+	var hasSubcommands, calledSubcommand bool
+	for _, f := range fields {
+		o, ok := byName[f.option.Name]
+		if f.option.Type == discordgo.ApplicationCommandOptionSubCommand {
+			hasSubcommands = true
+			if !ok {
+				continue
+			}
+			calledSubcommand = true
+			sub := reflect.New(out.Field(f.index).Type().Elem())
+			if err := decodeOptions(f.subcommands, o.Options, sub.Elem()); err != nil {
+				return err
+			}
+			out.Field(f.index).Set(sub)
+			continue
+		}
+		if !ok {
+			if f.option.Required {
+				return fmt.Errorf("%w: %q is required", ErrInvalidOption, f.option.Name)
+			}
+			continue
+		}
+		v, err := f.decode(o.Value)
+		if err != nil {
+			return err
+		}
+		field := out.Field(f.index)
+		if f.optional {
+			ptr := reflect.New(field.Type().Elem())
+			ptr.Elem().Set(v.Convert(field.Type().Elem()))
+			field.Set(ptr)
+		} else {
+			field.Set(v.Convert(field.Type()))
+		}
+	}
+	if hasSubcommands && !calledSubcommand {
+		return fmt.Errorf("%w: a subcommand is required", ErrInvalidOption)
+	}
+	return nil
+}
+
+func (f *optionField) decode(value any) (reflect.Value, error) {
+	name := f.option.Name
+	switch f.option.Type {
+	case discordgo.ApplicationCommandOptionInteger, discordgo.ApplicationCommandOptionNumber:
+		v, ok := value.(float64)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("%w: %q must be a number, got %v", ErrInvalidOption, name, value)
+		}
+		if f.option.Type == discordgo.ApplicationCommandOptionInteger && v != math.Trunc(v) {
+			return reflect.Value{}, fmt.Errorf("%w: %q must be a whole number, got %v", ErrInvalidOption, name, v)
+		}
+		if f.option.MinValue != nil && v < *f.option.MinValue {
+			return reflect.Value{}, fmt.Errorf("%w: %q must be at least %v, got %v", ErrInvalidOption, name, *f.option.MinValue, v)
+		}
+		if f.maxValue != nil && v > *f.maxValue {
+			return reflect.Value{}, fmt.Errorf("%w: %q must be at most %v, got %v", ErrInvalidOption, name, *f.maxValue, v)
+		}
+		if f.option.Type == discordgo.ApplicationCommandOptionInteger {
+			return reflect.ValueOf(int64(v)), nil
+		}
+		return reflect.ValueOf(v), nil
+	case discordgo.ApplicationCommandOptionBoolean:
+		v, ok := value.(bool)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("%w: %q must be true or false, got %v", ErrInvalidOption, name, value)
+		}
+		return reflect.ValueOf(v), nil
+	}
+	v, ok := value.(string)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: %q must be text, got %v", ErrInvalidOption, name, value)
+	}
+	if f.snowflake {
+		if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+			return reflect.Value{}, fmt.Errorf("%w: %q must be a Discord ID, got %q", ErrInvalidOption, name, v)
+		}
+	}
+	if f.option.MinLength != nil && len([]rune(v)) < *f.option.MinLength {
+		return reflect.Value{}, fmt.Errorf("%w: %q must be at least %d characters long", ErrInvalidOption, name, *f.option.MinLength)
+	}
+	if f.option.MaxLength != 0 && len([]rune(v)) > f.option.MaxLength {
+		return reflect.Value{}, fmt.Errorf("%w: %q must be at most %d characters long", ErrInvalidOption, name, f.option.MaxLength)
+	}
+	return reflect.ValueOf(v), nil
+}
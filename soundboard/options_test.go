@@ -0,0 +1,35 @@
+package soundboard
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestOptionMaxValue(t *testing.T) {
+	type options struct {
+		Zero     *int64   `option:"zero,max=0"`
+		Negative *float64 `option:"negative,max=-1.5"`
+		Unset    *int64   `option:"unset"`
+	}
+	fields, err := parseOptionFields(reflect.TypeOf(options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		field int
+		value float64
+		ok    bool
+	}{
+		{0, 0, true},
+		{0, 1, false},
+		{1, -2, true},
+		{1, -1, false},
+		{2, 1e9, true},
+	} {
+		f := fields[tc.field]
+		if _, err := f.decode(tc.value); (err == nil) != tc.ok || (err != nil && !errors.Is(err, ErrInvalidOption)) {
+			t.Errorf("decode(%v) of %q = %v, want ok %v", tc.value, f.option.Name, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+)
+
+type RateLimitScope string
+
+const (
+	// RateLimitUser limits each user separately.
+	RateLimitUser RateLimitScope = "user"
+	// RateLimitCommand limits all users together.
+	RateLimitCommand RateLimitScope = "command"
+	// RateLimitGuild limits each guild separately.
+	RateLimitGuild RateLimitScope = "guild"
+
+	maxBuckets = 10000
+)
+
+// RateLimit allows Burst calls at once, and one more call every Every after that.
+type RateLimit struct {
+	Scope RateLimitScope
+	// Command limits calls to this command only. If empty, calls to all commands share the limit.
+	Command string
+	Burst   int
+	Every   time.Duration
+}
+
+// ParseRateLimits parses a comma-separated list of limits in the form "scope:command:burst/every",
+// such as "user:invite-me:2/5m". A command of "*" applies the limit to all commands.
+func ParseRateLimits(limits string) ([]RateLimit, error) {
+	var out []RateLimit
+	for _, limit := range strings.Split(limits, ",") {
+		if limit = strings.TrimSpace(limit); limit == "" {
+			continue
+		}
+		parts := strings.Split(limit, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("rate limit %q is not in the form scope:command:burst/every", limit)
+		}
+		rl := RateLimit{Scope: RateLimitScope(parts[0]), Command: parts[1]}
+		switch rl.Scope {
+		case RateLimitUser, RateLimitCommand, RateLimitGuild:
+		default:
+			return nil, fmt.Errorf("rate limit %q has unknown scope %q", limit, rl.Scope)
+		}
+		if rl.Command == "*" {
+			rl.Command = ""
+		}
+		burst, every, ok := strings.Cut(parts[2], "/")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q is not in the form scope:command:burst/every", limit)
+		}
+		var err error
+		if rl.Burst, err = strconv.Atoi(burst); err != nil || rl.Burst < 1 {
+			return nil, fmt.Errorf("rate limit %q has invalid burst %q", limit, burst)
+		}
+		if rl.Every, err = time.ParseDuration(every); err != nil || rl.Every <= 0 {
+			return nil, fmt.Errorf("rate limit %q has invalid duration %q", limit, every)
+		}
+		out = append(out, rl)
+	}
+	return out, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill tops up the bucket with the tokens earned since it was last used.
+func (b *bucket) refill(limit RateLimit, now time.Time) {
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+float64(now.Sub(b.last))/float64(limit.Every))
+	b.last = now
+}
+
+// bucketKey identifies a bucket by its limit, rather than the limit's position, so that buckets survive reloads.
+type bucketKey struct {
+	limit RateLimit
+	scope discordgo.Snowflake
+	// command is empty for limits without a command, which are shared by every command.
+	command string
+}
+
+// rateLimiter is a set of token buckets, one per limit and scope.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  []RateLimit
+	buckets map[bucketKey]*bucket
+}
+
+// configure replaces the limiter's limits. Calls are only forgotten for limits which are no longer configured.
+func (l *rateLimiter) configure(limits []RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	if l.buckets == nil {
+		l.buckets = map[bucketKey]*bucket{}
+	}
+	configured := map[RateLimit]bool{}
+	for _, limit := range limits {
+		configured[limit] = true
+	}
+	for key := range l.buckets {
+		if !configured[key.limit] {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// take uses up a call to command by user in guild.
+// If any limit is exhausted, nothing is used up and the time until the call would be allowed is returned instead.
+func (l *rateLimiter) take(command string, user, guild discordgo.Snowflake) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	var buckets []*bucket
+	for _, limit := range l.limits {
+		if limit.Command != "" && limit.Command != command {
+			continue
+		}
+		var scope discordgo.Snowflake
+		switch limit.Scope {
+		case RateLimitUser:
+			scope = user
+		case RateLimitGuild:
+			if guild == "" {
+				continue
+			}
+			scope = guild
+		}
+		key := bucketKey{limit: limit, scope: scope, command: command}
+		if limit.Command == "" && limit.Scope != RateLimitCommand {
+			// Limits without a command are shared by every command.
+			key.command = ""
+		}
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(limit.Burst), last: now}
+			l.buckets[key] = b
+		}
+		b.refill(limit, now)
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)*float64(limit.Every)))
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	if len(l.buckets) > maxBuckets {
+		l.prune(now)
+	}
+	return 0
+}
+
+// prune forgets buckets which have refilled completely, since they're the same as new buckets.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(key.limit, now)
+		if b.tokens >= float64(key.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit rejects calls which exceed the bot's rate limits.
+func (b *bot) rateLimit(name string, _ command, next handler) handler {
+	return func(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption, followup *discordgo.Message) error {
+		if wait := b.limiter.take(name, user.ID, interaction.GuildID); wait > 0 {
+			return fmt.Errorf("%w: try again in %d seconds", ErrRateLimited, int(math.Ceil(wait.Seconds())))
+		}
+		return next(ctx, interaction, user, options, followup)
+	}
+}
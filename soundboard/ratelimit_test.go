@@ -0,0 +1,27 @@
+package soundboard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterConfigureKeepsUnchangedLimits(t *testing.T) {
+	kept := RateLimit{Scope: RateLimitUser, Command: fixRolesCommand, Burst: 1, Every: time.Hour}
+	changed := RateLimit{Scope: RateLimitUser, Command: inviteCommand, Burst: 1, Every: time.Hour}
+	var l rateLimiter
+	l.configure([]RateLimit{changed, kept})
+	for _, command := range []string{fixRolesCommand, inviteCommand} {
+		if wait := l.take(command, "user", "guild"); wait != 0 {
+			t.Fatalf("first call to %q waits %v, want 0", command, wait)
+		}
+	}
+	// Reordering the limits and changing one of them only forgets the calls counted by the changed limit.
+	changed.Burst = 2
+	l.configure([]RateLimit{kept, changed})
+	if wait := l.take(fixRolesCommand, "user", "guild"); wait == 0 {
+		t.Errorf("call to %q after reload isn't limited, want the unchanged limit to remember the earlier call", fixRolesCommand)
+	}
+	if wait := l.take(inviteCommand, "user", "guild"); wait != 0 {
+		t.Errorf("call to %q after reload waits %v, want the changed limit to start afresh", inviteCommand, wait)
+	}
+}
@@ -0,0 +1,305 @@
+package soundboard
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	rebalanceCommand = "rebalance"
+	// maxPlanLength leaves room in a message for the plan's heading and footer.
+	maxPlanLength = 1800
+
+	alphabeticalPolicy = "alphabetical"
+	categoryPolicy     = "category"
+	fillFirstPolicy    = "fill-first"
+)
+
+var (
+	// rebalancePolicies describes how each policy arranges the soundboards.
+	rebalancePolicies = map[string]string{
+		alphabeticalPolicy: "alphabetically",
+		categoryPolicy:     "by category",
+		fillFirstPolicy:    "by filling the oldest soundboards first",
+	}
+)
+
+type rebalanceOptions struct {
+	Policy string `option:"policy,required,choices=rebalance_policies" description:"How to arrange the sounds, categories are a sound's first tag"`
+	Apply  *bool  `option:"apply" description:"Move the sounds, rather than only showing which would move"`
+}
+
+func (b *bot) initRebalance() {
+	b.commands[rebalanceCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Shows how sounds would be redistributed between soundboards, and moves them if applied",
+		},
+		handler:    bind(b.rebalance),
+		admin:      true,
+		background: true,
+		timeout:    time.Hour,
+	}
+}
+
+// soundMove moves a sound to another soundboard.
+type soundMove struct {
+	sound db.Sound
+	to    discordgo.Snowflake
+}
+
+// rebalancePlan is the moves which arrange the soundboards by a policy.
+type rebalancePlan struct {
+	moves []soundMove
+	// soundboards are in the order they were created, and free is how many free slots each has before any moves.
+	soundboards []discordgo.Snowflake
+	free        map[discordgo.Snowflake]int
+}
+
+func (b *bot) rebalance(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *rebalanceOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx).WithValues("policy", options.Policy)
+	logger.Info("Rebalance requested")
+	plan, err := b.planRebalance(ctx, options.Policy)
+	if err != nil {
+		return err
+	}
+	if len(plan.moves) == 0 {
+		if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("The soundboards are already arranged %s.", rebalancePolicies[options.Policy])); err != nil {
+			return fmt.Errorf("%w: failed to notify %q of completed rebalance request", err, user)
+		}
+		logger.Info("Rebalance completed", "moves", 0)
+		return nil
+	}
+	description := b.describePlan(plan, options.Policy)
+	if options.Apply == nil || !*options.Apply {
+		if err := b.reply(ctx, interaction, user, followup, description+"\nNothing has been moved yet, call /rebalance again with apply to move them."); err != nil {
+			return fmt.Errorf("%w: failed to notify %q of rebalance plan", err, user)
+		}
+		logger.Info("Rebalance planned", "moves", len(plan.moves))
+		return nil
+	}
+	if err := b.reply(ctx, interaction, user, followup, description+"\nMoving..."); err != nil {
+		return err
+	}
+	moved, err := b.applyPlan(ctx, plan)
+	if err != nil {
+		return fmt.Errorf("%w: after moving %d of %d sounds", err, moved, len(plan.moves))
+	}
+	if err := b.reply(ctx, interaction, user, followup, fmt.Sprintf("Moved %d sounds to arrange the soundboards %s.", moved, rebalancePolicies[options.Policy])); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed rebalance request", err, user)
+	}
+	logger.Info("Rebalance completed", "moves", moved)
+	return nil
+}
+
+// planRebalance works out which sounds in the inventory must move to arrange the soundboards by policy.
+func (b *bot) planRebalance(ctx context.Context, policy string) (_ rebalancePlan, err error) {
+	ctx, end := step(ctx, "plan rebalance")
+	defer func() { end(err) }()
+	counts, err := b.db.CountSounds(ctx)
+	if err != nil {
+		return rebalancePlan{}, err
+	}
+	sounds, err := b.db.ListSounds(ctx)
+	if err != nil {
+		return rebalancePlan{}, err
+	}
+	plan := rebalancePlan{soundboards: byAge(counts), free: map[discordgo.Snowflake]int{}}
+	capacity := map[discordgo.Snowflake]int{}
+	var total int
+	for _, guildID := range plan.soundboards {
+		guild, err := b.manager.Guild(guildID, discordgo.WithContext(ctx))
+		if err != nil {
+			return rebalancePlan{}, err
+		}
+		capacity[guildID] = discord.MaxSoundboardSounds(guild.PremiumTier)
+		plan.free[guildID] = capacity[guildID] - counts[guildID]
+		total += capacity[guildID]
+	}
+	if len(sounds) > total {
+		return rebalancePlan{}, fmt.Errorf("%w: %d sounds don't fit in %d slots", ErrNoFreeSlots, len(sounds), total)
+	}
+	var targets map[discordgo.Snowflake]discordgo.Snowflake
+	switch policy {
+	case alphabeticalPolicy:
+		sort.SliceStable(sounds, func(i, j int) bool { return strings.ToLower(sounds[i].Name) < strings.ToLower(sounds[j].Name) })
+		targets = arrange(sounds, nil, plan.soundboards, capacity)
+	case categoryPolicy:
+		// Uncategorised sounds go last.
+		sort.SliceStable(sounds, func(i, j int) bool {
+			x, y := category(sounds[i]), category(sounds[j])
+			if x != y {
+				return y == "" || (x != "" && x < y)
+			}
+			return strings.ToLower(sounds[i].Name) < strings.ToLower(sounds[j].Name)
+		})
+		targets = arrange(sounds, category, plan.soundboards, capacity)
+	case fillFirstPolicy:
+		targets = fillFirst(sounds, plan.soundboards, capacity)
+	default:
+		return rebalancePlan{}, fmt.Errorf("%w: unknown policy %q", ErrInvalidOption, policy)
+	}
+	for _, sound := range sounds {
+		if to := targets[sound.SoundID]; to != sound.GuildID {
+			plan.moves = append(plan.moves, soundMove{sound: sound, to: to})
+		}
+	}
+	klog.FromContext(ctx).V(1).Info("Planned rebalance", "sounds", len(sounds), "slots", total, "moves", len(plan.moves))
+	return plan, nil
+}
+
+// category is a sound's first tag, if it has any.
+func category(sound db.Sound) string {
+	if len(sound.Tags) == 0 {
+		return ""
+	}
+	return sound.Tags[0]
+}
+
+// arrange assigns sounds, in order, to soundboards, in order, filling each before moving on to the next.
+// If groupOf is given, a group which would be split starts in the next soundboard instead, when that keeps it
+// together and leaves room for everything after it.
+func arrange(sounds []db.Sound, groupOf func(db.Sound) string, soundboards []discordgo.Snowflake, capacity map[discordgo.Snowflake]int) map[discordgo.Snowflake]discordgo.Snowflake {
+	// remaining[i] is the capacity of soundboards[i:].
+	remaining := make([]int, len(soundboards)+1)
+	for i := len(soundboards) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + capacity[soundboards[i]]
+	}
+	targets := map[discordgo.Snowflake]discordgo.Snowflake{}
+	var current, used int
+	for i, sound := range sounds {
+		if groupOf != nil && used > 0 && groupOf(sound) != groupOf(sounds[i-1]) && current+1 < len(soundboards) {
+			size := 1
+			for size < len(sounds)-i && groupOf(sounds[i+size]) == groupOf(sound) {
+				size++
+			}
+			if used+size > capacity[soundboards[current]] && size <= capacity[soundboards[current+1]] && len(sounds)-i <= remaining[current+1] {
+				current, used = current+1, 0
+			}
+		}
+		for used >= capacity[soundboards[current]] {
+			current, used = current+1, 0
+		}
+		targets[sound.SoundID] = soundboards[current]
+		used++
+	}
+	return targets
+}
+
+// fillFirst keeps sounds where they are, except to fill the free slots of older soundboards with sounds from the
+// newest.
+func fillFirst(sounds []db.Sound, soundboards []discordgo.Snowflake, capacity map[discordgo.Snowflake]int) map[discordgo.Snowflake]discordgo.Snowflake {
+	targets := map[discordgo.Snowflake]discordgo.Snowflake{}
+	in := map[discordgo.Snowflake][]db.Sound{}
+	for _, sound := range sounds {
+		targets[sound.SoundID] = sound.GuildID
+		in[sound.GuildID] = append(in[sound.GuildID], sound)
+	}
+	last := len(soundboards) - 1
+	for i, guildID := range soundboards {
+		for len(in[guildID]) < capacity[guildID] {
+			for last > i && len(in[soundboards[last]]) == 0 {
+				last--
+			}
+			if last <= i {
+				return targets
+			}
+			from := in[soundboards[last]]
+			sound := from[len(from)-1]
+			in[soundboards[last]] = from[:len(from)-1]
+			in[guildID] = append(in[guildID], sound)
+			targets[sound.SoundID] = guildID
+		}
+	}
+	return targets
+}
+
+// describePlan lists the plan's moves, as many as fit in a message.
+func (b *bot) describePlan(plan rebalancePlan, policy string) string {
+	names := b.guildNames()
+	var lines []string
+	length := 0
+	for i, m := range plan.moves {
+		line := fmt.Sprintf("%q: %q → %q", m.sound.Name, names[m.sound.GuildID], names[m.to])
+		if length+len(line) > maxPlanLength {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(plan.moves)-i))
+			break
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+	}
+	return fmt.Sprintf("Arranging the soundboards %s moves %d sounds:\n%s", rebalancePolicies[policy], len(plan.moves), strings.Join(lines, "\n"))
+}
+
+// applyPlan makes the plan's moves, each once its soundboard has a free slot, returning how many were made.
+// When every soundboard which sounds are moving to is full, a sound moving out of one of them is parked in any
+// soundboard with a free slot to make room, and moved on from there later.
+func (b *bot) applyPlan(ctx context.Context, plan rebalancePlan) (int, error) {
+	free := maps.Clone(plan.free)
+	pending := slices.Clone(plan.moves)
+	var moved int
+	for len(pending) > 0 {
+		var waiting []soundMove
+		for _, m := range pending {
+			if free[m.to] <= 0 {
+				waiting = append(waiting, m)
+				continue
+			}
+			if _, err := b.relocateSound(ctx, m.sound, m.to); err != nil {
+				return moved, err
+			}
+			free[m.to]--
+			free[m.sound.GuildID]++
+			moved++
+			if j := jobFromContext(ctx); j != nil {
+				j.setStatus(fmt.Sprintf("Moved %d of %d sounds", moved, len(plan.moves)))
+			}
+		}
+		if len(waiting) == len(pending) {
+			i, spare, ok := parking(waiting, plan.soundboards, free)
+			if !ok {
+				return moved, fmt.Errorf("%w: %d sounds can't be moved until a soundboard has a free slot", ErrNoFreeSlots, len(waiting))
+			}
+			klog.FromContext(ctx).Info("Parking sound to make room", "soundID", waiting[i].sound.SoundID, "soundboardID", spare)
+			parked, err := b.relocateSound(ctx, waiting[i].sound, spare)
+			if err != nil {
+				return moved, err
+			}
+			free[spare]--
+			free[waiting[i].sound.GuildID]++
+			waiting[i].sound = parked
+		}
+		pending = waiting
+	}
+	return moved, nil
+}
+
+// parking picks which of the waiting moves to park, and where, when none of them can be made: one which makes room
+// in a soundboard which another is waiting on, parked in the oldest other soundboard with a free slot.
+func parking(waiting []soundMove, soundboards []discordgo.Snowflake, free map[discordgo.Snowflake]int) (int, discordgo.Snowflake, bool) {
+	awaited := map[discordgo.Snowflake]bool{}
+	for _, m := range waiting {
+		awaited[m.to] = true
+	}
+	for i, m := range waiting {
+		if !awaited[m.sound.GuildID] {
+			continue
+		}
+		for _, guildID := range soundboards {
+			if free[guildID] > 0 && guildID != m.sound.GuildID {
+				return i, guildID, true
+			}
+		}
+	}
+	return 0, "", false
+}
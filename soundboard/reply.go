@@ -0,0 +1,77 @@
+package soundboard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Interaction tokens are valid for 15 minutes, leave some slack for slow requests.
+	interactionTokenLifetime = time.Minute * 14
+)
+
+// followup creates the ephemeral follow-up message which reply later edits.
+func (b *bot) followup(ctx context.Context, interaction *discordgo.Interaction, content string) (*discordgo.Message, error) {
+	if call := dashboardCallFromContext(ctx); call != nil {
+		call.reply(content)
+		return &discordgo.Message{}, nil
+	}
+	return b.manager.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
+		Content: content,
+	}, discordgo.WithContext(ctx))
+}
+
+// reply shows content to the user who triggered the interaction.
+// The follow-up is edited while the interaction's token is still valid, after which content is sent by DM instead.
+// If ctx belongs to a job, content also becomes the job's status.
+// Calls from the dashboard are replied to on the dashboard instead.
+func (b *bot) reply(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, followup *discordgo.Message, content string) error {
+	return b.replyWithFiles(ctx, interaction, user, followup, content)
+}
+
+// replyWithFiles is reply with files attached. The dashboard can't show files, so they are left out of its replies.
+func (b *bot) replyWithFiles(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, followup *discordgo.Message, content string, files ...*discordgo.File) error {
+	if j := jobFromContext(ctx); j != nil {
+		j.setStatus(content)
+	}
+	if call := dashboardCallFromContext(ctx); call != nil {
+		call.reply(content)
+		return nil
+	}
+	created, err := discordgo.SnowflakeTimestamp(interaction.ID)
+	if err != nil {
+		return fmt.Errorf("%w: failed to parse interaction %q", err, interaction.ID)
+	}
+	if time.Since(created) < interactionTokenLifetime {
+		if _, err := b.manager.FollowupMessageEdit(interaction, followup.ID, &discordgo.WebhookEdit{
+			Content: toPtr(content),
+			Files:   files,
+		}, discordgo.WithContext(ctx)); err != nil {
+			return fmt.Errorf("%w: failed to update follow-up message for %q", err, user)
+		}
+		return nil
+	}
+	klog.FromContext(ctx).Info("Interaction has expired, messaging user directly")
+	return b.sendDM(ctx, user, content, files...)
+}
+
+// sendDM sends content to user as the manager, with any files attached.
+func (b *bot) sendDM(ctx context.Context, user *discordgo.User, content string, files ...*discordgo.File) error {
+	dm, err := b.manager.UserChannelCreate(user.ID, discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to open dm with %q: %w", user.ID, err)
+	}
+	if len(files) > 0 {
+		_, err = b.manager.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{Content: content, Files: files}, discordgo.WithContext(ctx))
+	} else {
+		_, err = b.manager.ChannelMessageSend(dm.ID, content, discordgo.WithContext(ctx))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send dm to %q: %w", user.ID, err)
+	}
+	return nil
+}
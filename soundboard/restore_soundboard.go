@@ -0,0 +1,256 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord"
+)
+
+const (
+	restoreSoundboardCommand = "restore-soundboard"
+)
+
+var (
+	ErrUnknownBackup = errors.New("unknown backup")
+)
+
+type restoreSoundboardOptions struct {
+	From string  `option:"from,required,autocomplete=backups" description:"The backed up soundboard to restore"`
+	To   *string `option:"to,autocomplete=soundboards" description:"The soundboard to restore it into, or a new one if left out"`
+}
+
+// backedUpSoundboard is a soundboard as of the latest backup it is in.
+type backedUpSoundboard struct {
+	backupSoundboard
+	backedUp time.Time
+}
+
+func (b *bot) initRestoreSoundboard() {
+	b.commands[restoreSoundboardCommand] = command{
+		command: &discordgo.ApplicationCommand{
+			Description: "Restores a soundboard's sounds from its latest backup",
+		},
+		handler:    bind(b.restoreSoundboard),
+		admin:      true,
+		background: true,
+		timeout:    time.Hour,
+	}
+}
+
+func (b *bot) restoreSoundboard(ctx context.Context, interaction *discordgo.Interaction, user *discordgo.User, options *restoreSoundboardOptions, followup *discordgo.Message) error {
+	logger := klog.FromContext(ctx)
+	logger.Info("Restore soundboard requested", "from", options.From)
+	from, err := b.resolveBackup(options.From)
+	if err != nil {
+		return err
+	}
+	var to discordgo.Snowflake
+	if options.To != nil {
+		if to, err = b.resolveSoundboard(ctx, *options.To); err != nil {
+			return err
+		}
+	} else {
+		soundboards, err := b.db.ListSoundboards(ctx)
+		if err != nil {
+			return err
+		}
+		guild, err := b.createGuild(ctx, strconv.Itoa(len(soundboards)+1))
+		if err != nil {
+			return fmt.Errorf("failed to create guild: %w", err)
+		}
+		if err := b.handOver(ctx, guild, user, func(ctx context.Context, invite *discordgo.Invite) error {
+			return b.reply(ctx, interaction, user, followup, fmt.Sprintf("Join https://discord.gg/%s to restore %q into it.", invite.Code, from.Name))
+		}); err != nil {
+			return err
+		}
+		to = guild.ID
+	}
+	restored, skipped, unfit, err := b.restoreSounds(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	name := b.guildNames()[to]
+	content := fmt.Sprintf("Restored %d sounds from %q, as backed up <t:%d:R>, into %q.", restored, from.Name, from.backedUp.Unix(), name)
+	if skipped > 0 {
+		content += fmt.Sprintf(" %d sounds were already there.", skipped)
+	}
+	if unfit > 0 {
+		content += fmt.Sprintf(" %d sounds didn't fit, as %q is full. Boosting it makes room for more, after which /%s restores the rest.", unfit, name, restoreSoundboardCommand)
+	}
+	if err := b.reply(ctx, interaction, user, followup, content); err != nil {
+		return fmt.Errorf("%w: failed to notify %q of completed restore soundboard request", err, user)
+	}
+	logger.Info("Restore soundboard completed", "from", from.ID, "to", to, "restored", restored, "skipped", skipped, "unfit", unfit)
+	return nil
+}
+
+// backedUpSoundboards returns every soundboard in the backup index, so that soundboards which have been deleted since
+// can still be restored.
+func (b *bot) backedUpSoundboards() (map[discordgo.Snowflake]backupIndexEntry, error) {
+	if b.backupDir == "" {
+		return nil, ErrBackupsDisabled
+	}
+	index, err := b.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.Soundboards, nil
+}
+
+// resolveBackup finds the backed up soundboard with the given ID, or failing that the given name, as of the latest
+// backup it is in.
+func (b *bot) resolveBackup(value string) (backedUpSoundboard, error) {
+	soundboards, err := b.backedUpSoundboards()
+	if err != nil {
+		return backedUpSoundboard{}, err
+	}
+	id := discordgo.Snowflake(value)
+	entry, ok := soundboards[id]
+	if !ok {
+		for soundboardID, e := range soundboards {
+			if strings.EqualFold(e.Name, value) {
+				id, entry, ok = soundboardID, e, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return backedUpSoundboard{}, fmt.Errorf("%w: %q", ErrUnknownBackup, value)
+	}
+	manifest, err := b.readManifest(entry.Manifest)
+	if err != nil {
+		return backedUpSoundboard{}, err
+	}
+	for _, soundboard := range manifest.Soundboards {
+		if soundboard.ID == id {
+			return backedUpSoundboard{backupSoundboard: soundboard, backedUp: manifest.Created}, nil
+		}
+	}
+	return backedUpSoundboard{}, fmt.Errorf("%w: %q isn't in backup %q", ErrUnknownBackup, value, entry.Manifest)
+}
+
+// restoreSounds uploads the sounds of a backed up soundboard to the soundboard to, other than those it already has a
+// sound of the same name for. Only as many sounds as to has free slots for at its boost tier are uploaded. It returns
+// how many sounds were restored, skipped and left out for lack of room.
+func (b *bot) restoreSounds(ctx context.Context, from backedUpSoundboard, to discordgo.Snowflake) (restored, skipped, unfit int, err error) {
+	ctx, end := step(ctx, "restore sounds", attribute.String("soundboard.id", string(to)))
+	defer func() { end(err) }()
+	logger := klog.FromContext(ctx)
+	existing, err := b.manager.GuildSoundboardSounds(to, discordgo.WithContext(ctx))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: failed to list sounds in %q", err, to)
+	}
+	guild, err := b.manager.Guild(to, discordgo.WithContext(ctx))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	names := map[string]bool{}
+	for _, sound := range existing {
+		names[sound.Name] = true
+	}
+	var pending []backupSound
+	for _, sound := range from.Sounds {
+		if names[sound.Name] {
+			logger.V(1).Info("Sound already restored", "soundID", sound.ID, "name", sound.Name)
+			skipped++
+			continue
+		}
+		names[sound.Name] = true
+		pending = append(pending, sound)
+	}
+	if free := max(discord.MaxSoundboardSounds(guild.PremiumTier)-len(existing), 0); len(pending) > free {
+		logger.Info("Soundboard doesn't have room for every sound", "soundboardID", to, "sounds", len(pending), "free", free)
+		pending, unfit = pending[:free], len(pending)-free
+	}
+	for i, sound := range pending {
+		jobFromContext(ctx).setStatus(fmt.Sprintf("Restoring %q (%d of %d)", sound.Name, i+1, len(pending)))
+		data, err := b.readObject(sound.SHA256)
+		if err != nil {
+			return restored, skipped, unfit, err
+		}
+		if _, err := checkSound(data, sound.Name, sound.ContentType); err != nil {
+			return restored, skipped, unfit, fmt.Errorf("%w: restored %d of %d sounds", err, restored, len(pending))
+		}
+		created, err := b.createSound(ctx, to, &discord.SoundboardSoundParams{
+			Name:      sound.Name,
+			Sound:     soundURI(sound.ContentType, data),
+			Volume:    toPtr(sound.Volume),
+			EmojiID:   sound.EmojiID,
+			EmojiName: sound.EmojiName,
+		})
+		if err != nil {
+			return restored, skipped, unfit, fmt.Errorf("%w: restored %d of %d sounds", err, restored, len(pending))
+		}
+		tags := sound.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		if err := b.db.UpsertSound(ctx, db.Sound{
+			SoundID:   created.ID,
+			GuildID:   created.GuildID,
+			Name:      created.Name,
+			EmojiID:   created.EmojiID,
+			EmojiName: created.EmojiName,
+			Volume:    created.Volume,
+			UserID:    sound.UserID,
+			Added:     time.Now(),
+			Tags:      tags,
+		}); err != nil {
+			return restored, skipped, unfit, err
+		}
+		restored++
+	}
+	return restored, skipped, unfit, nil
+}
+
+// backupSuggestions suggests the backed up soundboards whose names match value, most recently backed up first.
+func (b *bot) backupSuggestions(value string) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+	soundboards, err := b.backedUpSoundboards()
+	if err != nil {
+		return nil, err
+	}
+	query := strings.ToLower(strings.TrimSpace(value))
+	type match struct {
+		id    discordgo.Snowflake
+		entry backupIndexEntry
+		score int
+	}
+	var matches []match
+	for id, entry := range soundboards {
+		score := 1
+		if query != "" {
+			score = fuzzyScore(query, entry.Name)
+		}
+		if score > 0 {
+			matches = append(matches, match{id: id, entry: entry, score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if !matches[i].entry.BackedUp.Equal(matches[j].entry.BackedUp) {
+			return matches[i].entry.BackedUp.After(matches[j].entry.BackedUp)
+		}
+		return matches[i].id < matches[j].id
+	})
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, m := range matches {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  fmt.Sprintf("%s (%d sounds, %s)", m.entry.Name, m.entry.Sounds, m.entry.BackedUp.Format(time.DateOnly)),
+			Value: string(m.id),
+		})
+	}
+	return choices, nil
+}
@@ -0,0 +1,71 @@
+package soundboard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// silentMP3 is a fraction of a second of silent MPEG-1 layer III, tagged with name so that sounds differ.
+func silentMP3(name string) []byte {
+	data := append([]byte("ID3\x04\x00\x00\x00\x00\x00"), byte(len(name)))
+	data = append(data, name...)
+	for i := 0; i < 10; i++ {
+		frame := make([]byte, 417)
+		copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// TestRestoreSoundboardCapacity restores a backup of a boosted soundboard into one without boosts, which only has
+// room for some of its sounds.
+func TestRestoreSoundboardCapacity(t *testing.T) {
+	e := newTestEnv(t, Config{BackupDir: t.TempDir()})
+	board := e.d.AddGuild("soundboardhost 2", e.admin.ID)
+	if err := e.d.Authorize(e.manager, board.ID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.db.UpsertSoundboard(context.Background(), board.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	backup := backupSoundboard{ID: "1", Name: "boosted"}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("sound %d", i)
+		sum, err := e.b.writeObject(silentMP3(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		backup.Sounds = append(backup.Sounds, backupSound{ID: discordgo.Snowflake(fmt.Sprint(100 + i)), Name: name, ContentType: "audio/mpeg", SHA256: sum})
+	}
+	created := time.Now().UTC()
+	content, err := json.Marshal(backupManifest{Created: created, Soundboards: []backupSoundboard{backup}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(e.b.backupDir, "manifests", created.Format(manifestTimeFormat)+".json"), content); err != nil {
+		t.Fatal(err)
+	}
+
+	i := e.d.Interact(e.manager, e.admin.ID, e.main.ID, restoreSoundboardCommand, opt("from", "boosted"), opt("to", "soundboardhost 2"))
+	waitFor(t, "restore", followupMatching(e.d, i, regexp.MustCompile(`Restored 8 sounds from "boosted".* 2 sounds didn't fit, as "soundboardhost 2" is full\.`)))
+	if got := len(e.d.Sounds(board.ID)); got != 8 {
+		t.Errorf("restored %d sounds, want 8", got)
+	}
+
+	// Once boosted, restoring again restores the rest.
+	if err := e.d.SetPremiumTier(board.ID, discordgo.PremiumTier1); err != nil {
+		t.Fatal(err)
+	}
+	i = e.d.Interact(e.manager, e.admin.ID, e.main.ID, restoreSoundboardCommand, opt("from", "boosted"), opt("to", "soundboardhost 2"))
+	waitFor(t, "restore the rest", followupMatching(e.d, i, regexp.MustCompile(`Restored 2 sounds from "boosted".* 8 sounds were already there\.$`)))
+	if got := len(e.d.Sounds(board.ID)); got != 10 {
+		t.Errorf("restored %d sounds in total, want 10", got)
+	}
+}
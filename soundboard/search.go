@@ -0,0 +1,286 @@
+package soundboard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kagadar/soundboardbot/db"
+)
+
+// Scores of the ways a query can match a sound's name, emoji or tag, best first. Prefix and substring matches lose
+// a point for each character before or after the query, so that closer matches rank higher.
+const (
+	exactScore       = 1000
+	prefixScore      = 900
+	wordPrefixScore  = 800
+	substringScore   = 700
+	subsequenceScore = 500
+	// Subsequences lose gapPenalty for each character skipped between matched characters, down to
+	// minSubsequenceScore.
+	gapPenalty          = 10
+	minSubsequenceScore = 350
+	// Misspellings lose typoPenalty for each edit the query is away from a word.
+	typoScore   = 300
+	typoPenalty = 50
+	// Tags rank just below names which match the same way.
+	tagPenalty = 50
+)
+
+// searchSounds returns the sounds in the inventory which match query, best first, or every sound if it is empty.
+// Sounds are matched by name, emoji and tags, with a few typos forgiven.
+func (b *bot) searchSounds(ctx context.Context, query string) (_ []db.Sound, err error) {
+	ctx, end := span(ctx, "search sounds", attribute.String("query", query))
+	defer func() { end(err) }()
+	sounds, err := b.db.ListSounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type match struct {
+		sound db.Sound
+		score int
+	}
+	var matches []match
+	for _, sound := range sounds {
+		if score := soundScore(query, sound); score > 0 {
+			matches = append(matches, match{sound: sound, score: score})
+		}
+	}
+	// Sounds are listed by name, which breaks ties.
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	out := make([]db.Sound, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, m.sound)
+	}
+	return out, nil
+}
+
+// soundScore scores how well query matches sound, which doesn't match at all if it is 0.
+func soundScore(query string, sound db.Sound) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return 1
+	}
+	if (sound.EmojiName != "" && query == sound.EmojiName) || (sound.EmojiID != "" && query == string(sound.EmojiID)) {
+		return exactScore
+	}
+	if m := customEmoji.FindStringSubmatch(query); m != nil && discordgo.Snowflake(m[1]) == sound.EmojiID {
+		return exactScore
+	}
+	score := fuzzyScore(query, sound.Name)
+	for _, tag := range sound.Tags {
+		if s := fuzzyScore(query, tag) - tagPenalty; s > score {
+			score = s
+		}
+	}
+	return max(score, 0)
+}
+
+// fuzzyScore scores how well query, which must be lower case, matches text, which doesn't match at all if it is 0.
+func fuzzyScore(query, text string) int {
+	text = strings.ToLower(text)
+	switch {
+	case text == query:
+		return exactScore
+	case strings.HasPrefix(text, query):
+		return prefixScore - (len(text) - len(query))
+	case strings.Contains(text, " "+query):
+		return wordPrefixScore - (len(text) - len(query))
+	}
+	if i := strings.Index(text, query); i >= 0 {
+		return substringScore - i
+	}
+	if gaps, ok := subsequence(query, text); ok {
+		return max(subsequenceScore-gapPenalty*gaps, minSubsequenceScore)
+	}
+	// Only misspellings which leave most of the query intact are forgiven.
+	allowed := len([]rune(query)) / 4
+	best := allowed + 1
+	for _, word := range append(strings.Fields(text), text) {
+		best = min(best, editDistance(query, word))
+	}
+	if best <= allowed {
+		return typoScore - typoPenalty*best
+	}
+	return 0
+}
+
+// subsequence reports whether query's characters appear in order in text, and how many characters are skipped
+// between the first and the last of them.
+func subsequence(query, text string) (int, bool) {
+	q := []rune(query)
+	if len(q) == 0 {
+		return 0, true
+	}
+	var gaps, matched int
+	started := false
+	for _, r := range text {
+		if r == q[matched] {
+			started = true
+			matched++
+			if matched == len(q) {
+				return gaps, true
+			}
+		} else if started {
+			gaps++
+		}
+	}
+	return 0, false
+}
+
+// editDistance returns how many characters must be inserted, deleted, substituted or swapped with their neighbour to
+// turn a into b.
+func editDistance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	// Only the last two rows are needed, to look back on swaps.
+	var prev2 []int
+	prev := make([]int, len(y)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		curr := make([]int, len(y)+1)
+		curr[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev = prev, curr
+	}
+	return prev[len(y)]
+}
+
+// soundSuggestions suggests the names of the sounds which best match what has been typed so far.
+func (b *bot) soundSuggestions(ctx context.Context, value string) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+	sounds, err := b.searchSounds(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	seen := map[string]bool{}
+	for _, sound := range sounds {
+		// Sounds with the same name in different soundboards would be the same suggestion.
+		if seen[sound.Name] {
+			continue
+		}
+		seen[sound.Name] = true
+		name := sound.Name
+		if sound.EmojiName != "" {
+			name = fmt.Sprintf("%s %s", sound.EmojiName, sound.Name)
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: sound.Name})
+		if len(choices) == maxSuggestions {
+			break
+		}
+	}
+	return choices, nil
+}
+
+// soundIDSuggestions suggests the IDs of the sounds which best match what has been typed so far, named along with
+// their soundboard so that sounds with the same name can be told apart.
+func (b *bot) soundIDSuggestions(ctx context.Context, value string) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+	sounds, err := b.searchSounds(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	guildNames := b.guildNames()
+	for _, sound := range sounds {
+		name := fmt.Sprintf("%s (%s)", sound.Name, guildNames[sound.GuildID])
+		if sound.EmojiName != "" {
+			name = sound.EmojiName + " " + name
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: string(sound.SoundID)})
+		if len(choices) == maxSuggestions {
+			break
+		}
+	}
+	return choices, nil
+}
+
+// soundboardSuggestions suggests the IDs of the soundboards whose names best match what has been typed so far.
+func (b *bot) soundboardSuggestions(ctx context.Context, value string) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+	counts, err := b.db.CountSounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := strings.ToLower(strings.TrimSpace(value))
+	type match struct {
+		choice *discordgo.ApplicationCommandOptionChoice
+		score  int
+	}
+	var matches []match
+	names := b.guildNames()
+	for _, guildID := range byAge(counts) {
+		name := names[guildID]
+		score := 1
+		if query != "" {
+			score = fuzzyScore(query, name)
+		}
+		if score > 0 {
+			matches = append(matches, match{
+				choice: &discordgo.ApplicationCommandOptionChoice{Name: fmt.Sprintf("%s (%d sounds)", name, counts[guildID]), Value: string(guildID)},
+				score:  score,
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, m := range matches {
+		choices = append(choices, m.choice)
+	}
+	return choices, nil
+}
+
+// tagSuggestions suggests the tags which best match what has been typed so far, most used first.
+func (b *bot) tagSuggestions(ctx context.Context, value string) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+	sounds, err := b.db.ListSounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, sound := range sounds {
+		for _, tag := range sound.Tags {
+			counts[tag]++
+		}
+	}
+	query := strings.ToLower(strings.TrimSpace(value))
+	type match struct {
+		tag   string
+		score int
+	}
+	var matches []match
+	for tag := range counts {
+		score := 1
+		if query != "" {
+			score = fuzzyScore(query, tag)
+		}
+		if score > 0 {
+			matches = append(matches, match{tag: tag, score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if counts[matches[i].tag] != counts[matches[j].tag] {
+			return counts[matches[i].tag] > counts[matches[j].tag]
+		}
+		return matches[i].tag < matches[j].tag
+	})
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, m := range matches {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: fmt.Sprintf("%s (%d sounds)", m.tag, counts[m.tag]), Value: m.tag})
+	}
+	return choices, nil
+}
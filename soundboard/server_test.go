@@ -0,0 +1,182 @@
+package soundboard
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/kagadar/soundboardbot/db"
+	"github.com/kagadar/soundboardbot/discord/fake"
+	"github.com/kagadar/soundboardbot/tokens"
+)
+
+// serverEnv is a fake Discord served over HTTP, which the bot reaches through its REST API and gateway as it would
+// the real one.
+type serverEnv struct {
+	d                *fake.Discord
+	creator, manager *fake.Session
+	admin            *discordgo.User
+	main             *discordgo.Guild
+	db               db.DB
+	url              string
+}
+
+func newServerEnv(t *testing.T) *serverEnv {
+	t.Helper()
+	e := &serverEnv{d: fake.New()}
+	srv := fake.NewServer(e.d)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	e.url = ts.URL
+	e.creator = srv.AddBot("creator", "ctok")
+	e.manager = srv.AddBot("manager", "mtok")
+	e.admin = e.d.AddUser("admin")
+	e.d.AddTemplate("tmpl", "Member", "DJ")
+	e.d.AddTemplate("other", "Listener")
+	e.main = e.d.AddGuild("main", e.admin.ID)
+	if err := e.d.Authorize(e.manager, e.main.ID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	database, err := db.New(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.db = database
+	return e
+}
+
+func (e *serverEnv) config() Config {
+	return Config{
+		DiscordURL:   e.url,
+		Admins:       []string{e.admin.Username},
+		CreatorToken: tokens.Static("ctok"),
+		ManagerToken: tokens.Static("mtok"),
+		ManagerAppId: e.manager.CurrentApplication().ID,
+		Template:     "tmpl",
+	}
+}
+
+func (e *serverEnv) start(t *testing.T, config Config) *bot {
+	t.Helper()
+	b, err := New(config, e.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b.(*bot)
+}
+
+func commandNames(commands []*discordgo.ApplicationCommand) []string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestServerHandover creates a soundboard with the creator and hands it over through the fake server, which relies
+// on the gateway delivering GuildCreate to the creator and GuildMemberAdd to the manager.
+func TestServerHandover(t *testing.T) {
+	e := newServerEnv(t)
+	e.start(t, e.config())
+	i := e.d.Interact(e.manager, e.admin.ID, "", createSoundboardCommand, opt("server_suffix", float64(3)))
+	code := waitFor(t, "invite", followupMatching(e.d, i, regexp.MustCompile(`discord\.gg/(\d+)`)))[1]
+	if err := e.d.JoinInvite(code, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	guildID := waitFor(t, "authorisation DM", func() (discordgo.Snowflake, bool) {
+		for _, m := range e.d.DirectMessages(e.admin.ID) {
+			if m := regexp.MustCompile(`guild_id=(\d+)`).FindStringSubmatch(m.Content); m != nil {
+				return discordgo.Snowflake(m[1]), true
+			}
+		}
+		return "", false
+	})
+	if err := e.d.Authorize(e.manager, guildID, e.admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "handover", func() (*discordgo.Guild, bool) {
+		g, _ := e.d.Guild(guildID)
+		return g, g.OwnerID == e.admin.ID && !hasMember(g, e.creator.CurrentUser().ID)
+	})
+	i = e.d.Interact(e.manager, e.admin.ID, e.main.ID, listServerCommand)
+	waitFor(t, "list-servers", followupMatching(e.d, i, regexp.MustCompile(`soundboardhost 3`)))
+}
+
+// TestServerTemplate loads the template's roles on start and on reload, and refuses to start without the template.
+func TestServerTemplate(t *testing.T) {
+	e := newServerEnv(t)
+	config := e.config()
+	config.Template = "missing"
+	if _, err := New(config, e.db); err == nil || !strings.Contains(err.Error(), `failed to load template "missing"`) {
+		t.Fatalf("New() with a missing template = %v, want a failure to load it", err)
+	}
+	b := e.start(t, e.config())
+	if got, want := b.current().roles.Elements(), []string{"DJ", "Member"}; !slices.Equal(sorted(got), want) {
+		t.Errorf("template roles = %v, want %v", got, want)
+	}
+	config = e.config()
+	config.Template = "other"
+	if err := b.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.current().roles.Elements(), []string{"Listener"}; !slices.Equal(sorted(got), want) {
+		t.Errorf("template roles after reload = %v, want %v", got, want)
+	}
+}
+
+// TestServerCommandRegistration registers commands through the fake server, both on start and without connecting
+// to the gateway, and re-registers them when commands are disabled on reload.
+func TestServerCommandRegistration(t *testing.T) {
+	e := newServerEnv(t)
+	app := e.manager.CurrentApplication().ID
+	config := e.config()
+	config.AdminGuilds = []discordgo.Snowflake{e.main.ID}
+	if err := RegisterCommands(config); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.d.Commands(app, "")) == 0 || len(e.d.Commands(app, e.main.ID)) == 0 {
+		t.Fatalf("RegisterCommands() registered %v globally and %v in the admin guild, want both",
+			commandNames(e.d.Commands(app, "")), commandNames(e.d.Commands(app, e.main.ID)))
+	}
+	// Starting without admin guilds moves every command back to global, but only register-commands clears them from the
+	// former admin guild.
+	b := e.start(t, e.config())
+	var want []string
+	for name := range b.commands {
+		want = append(want, name)
+	}
+	slices.Sort(want)
+	if got := commandNames(e.d.Commands(app, "")); !slices.Equal(got, want) {
+		t.Errorf("global commands = %v, want %v", got, want)
+	}
+	if got := commandNames(e.d.Commands(app, e.main.ID)); len(got) == 0 {
+		t.Error("start cleared the former admin guild's commands")
+	}
+	if err := RegisterCommands(e.config()); err != nil {
+		t.Fatal(err)
+	}
+	if got := commandNames(e.d.Commands(app, e.main.ID)); len(got) != 0 {
+		t.Errorf("former admin guild still has commands %v", got)
+	}
+	config = e.config()
+	config.DisabledCommands = []string{fixRolesCommand}
+	if err := b.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if got := commandNames(e.d.Commands(app, "")); slices.Contains(got, fixRolesCommand) || len(got) != len(want)-1 {
+		t.Errorf("global commands after disabling %q = %v", fixRolesCommand, got)
+	}
+}
+
+func sorted(s []string) []string {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	return s
+}
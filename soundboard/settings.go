@@ -0,0 +1,96 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-set"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/tokens"
+)
+
+var (
+	ErrCommandDisabled = errors.New("command is disabled")
+)
+
+// settings are the parts of Config which can be changed by Reload.
+// Once created, settings are never modified, so they can be read without holding b.settingsMu.
+type settings struct {
+	admins   set.Set[string]
+	disabled set.Set[string]
+	template string
+	// creatorToken and managerToken are re-read periodically, see watchTokens.
+	creatorToken tokens.Source
+	managerToken tokens.Source
+	// dashboardSecret is read each time an admin signs in to the dashboard.
+	dashboardSecret tokens.Source
+	// roles are the names of the roles in template, other than @everyone.
+	roles set.Set[string]
+	// overflowOwner is given the soundboards created when every other is full, see overflow.
+	overflowOwner  discordgo.Snowflake
+	maxSoundboards int
+}
+
+// loadSettings builds the settings for config, loading role names from its template.
+func (b *bot) loadSettings(config Config) (settings, error) {
+	s := settings{
+		admins:       set.New(config.Admins...),
+		disabled:     set.New(config.DisabledCommands...),
+		template:     config.Template,
+		creatorToken: config.CreatorToken,
+		managerToken: config.ManagerToken,
+		roles:        set.New[string](),
+		// Read each time an admin signs in, so it isn't checked here.
+		dashboardSecret: config.DashboardClientSecret,
+		overflowOwner:   config.OverflowOwner,
+		maxSoundboards:  config.MaxSoundboards,
+	}
+	template, err := b.manager.GuildTemplate(s.template)
+	if err != nil {
+		return settings{}, fmt.Errorf("failed to load template %q: %w", s.template, err)
+	}
+	for _, role := range template.SerializedSourceGuild.Roles {
+		if role.Name != "@everyone" {
+			s.roles.Put(role.Name)
+		}
+	}
+	for _, name := range config.DisabledCommands {
+		if _, ok := b.commands[name]; !ok {
+			return settings{}, fmt.Errorf("%w: cannot disable %q", ErrUnknownCommand, name)
+		}
+	}
+	return s, nil
+}
+
+// current returns the settings in effect.
+func (b *bot) current() settings {
+	b.settingsMu.RLock()
+	defer b.settingsMu.RUnlock()
+	return b.settings
+}
+
+// Reload applies the admins, template, rate limits, disabled commands, token sources, dashboard client secret
+// source, overflow owner and maximum number of soundboards from config.
+// Application commands are re-registered if their options or availability have changed, and sessions are
+// reconnected if their tokens have changed. Everything else in config requires a restart.
+func (b *bot) Reload(config Config) error {
+	s, err := b.loadSettings(config)
+	if err != nil {
+		return err
+	}
+	b.settingsMu.Lock()
+	b.settings = s
+	b.settingsMu.Unlock()
+	b.limiter.configure(config.RateLimits)
+	if err := b.refreshTokens(context.Background()); err != nil {
+		return err
+	}
+	if err := b.registerCommands(); err != nil {
+		return err
+	}
+	klog.Infof("reloaded config: %d admins, template %q, %d rate limits, disabled commands %v", len(config.Admins), s.template, len(config.RateLimits), config.DisabledCommands)
+	return nil
+}
@@ -0,0 +1,173 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kagadar/go-syncmap"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// shutdownGracePeriod is how long in-flight commands have to finish, which should be well within the time
+	// given to the bot to stop (30s by default under Kubernetes).
+	shutdownGracePeriod = time.Second * 20
+	// shutdownCancelPeriod is how long cancelled commands have to report that they were interrupted.
+	shutdownCancelPeriod = time.Second * 5
+)
+
+var (
+	ErrShuttingDown = errors.New("the bot is restarting, please try again shortly")
+)
+
+// tracker counts the work in progress, so that shutdown can wait for it to finish.
+type tracker struct {
+	mu      sync.Mutex
+	stopped bool
+	active  int
+	idle    chan struct{}
+}
+
+// begin records the start of new work, unless the tracker has been stopped.
+func (t *tracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.active++
+	return true
+}
+
+// extend records work started by work which is already in progress, which is allowed even once stopped.
+func (t *tracker) extend() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active++
+}
+
+func (t *tracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.stopped && t.active == 0 {
+		close(t.idle)
+	}
+}
+
+// stop prevents new work from beginning, and returns a channel which is closed once all work has ended.
+func (t *tracker) stop() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return t.idle
+	}
+	t.stopped = true
+	t.idle = make(chan struct{})
+	if t.active == 0 {
+		close(t.idle)
+	}
+	return t.idle
+}
+
+// shuttingDown reports whether Close has been called.
+func (b *bot) shuttingDown() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the channel to close, for at most timeout. It reports whether the channel closed.
+func wait(ch <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// turnAway tells the user calling a command during shutdown to try again later.
+func (b *bot) turnAway(ctx context.Context, interaction *discordgo.Interaction) error {
+	return b.respond(ctx, interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "The bot is restarting, please try again shortly.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// cancelPending fails every pending invite or transfer, so that the commands waiting on them can finish.
+func cancelPending(name string, pending *syncmap.Map[discordgo.Snowflake, pendingInvite]) {
+	var guilds []discordgo.Snowflake
+	pending.Range(func(guildID discordgo.Snowflake, _ pendingInvite) bool {
+		guilds = append(guilds, guildID)
+		return true
+	})
+	for _, guildID := range guilds {
+		// Whoever removes the entry is the only one to complete it, see grantAutoRoles and transferServer.
+		if p, ok := pending.LoadAndDelete(guildID); ok {
+			klog.FromContext(p.ctx).Info("Cancelling pending "+name, "pendingGuildID", guildID)
+			p.done <- ErrShuttingDown
+		}
+	}
+}
+
+// Close shuts the bot down. New interactions are turned away, and pending invites, transfers and jobs are
+// cancelled, which their users are told about. In-flight commands are given shutdownGracePeriod to finish before
+// they too are cancelled, after which both sessions and the DB are closed.
+func (b *bot) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		klog.Info("shutting down")
+		idle := b.inflight.stop()
+		close(b.closed)
+		if b.interactions != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), interactionResponseDeadline)
+			if err := b.interactions.Shutdown(ctx); err != nil {
+				klog.Errorf("%v: failed to stop interactions server", err)
+			}
+			cancel()
+		}
+		if b.dashboard != nil {
+			// Actions already started are in-flight commands, which are waited on below.
+			if err := b.dashboard.Close(); err != nil {
+				klog.Errorf("%v: failed to stop dashboard", err)
+			}
+		}
+		cancelPending("invite", &b.creatorInvites)
+		cancelPending("invite", &b.managerInvites)
+		cancelPending("transfer", &b.transfers)
+		b.jobs.Range(func(_ discordgo.Snowflake, j *job) bool {
+			klog.InfoS("Cancelling job", "correlationID", j.id, "command", j.command)
+			j.cancel()
+			return true
+		})
+		if !wait(idle, shutdownGracePeriod) {
+			klog.Warningf("commands still running after %v, cancelling them", shutdownGracePeriod)
+			b.cancel()
+			if !wait(idle, shutdownCancelPeriod) {
+				klog.Warningf("commands still running after being cancelled, abandoning them")
+			}
+		}
+		b.cancel()
+		err = errors.Join(b.creator.Close(), b.manager.Close())
+		if b.db != nil {
+			err = errors.Join(err, b.db.Close())
+		}
+		if b.monitoring != nil {
+			if err := b.monitoring.Close(); err != nil {
+				klog.Errorf("%v: failed to stop monitoring server", err)
+			}
+		}
+		klog.Info("shut down")
+	})
+	return err
+}
@@ -0,0 +1,95 @@
+package soundboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"k8s.io/klog/v2"
+
+	"github.com/kagadar/soundboardbot/discord"
+	"github.com/kagadar/soundboardbot/tokens"
+)
+
+const (
+	tokenRefreshInterval = time.Minute
+)
+
+// sessionToken is a session along with the token it is connected with, so that it can be reconnected when its
+// token is rotated.
+type sessionToken struct {
+	name    string
+	session discord.Session
+
+	mu    sync.Mutex
+	token string
+}
+
+func newSessionToken(ctx context.Context, name string, source tokens.Source) (*sessionToken, error) {
+	if source == nil {
+		return nil, fmt.Errorf("%w for %s", tokens.ErrNoToken, name)
+	}
+	token, err := source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s token: %w", name, err)
+	}
+	// Soundboard events are sent under the guild emojis intent, which Discord now calls guild expressions.
+	session, err := discord.New(token, discordgo.IntentGuildMembers|discordgo.IntentGuildEmojis, instrumentedTransport{bot: name, next: http.DefaultTransport})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s session: %w", name, err)
+	}
+	return &sessionToken{name: name, session: session, token: token}, nil
+}
+
+// refresh reads the token from source, and reconnects the session if it has changed.
+func (t *sessionToken) refresh(ctx context.Context, source tokens.Source) error {
+	if source == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token, err := source.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read %s token: %w", t.name, err)
+	}
+	if token == t.token {
+		return nil
+	}
+	klog.Infof("%s token has changed, reconnecting", t.name)
+	if err := t.session.Reconnect(token); err != nil {
+		return fmt.Errorf("failed to reconnect %s: %w", t.name, err)
+	}
+	t.token = token
+	return nil
+}
+
+// refreshTokens reconnects any session whose token has been rotated.
+func (b *bot) refreshTokens(ctx context.Context) error {
+	s := b.current()
+	return errors.Join(
+		b.creatorToken.refresh(ctx, s.creatorToken),
+		b.managerToken.refresh(ctx, s.managerToken),
+	)
+}
+
+// watchTokens periodically refreshes the bot's tokens until the bot is closed.
+func (b *bot) watchTokens() {
+	ticker := time.NewTicker(tokenRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), tokenRefreshInterval)
+			if err := b.refreshTokens(ctx); err != nil {
+				klog.Error(err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package soundboard
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+var tracer = otel.Tracer("github.com/kagadar/soundboardbot/soundboard")
+
+// span starts a span named name as a child of any span in ctx. The returned func ends it, recording err if it
+// isn't nil.
+func span(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx, s := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		endSpan(s, err)
+	}
+}
+
+// step starts a span for one step of a command, and adds the step to ctx's logger.
+func step(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx = klog.NewContext(ctx, klog.FromContext(ctx).WithValues("step", name))
+	return span(ctx, name, attrs...)
+}
+
+func endSpan(s trace.Span, err error) {
+	if err != nil {
+		s.RecordError(err)
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
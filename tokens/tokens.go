@@ -0,0 +1,99 @@
+// Package tokens reads bot tokens from files, the environment or secret providers, so that they never need to be
+// passed on the command line.
+//
+// Tokens are read each time they are needed, so that a Source notices when its token is rotated.
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown token provider")
+	ErrNoToken         = errors.New("no token")
+)
+
+// Source provides a bot token.
+type Source interface {
+	// Token returns the current token, which may change between calls if it is rotated.
+	Token(ctx context.Context) (string, error)
+}
+
+// Provider creates a Source from a reference, which means whatever the provider needs it to, such as a secret's name.
+type Provider func(ref string) (Source, error)
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"env":  func(ref string) (Source, error) { return Env(ref), nil },
+		"file": func(ref string) (Source, error) { return File(ref), nil },
+	}
+)
+
+// Register makes a Provider available to Parse as scheme, such as one which reads from a secret manager.
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = provider
+}
+
+// Parse returns the Source described by spec, which is one of:
+//
+//	file:<path>      the token is the content of the file at path
+//	env:<variable>   the token is the value of the environment variable
+//	<scheme>:<ref>   the token comes from the Provider registered as scheme
+//	<token>          the token itself, which Discord tokens can be told apart from since they never contain a colon
+func Parse(spec string) (Source, error) {
+	scheme, ref, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Static(spec), nil
+	}
+	mu.RLock()
+	provider, ok := providers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, scheme)
+	}
+	return provider(ref)
+}
+
+// Static is a token which never changes.
+type Static string
+
+func (s Static) Token(context.Context) (string, error) {
+	if s == "" {
+		return "", ErrNoToken
+	}
+	return string(s), nil
+}
+
+// Env reads the token from an environment variable.
+type Env string
+
+func (e Env) Token(context.Context) (string, error) {
+	token := strings.TrimSpace(os.Getenv(string(e)))
+	if token == "" {
+		return "", fmt.Errorf("%w: $%s is not set", ErrNoToken, string(e))
+	}
+	return token, nil
+}
+
+// File reads the token from a file, such as a mounted secret, ignoring surrounding whitespace.
+type File string
+
+func (f File) Token(context.Context) (string, error) {
+	content, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("%w: %q is empty", ErrNoToken, string(f))
+	}
+	return token, nil
+}
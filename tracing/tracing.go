@@ -0,0 +1,93 @@
+// Package tracing exports OpenTelemetry traces for the bot, to a collector or to stdout for offline debugging.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+const (
+	serviceName = "soundboardbot"
+)
+
+var (
+	ErrUnknownExporter = errors.New("unknown trace exporter")
+)
+
+// Setup starts exporting traces as described by spec, which is one of:
+//
+//	(empty)           traces are not exported
+//	stdout            traces are written to stdout as JSON
+//	stdout:<path>     traces are written to the file at path as JSON
+//	otlp              traces are sent over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables
+//	otlp:<host:port>  traces are sent over OTLP/HTTP to a local collector, without TLS
+//
+// The returned func flushes any buffered spans and stops exporting.
+func Setup(ctx context.Context, spec string) (func(context.Context) error, error) {
+	if spec == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, closer, err := newExporter(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closer.Close())
+	}, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func newExporter(ctx context.Context, spec string) (sdktrace.SpanExporter, io.Closer, error) {
+	kind, ref, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "stdout":
+		if ref == "" {
+			exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+			return exporter, nopCloser{}, err
+		}
+		f, err := os.OpenFile(ref, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	case "otlp":
+		var options []otlptracehttp.Option
+		if ref != "" {
+			options = append(options, otlptracehttp.WithEndpoint(ref), otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, nopCloser{}, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnknownExporter, kind)
+	}
+}